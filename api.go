@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -33,15 +34,172 @@ func handleAPIPreflight(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAPIGameTypes(w http.ResponseWriter, r *http.Request) {
-	gt := fetchGameTypes()
+	gt := dataStore.FetchGameTypes()
 	writeAPIResponse(w, r, gt)
 }
 
+func handleAPISeasons(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	seasons, err := dataStore.FetchSeasons(gt.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAPIResponse(w, r, seasons)
+}
+
+func handleAPIUpsets(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	type UpsetJSON struct {
+		Match       string    `json:"match"`
+		Tournament  string    `json:"tournament"`
+		Date        time.Time `json:"date"`
+		Winner      string    `json:"winner"`
+		Loser       string    `json:"loser"`
+		WinnerScore int       `json:"winnerScore"`
+		LoserScore  int       `json:"loserScore"`
+		UpsetRating int       `json:"upsetRating"`
+		UpsetSeed   int       `json:"upsetSeed"`
+	}
+
+	result := []UpsetJSON{}
+	for _, m := range fetchUpsetsFeed(gt) {
+		u := UpsetJSON{
+			Match:       m.ID,
+			Tournament:  m.Tournament,
+			Date:        m.Date,
+			Winner:      m.Winner(),
+			Loser:       m.Loser(),
+			WinnerScore: m.Player1score,
+			LoserScore:  m.Player2score,
+			UpsetRating: m.UpsetRating,
+			UpsetSeed:   m.UpsetSeed,
+		}
+		if u.Winner == m.Player2 {
+			u.WinnerScore, u.LoserScore = m.Player2score, m.Player1score
+		}
+		result = append(result, u)
+	}
+	writeAPIResponse(w, r, result)
+}
+
+func handleAPISeasonRankings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s, err := dataStore.FetchSeason(vars["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	gt, err := dataStore.FetchGameType(s.GameType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filter := rankingFilterFor(gt)
+	readRankingFilter(r, &filter)
+	ranks, err := fetchSeasonRankings(gt, s, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		Season *Season    `json:"season"`
+		Ranks  []RankJSON `json:"ranks"`
+	}{s, ranksJSON(ranks)}
+	writeAPIResponse(w, r, result)
+}
+
+func handleAPIRegions(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, r, dataStore.FetchRegions())
+}
+
+func handleAPIRegionRankings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rg, err := dataStore.FetchRegionByURLPath(vars["region"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	gametype := r.FormValue("gametype")
+	if gametype == "" {
+		http.Error(w, "gametype is required", http.StatusBadRequest)
+		return
+	}
+	gt, err := dataStore.FetchGameType(gametype)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	filter := rankingFilterFor(gt)
+	readRankingFilter(r, &filter)
+	ranks, err := fetchRegionRankings(gt, rg, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		Region *Region    `json:"region"`
+		Ranks  []RankJSON `json:"ranks"`
+	}{rg, ranksJSON(ranks)}
+	writeAPIResponse(w, r, result)
+}
+
+func handleAPICircuits(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, r, dataStore.FetchCircuits())
+}
+
+func handleAPICircuitStandings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c, err := dataStore.FetchCircuitByURLPath(vars["circuit"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	standings, err := fetchCircuitStandings(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAPIResponse(w, r, standings)
+}
+
+type RankJSON struct {
+	Player    Player  `json:"player"`
+	Rating    int     `json:"rating"`
+	Deviation float64 `json:"deviation"`
+}
+
+func ranksJSON(ranks []*EloDict) []RankJSON {
+	result := []RankJSON{}
+	for _, rank := range ranks {
+		result = append(result, RankJSON{rank.Player, rank.Rank, rank.Deviation})
+	}
+	return result
+}
+
 func handleAPIPlayer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playerID := vars["id"]
 
-	p, err := fetchPlayer(playerID)
+	p, err := dataStore.FetchPlayer(playerID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -50,13 +208,13 @@ func handleAPIPlayer(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAPIPlayers(w http.ResponseWriter, r *http.Request) {
-	p := fetchPlayers()
+	p := dataStore.FetchPlayers()
 	writeAPIResponse(w, r, p)
 }
 
 func handleAPIPlayersSearch(w http.ResponseWriter, r *http.Request) {
 	search := r.FormValue("query")
-	p, err := fetchPlayersSearch(search)
+	p, err := dataStore.FetchPlayersSearch(search)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -70,15 +228,16 @@ func handleAPIPlayerTournamentResults(w http.ResponseWriter, r *http.Request) {
 
 	gameType := r.FormValue("gametype")
 
-	rs, err := fetchResultsForPlayer(playerID)
+	rs, err := dataStore.FetchResultsForPlayer(playerID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
 	type ResultJSON struct {
-		Seed       int         `json:"seed"`
-		Place      int         `json:"place"`
-		Tournament *Tournament `json:"tournament"`
+		Seed            int         `json:"seed"`
+		Place           int         `json:"place"`
+		SeedPerformance *int        `json:"seedPerformance,omitempty"`
+		Tournament      *Tournament `json:"tournament"`
 	}
 
 	results := []ResultJSON{}
@@ -86,23 +245,50 @@ func handleAPIPlayerTournamentResults(w http.ResponseWriter, r *http.Request) {
 		if gameType != "" && gameType != result.Tournament.GameType {
 			continue
 		}
-		results = append(results, ResultJSON{
+		rj := ResultJSON{
 			Seed:       result.Seed,
 			Place:      result.Place,
 			Tournament: result.Tournament,
-		})
+		}
+		if result.HasSeedPerformance() {
+			spr := result.SeedPerformance()
+			rj.SeedPerformance = &spr
+		}
+		results = append(results, rj)
 	}
 
 	writeAPIResponse(w, r, results)
 }
 
+func handleAPIPlayerSeedPerformance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	playerID := vars["id"]
+
+	gameType := r.FormValue("gametype")
+
+	rs, err := dataStore.FetchResultsForPlayer(playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summary := &SeedPerformanceSummary{}
+	for _, result := range rs {
+		if gameType != "" && gameType != result.Tournament.GameType {
+			continue
+		}
+		summary.Add(result)
+	}
+	writeAPIResponse(w, r, summary)
+}
+
 func handleAPIPlayerMatches(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playerID := vars["id"]
 
 	gametype := r.FormValue("gametype")
 
-	matches := fetchMatchesForPlayer(playerID, false)
+	matches := dataStore.FetchMatchesForPlayer(playerID, false)
 
 	filterMatches := []Match{}
 	for _, m := range matches {
@@ -114,6 +300,41 @@ func handleAPIPlayerMatches(w http.ResponseWriter, r *http.Request) {
 	writeAPIResponse(w, r, filterMatches)
 }
 
+func handleAPIPlayerRatings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	playerID := vars["id"]
+
+	gametype := r.FormValue("gametype")
+	if gametype == "" {
+		http.Error(w, "gametype is required", http.StatusBadRequest)
+		return
+	}
+
+	h, err := fetchRatingHistory(gametype, playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		*RatingHistory
+		RatingAt *int `json:"ratingAt,omitempty"`
+	}{RatingHistory: h}
+
+	if date := r.FormValue("date"); date != "" {
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		// The rating at a date includes every match played that day.
+		rating := h.RatingAt(t.AddDate(0, 0, 1).Add(-time.Nanosecond))
+		result.RatingAt = &rating
+	}
+
+	writeAPIResponse(w, r, result)
+}
+
 func handleAPIFaceoff(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	p1 := vars["p1"]
@@ -121,7 +342,7 @@ func handleAPIFaceoff(w http.ResponseWriter, r *http.Request) {
 
 	gametype := r.FormValue("gametype")
 
-	matches := fetchMatchesForPlayers(p1, p2, false)
+	matches := dataStore.FetchMatchesForPlayers(p1, p2, false)
 
 	filterMatches := []Match{}
 	for _, m := range *matches {
@@ -132,3 +353,42 @@ func handleAPIFaceoff(w http.ResponseWriter, r *http.Request) {
 	}
 	writeAPIResponse(w, r, filterMatches)
 }
+
+func handleAPISeeding(w http.ResponseWriter, r *http.Request) {
+	gametype := r.FormValue("gametype")
+	if gametype == "" {
+		http.Error(w, "gametype is required", http.StatusBadRequest)
+		return
+	}
+	gt, err := dataStore.FetchGameType(gametype)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeAPIResponse(w, r, generateSeeding(gt, readEntrants(r.FormValue("entrants"))))
+}
+
+func handleAPIPredict(w http.ResponseWriter, r *http.Request) {
+	p1 := r.FormValue("p1")
+	p2 := r.FormValue("p2")
+	gametype := r.FormValue("gametype")
+	if p1 == "" || p2 == "" || gametype == "" {
+		http.Error(w, "p1, p2 and gametype are required", http.StatusBadRequest)
+		return
+	}
+
+	gt, err := dataStore.FetchGameType(gametype)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	for _, id := range []string{p1, p2} {
+		if _, err := dataStore.FetchPlayer(id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	writeAPIResponse(w, r, predictMatch(gt, p1, p2, readBestOf(r.FormValue("bestof"))))
+}
@@ -0,0 +1,45 @@
+$(function() {
+  var width = 600, height = 150, pad = 10;
+
+  function drawChart(el, timeline) {
+    if (timeline.length < 1) return;
+    var ratings = [timeline[0].Before].concat(timeline.map(function(c) { return c.After; }));
+    var min = Math.min.apply(null, ratings);
+    var max = Math.max.apply(null, ratings);
+    var span = Math.max(max - min, 1);
+    var step = (width - 2 * pad) / (ratings.length - 1);
+    var points = ratings.map(function(r, i) {
+      var x = pad + i * step;
+      var y = pad + (max - r) / span * (height - 2 * pad);
+      return x.toFixed(1) + ',' + y.toFixed(1);
+    });
+    el.html(
+      '<svg width="' + width + '" height="' + height + '" viewBox="0 0 ' + width + ' ' + height + '">' +
+      '<text x="0" y="' + pad + '" font-size="10" fill="#999">' + max + '</text>' +
+      '<text x="0" y="' + (height - 2) + '" font-size="10" fill="#999">' + min + '</text>' +
+      '<polyline fill="none" stroke="#337ab7" stroke-width="2" points="' + points.join(' ') + '"/>' +
+      '</svg>');
+  }
+
+  $('.rating-history').each(function() {
+    var history = $(this);
+    var url = '/api/v1/players/' + history.data('player') + '/ratings?gametype=' +
+      encodeURIComponent(history.data('gametype'));
+
+    $.getJSON(url, function(res) {
+      drawChart(history.find('.rating-chart'), res.timeline);
+    });
+
+    history.find('.rating-at input').on('change', function() {
+      var date = $(this).val();
+      var value = history.find('.rating-at-value');
+      if (!date) {
+        value.text('');
+        return;
+      }
+      $.getJSON(url + '&date=' + date, function(res) {
+        value.text(res.ratingAt);
+      });
+    });
+  });
+});
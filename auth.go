@@ -5,9 +5,8 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/boj/rethinkstore"
+	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 type User struct {
@@ -26,11 +25,13 @@ func (u User) HasPermission(p int) bool {
 	return u.PermissionLevel&p != 0
 }
 
-var sessionStore *rethinkstore.RethinkStore
+type ByEmail []User
 
-func getUserTable() r.Term {
-	return r.Table("users")
-}
+func (a ByEmail) Len() int           { return len(a) }
+func (a ByEmail) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByEmail) Less(i, j int) bool { return a[i].Email < a[j].Email }
+
+var sessionStore sessions.Store
 
 func getPermissionLevels() PermissionLevels {
 	return PermissionLevels{
@@ -43,67 +44,25 @@ func getMaxPermissionLevel() int {
 }
 
 func initializeSessionStore() {
-	store, err := rethinkstore.NewRethinkStore(siteConfiguration.RethinkConnection, "velvetdb", "sessions", 5, 5,
-		[]byte(siteConfiguration.CookieKey))
+	store, err := dataStore.NewSessionStore([]byte(siteConfiguration.CookieKey))
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
 	sessionStore = store
 }
 
-func addUser(user User) string {
-	resp, err := getUserTable().Insert(&user).RunWrite(dataStore.GetSession())
-	if err != nil {
-		fmt.Println(err)
-	}
-	return resp.GeneratedKeys[0]
-}
-
 func updateUserPassword(email string, newPassword string) error {
 	p, err := generatePassword(newPassword)
 	if err != nil {
 		return err
 	}
 
-	_, err = getUserTable().Filter(map[string]interface{}{
-		"email": email,
-	}).Update(map[string]interface{}{
-		"password": p,
-	}).RunWrite(dataStore.GetSession())
-
-	return err
-}
-
-func fetchUserByEmail(email string) *User {
-	c, err := getUserTable().Filter(map[string]interface{}{
-		"email": email,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	var user User
-	err = c.One(&user)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	return &user
-}
-
-func fetchUsers() []User {
-	c, err := getUserTable().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-	var u []User
-	err = c.All(&u)
-	if err != nil {
-		fmt.Println(err)
+	user := dataStore.FetchUserByEmail(email)
+	if user == nil {
+		return fmt.Errorf("no user with email %s", email)
 	}
-	return u
+	user.Password = p
+	return dataStore.UpdateUser(user)
 }
 
 func generatePassword(password string) (string, error) {
@@ -115,7 +74,7 @@ func generatePassword(password string) (string, error) {
 }
 
 func validateUser(email string, password string) bool {
-	user := fetchUserByEmail(email)
+	user := dataStore.FetchUserByEmail(email)
 	if user == nil {
 		return false
 	}
@@ -124,13 +83,13 @@ func validateUser(email string, password string) bool {
 }
 
 func registerUser(email string, password string, permissionLevel int) {
-	user := fetchUserByEmail(email)
+	user := dataStore.FetchUserByEmail(email)
 	if user == nil {
 		p, err := generatePassword(password)
 		if err != nil {
 			fmt.Println(err)
 		} else {
-			addUser(User{
+			dataStore.AddUser(User{
 				Email:           email,
 				Password:        p,
 				PermissionLevel: permissionLevel,
@@ -159,7 +118,7 @@ func saveRegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, r, "register", data)
 		return
 	}
-	if fetchUserByEmail(e) != nil {
+	if dataStore.FetchUserByEmail(e) != nil {
 		data.Message = "User already exists."
 		renderTemplate(w, r, "register", data)
 		return
@@ -236,7 +195,7 @@ func userProfileHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func userListHandler(w http.ResponseWriter, r *http.Request) {
-	u := fetchUsers()
+	u := dataStore.FetchUsers()
 	data := struct {
 		Users []User
 	}{
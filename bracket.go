@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dguenther/go-bracket"
+)
+
+// BracketProvider fetches brackets from a bracket site. Every provider turns
+// its site's brackets into go-bracket's types, which is what the importer
+// works with.
+type BracketProvider interface {
+	// Handles reports whether a bracket URL is on the provider's site.
+	Handles(url string) bool
+	FetchBracket(url string) (*bracket.Bracket, error)
+	// FetchPools returns the brackets that feed into a bracket, like the
+	// pools of an event's earlier phases.
+	FetchPools(url string) ([]ExternalPool, error)
+}
+
+// ExternalPool is a bracket on a bracket site that's a pool of another one.
+type ExternalPool struct {
+	Name string
+	URL  string
+}
+
+// challongeProvider fetches brackets through go-bracket. It handles any URL
+// no other provider does.
+type challongeProvider struct{}
+
+func (challongeProvider) Handles(url string) bool {
+	return true
+}
+
+func (challongeProvider) FetchBracket(url string) (*bracket.Bracket, error) {
+	client := bracket.NewClient(siteConfiguration.ChallongeDevUsername, siteConfiguration.ChallongeApiKey)
+	return client.FetchBracket(url)
+}
+
+func (challongeProvider) FetchPools(url string) ([]ExternalPool, error) {
+	return nil, nil
+}
+
+// bracketProviderFor picks the provider for a bracket URL.
+func bracketProviderFor(url string) BracketProvider {
+	providers := []BracketProvider{
+		newStartGGProvider(siteConfiguration.StartGGEndpoint, siteConfiguration.StartGGApiKey),
+		challongeProvider{},
+	}
+	for _, p := range providers {
+		if p.Handles(url) {
+			return p
+		}
+	}
+	return challongeProvider{}
+}
+
+// fetchExternalBracket fetches a bracket from whichever site it's on.
+func fetchExternalBracket(url string) (*bracket.Bracket, error) {
+	b, err := bracketProviderFor(url).FetchBracket(url)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch the bracket at %s: %v", url, err)
+	}
+	if b == nil {
+		return nil, fmt.Errorf("couldn't find a bracket at %s", url)
+	}
+	return b, nil
+}
+
+// startedBracket fetches a bracket that's being added as a tournament, which
+// takes its dates from the bracket. The status to respond with is returned
+// along with any error.
+func startedBracket(url string) (*bracket.Bracket, int, error) {
+	b, err := fetchExternalBracket(url)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	if b.StartedAt == nil || b.UpdatedAt == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("the bracket at %s hasn't started yet", url)
+	}
+	return b, http.StatusOK, nil
+}
+
+// addExternalPools adds the pools of a tournament's bracket as pools of the
+// tournament, ready for their matches to be imported.
+func addExternalPools(t *Tournament) {
+	pools, err := bracketProviderFor(t.BracketURL).FetchPools(t.BracketURL)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, p := range pools {
+		if _, err := dataStore.FetchTournamentByBracketURL(p.URL); err == nil {
+			continue
+		}
+		b, _, err := startedBracket(p.URL)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		dataStore.AddTournament(Tournament{
+			Name:        t.Name + " - " + p.Name,
+			BracketURL:  p.URL,
+			PoolOf:      t.ID,
+			GameType:    t.GameType,
+			Tier:        t.Tier,
+			City:        t.City,
+			State:       t.State,
+			Location:    t.Location,
+			DateStart:   *b.StartedAt,
+			DateEnd:     *b.UpdatedAt,
+			PlayerCount: len(b.Players),
+			Editing:     true,
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+const (
+	bracketMatchWidth  = 180
+	bracketMatchHeight = 44
+	bracketColumnGap   = 40
+	bracketRowGap      = 12
+	bracketLabelHeight = 24
+	// bracketTextPadding is the space between a match's text and its edges.
+	bracketTextPadding = 6
+	// bracketNameLength is how much of a player's nickname fits in a match.
+	bracketNameLength = 18
+)
+
+// bracketBox is where the template draws a match's box and text.
+var bracketBox = BracketBox{
+	Width:    bracketMatchWidth,
+	Height:   bracketMatchHeight,
+	Middle:   bracketMatchHeight / 2,
+	TextX:    bracketTextPadding,
+	ScoreX:   bracketMatchWidth - bracketTextPadding,
+	Player1Y: bracketMatchHeight/2 - bracketTextPadding,
+	Player2Y: bracketMatchHeight - bracketTextPadding,
+	LabelY:   bracketLabelHeight - bracketTextPadding,
+}
+
+// BracketTree is a tournament's matches laid out as brackets to draw, from the
+// links between each match and the ones its players came from.
+type BracketTree struct {
+	Name     string
+	Sections []*BracketSection
+}
+
+// BracketSection is one side of a bracket, winners or losers.
+type BracketSection struct {
+	Name    string
+	Width   int
+	Height  int
+	Box     BracketBox
+	Rounds  []*BracketColumn
+	Matches []*BracketMatch
+	Lines   []*BracketLine
+}
+
+// BracketBox is the size of a match's box, and where its names, scores and
+// the round labels above it go.
+type BracketBox struct {
+	Width    int
+	Height   int
+	Middle   int
+	TextX    int
+	ScoreX   int
+	Player1Y int
+	Player2Y int
+	LabelY   int
+}
+
+// BracketColumn labels a round's column.
+type BracketColumn struct {
+	Name string
+	X    int
+}
+
+// BracketMatch is a match and where it's drawn.
+type BracketMatch struct {
+	Match
+	Player1Name string
+	Player2Name string
+	X           int
+	Y           float64
+
+	column   int
+	children []*BracketMatch
+}
+
+// BracketLine joins a match to the match its winner or loser played next.
+type BracketLine struct {
+	X1   int
+	Y1   float64
+	XMid int
+	X2   int
+	Y2   float64
+}
+
+// ByTournamentMatchID sorts matches by their bracket match ID, numerically
+// where the IDs are numbers.
+type ByTournamentMatchID []*BracketMatch
+
+func (a ByTournamentMatchID) Len() int      { return len(a) }
+func (a ByTournamentMatchID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByTournamentMatchID) Less(i, j int) bool {
+	x, errX := strconv.Atoi(a[i].TournamentMatchID)
+	y, errY := strconv.Atoi(a[j].TournamentMatchID)
+	if errX == nil && errY == nil {
+		return x < y
+	}
+	return a[i].TournamentMatchID < a[j].TournamentMatchID
+}
+
+// tournamentBrackets lays out a tournament's bracket from its matches, then
+// each of its pools'. Hidden pool matches are left out unless includeHidden is
+// set, along with the links to them.
+func tournamentBrackets(t *Tournament, matches []Match, pools []*Tournament, playerMap map[string]Player, includeHidden bool) []*BracketTree {
+	trees := []*BracketTree{}
+	if tree := newBracketTree(t.Name, matches, playerMap); tree != nil {
+		trees = append(trees, tree)
+	}
+	for _, pool := range pools {
+		if tree := newBracketTree(pool.Name, dataStore.FetchMatchesForTournament(pool.ID, includeHidden), playerMap); tree != nil {
+			trees = append(trees, tree)
+		}
+	}
+	return trees
+}
+
+// newBracketTree lays out the matches that came from a bracket, or returns nil
+// if none did.
+func newBracketTree(name string, matches []Match, playerMap map[string]Player) *BracketTree {
+	winners := []*BracketMatch{}
+	losers := []*BracketMatch{}
+	for _, m := range matches {
+		// Matches added by hand aren't part of a bracket
+		if m.TournamentMatchID == "" {
+			continue
+		}
+		bm := &BracketMatch{
+			Match:       m,
+			Player1Name: bracketName(playerMap, m.Player1),
+			Player2Name: bracketName(playerMap, m.Player2),
+		}
+		if m.Round < 0 {
+			losers = append(losers, bm)
+		} else {
+			winners = append(winners, bm)
+		}
+	}
+	if len(winners) == 0 && len(losers) == 0 {
+		return nil
+	}
+
+	tree := &BracketTree{Name: name}
+	if len(losers) == 0 {
+		tree.Sections = append(tree.Sections, newBracketSection("", winners))
+		return tree
+	}
+	if len(winners) > 0 {
+		tree.Sections = append(tree.Sections, newBracketSection("Winners", winners))
+	}
+	tree.Sections = append(tree.Sections, newBracketSection("Losers", losers))
+	return tree
+}
+
+func bracketName(playerMap map[string]Player, id string) string {
+	name := []rune(playerMap[id].Nickname)
+	if len(name) > bracketNameLength {
+		return string(name[:bracketNameLength-1]) + "…"
+	}
+	return string(name)
+}
+
+// newBracketSection puts each round in a column, then places each match
+// level with the matches its players came from. Matches that nobody came
+// from fill the rows in order, and matches without any links between them,
+// like a round robin's, are stacked in their rounds.
+func newBracketSection(name string, matches []*BracketMatch) *BracketSection {
+	sort.Sort(ByTournamentMatchID(matches))
+	rounds := []int{}
+	columns := map[int]int{}
+	for _, m := range matches {
+		if _, ok := columns[m.Round]; !ok {
+			columns[m.Round] = 0
+			rounds = append(rounds, m.Round)
+		}
+	}
+	// Losers rounds count down, so they sort the other way
+	if len(rounds) > 0 && rounds[0] < 0 {
+		sort.Sort(sort.Reverse(sort.IntSlice(rounds)))
+	} else {
+		sort.Ints(rounds)
+	}
+
+	s := &BracketSection{Name: name, Box: bracketBox}
+	for i, round := range rounds {
+		columns[round] = i
+		if round < 0 {
+			round = -round
+		}
+		s.Rounds = append(s.Rounds, &BracketColumn{"Round " + strconv.Itoa(round), bracketColumnX(i)})
+	}
+
+	byID := map[string]*BracketMatch{}
+	for _, m := range matches {
+		m.column = columns[m.Round]
+		m.X = bracketColumnX(m.column)
+		byID[m.TournamentMatchID] = m
+	}
+	isChild := map[*BracketMatch]bool{}
+	linked := false
+	for _, m := range matches {
+		for _, prev := range []*string{m.Player1PrevTournamentMatch, m.Player2PrevTournamentMatch} {
+			if prev == nil {
+				continue
+			}
+			// Players who came from the other side of the bracket, or from
+			// a hidden match, aren't linked. Both players of a grand final
+			// reset came from the grand final, which is only linked once.
+			if child, ok := byID[*prev]; ok && child != m && !hasBracketChild(m, child) {
+				m.children = append(m.children, child)
+				isChild[child] = true
+				linked = true
+			}
+		}
+	}
+
+	rows := 0
+	if linked {
+		// The roots are the last matches, so later rounds are placed first
+		roots := []*BracketMatch{}
+		for _, m := range matches {
+			if !isChild[m] {
+				roots = append(roots, m)
+			}
+		}
+		sort.Stable(sort.Reverse(ByBracketColumn(roots)))
+		placed := map[*BracketMatch]bool{}
+		for _, root := range roots {
+			rows = placeBracketMatch(root, rows, placed)
+		}
+	} else {
+		inColumn := map[int]int{}
+		for _, m := range matches {
+			m.Y = float64(inColumn[m.column])
+			inColumn[m.column]++
+			if inColumn[m.column] > rows {
+				rows = inColumn[m.column]
+			}
+		}
+	}
+
+	for _, m := range matches {
+		m.Y = bracketLabelHeight + m.Y*(bracketMatchHeight+bracketRowGap)
+	}
+	for _, m := range matches {
+		for i, child := range m.children {
+			// Player 2 comes in on the bottom row, and a match both players
+			// came from comes in between the rows
+			y := m.Y + bracketMatchHeight/4
+			p1, p2 := m.Player1PrevTournamentMatch, m.Player2PrevTournamentMatch
+			if p1 != nil && p2 != nil && *p1 == *p2 {
+				y = m.Y + bracketMatchHeight/2
+			} else if i > 0 || p1 == nil || *p1 != child.TournamentMatchID {
+				y = m.Y + bracketMatchHeight*3/4
+			}
+			s.Lines = append(s.Lines, &BracketLine{
+				X1:   child.X + bracketMatchWidth,
+				Y1:   child.Y + bracketMatchHeight/2,
+				XMid: m.X - bracketColumnGap/2,
+				X2:   m.X,
+				Y2:   y,
+			})
+		}
+	}
+
+	s.Matches = matches
+	s.Width = bracketColumnX(len(rounds)) - bracketColumnGap
+	s.Height = bracketLabelHeight + rows*(bracketMatchHeight+bracketRowGap)
+	return s
+}
+
+// placeBracketMatch places a match and the matches its players came from,
+// in the rows from row on, and returns the next free row.
+func placeBracketMatch(m *BracketMatch, row int, placed map[*BracketMatch]bool) int {
+	placed[m] = true
+	total := 0.0
+	n := 0
+	for _, child := range m.children {
+		if !placed[child] {
+			row = placeBracketMatch(child, row, placed)
+		}
+		total += child.Y
+		n++
+	}
+	if n == 0 {
+		m.Y = float64(row)
+		return row + 1
+	}
+	m.Y = total / float64(n)
+	return row
+}
+
+func hasBracketChild(m *BracketMatch, child *BracketMatch) bool {
+	for _, c := range m.children {
+		if c == child {
+			return true
+		}
+	}
+	return false
+}
+
+func bracketColumnX(column int) int {
+	return column * (bracketMatchWidth + bracketColumnGap)
+}
+
+// ByBracketColumn sorts matches by the column they're drawn in.
+type ByBracketColumn []*BracketMatch
+
+func (a ByBracketColumn) Len() int           { return len(a) }
+func (a ByBracketColumn) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByBracketColumn) Less(i, j int) bool { return a[i].column < a[j].column }
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestBracketSectionLinksGrandFinalResetOnce(t *testing.T) {
+	id := func(s string) *string { return &s }
+	matches := []*BracketMatch{
+		{Match: Match{TournamentMatchID: "1", Round: 1}},
+		{Match: Match{TournamentMatchID: "2", Round: 1}},
+		{Match: Match{TournamentMatchID: "3", Round: 2, Player1PrevTournamentMatch: id("1"), Player2PrevTournamentMatch: id("2")}},
+		{Match: Match{TournamentMatchID: "4", Round: 3, Player1PrevTournamentMatch: id("3"), Player2PrevTournamentMatch: id("3")}},
+	}
+	s := newBracketSection("", matches)
+
+	reset := matches[3]
+	if len(reset.children) != 1 {
+		t.Fatalf("the reset came from one match, got %d children", len(reset.children))
+	}
+	if len(s.Lines) != 3 {
+		t.Errorf("got %d lines, want two into the grand final and one into the reset", len(s.Lines))
+	}
+	if reset.Y != matches[2].Y {
+		t.Errorf("the reset should be level with the grand final, got %v and %v", reset.Y, matches[2].Y)
+	}
+	if s.Box.Width != bracketMatchWidth || s.Box.ScoreX != bracketMatchWidth-bracketTextPadding {
+		t.Errorf("the template's box should follow the layout's sizes, got %+v", s.Box)
+	}
+}
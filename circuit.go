@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Circuit is a series of tournaments that players earn points at by where
+// they place. Its tournaments are either picked by hand or, when Tournaments
+// is empty, every tournament of the game type between DateStart and DateEnd
+// (either may be zero) with at least MinEntrants entrants, and in one of Tiers
+// if any are given.
+//
+// Points is the points table. When ScaleEntrants is set, points are scaled by
+// the tournament's entrant count over ScaleEntrants, so a tournament twice
+// that size is worth twice as much. With WeightByTier they're also scaled by
+// the weight of the tournament's tier. A player's standing is the sum of
+// their BestOf best results, or all of them if BestOf is 0.
+type Circuit struct {
+	ID            string          `gorethink:"id,omitempty"`
+	Name          string          `gorethink:"name"`
+	URLPath       string          `gorethink:"urlpath"`
+	GameType      string          `gorethink:"gametype"`
+	Tournaments   []string        `gorethink:"tournaments"`
+	DateStart     time.Time       `gorethink:"date_start"`
+	DateEnd       time.Time       `gorethink:"date_end"`
+	MinEntrants   int             `gorethink:"min_entrants"`
+	Points        []CircuitPoints `gorethink:"points"`
+	ScaleEntrants int             `gorethink:"scale_entrants"`
+	BestOf        int             `gorethink:"best_of"`
+	WeightByTier  bool            `gorethink:"weight_by_tier"`
+	Tiers         []string        `gorethink:"tiers"`
+}
+
+// CircuitPoints awards Points for finishing in Place, and in every place
+// after it up to the next entry in the table.
+type CircuitPoints struct {
+	Place  int     `gorethink:"place" json:"place"`
+	Points float64 `gorethink:"points" json:"points"`
+}
+
+type ByCircuitName []Circuit
+
+func (a ByCircuitName) Len() int           { return len(a) }
+func (a ByCircuitName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByCircuitName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// CircuitResult is what a player earned at one of the circuit's tournaments.
+// Counted is false for results outside the player's best.
+type CircuitResult struct {
+	Tournament *Tournament `json:"tournament"`
+	Place      int         `json:"place"`
+	Points     float64     `json:"points"`
+	Counted    bool        `json:"counted"`
+}
+
+type CircuitStanding struct {
+	Rank    int              `json:"rank"`
+	Player  Player           `json:"player"`
+	Points  float64          `json:"points"`
+	Results []*CircuitResult `json:"results"`
+}
+
+type ByCircuitPoints []*CircuitStanding
+
+func (a ByCircuitPoints) Len() int      { return len(a) }
+func (a ByCircuitPoints) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByCircuitPoints) Less(i, j int) bool {
+	if a[i].Points != a[j].Points {
+		return a[i].Points > a[j].Points
+	}
+	return a[i].Player.Nickname < a[j].Player.Nickname
+}
+
+type ByResultPoints []*CircuitResult
+
+func (a ByResultPoints) Len() int           { return len(a) }
+func (a ByResultPoints) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByResultPoints) Less(i, j int) bool { return a[i].Points > a[j].Points }
+
+// pointsFor returns the points for a place at a tournament, before any tier
+// weighting.
+func (c *Circuit) pointsFor(place int, entrants int) float64 {
+	var points float64
+	for _, p := range c.Points {
+		if p.Place > place {
+			break
+		}
+		points = p.Points
+	}
+	if c.ScaleEntrants > 0 {
+		points *= float64(entrants) / float64(c.ScaleEntrants)
+	}
+	return points
+}
+
+// includes reports whether a tournament counts towards the circuit.
+func (c *Circuit) includes(t *Tournament) bool {
+	if len(c.Tournaments) > 0 {
+		for _, id := range c.Tournaments {
+			if id == t.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if t.GameType != c.GameType || t.PlayerCount < c.MinEntrants {
+		return false
+	}
+	if !c.DateStart.IsZero() && t.DateStart.Before(c.DateStart) {
+		return false
+	}
+	if !c.DateEnd.IsZero() && !t.DateStart.Before(c.DateEnd.AddDate(0, 0, 1)) {
+		return false
+	}
+	if len(c.Tiers) == 0 {
+		return true
+	}
+	for _, tier := range c.Tiers {
+		if tier == t.Tier {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchCircuitTournaments returns the circuit's tournaments, oldest first.
+func fetchCircuitTournaments(c *Circuit) ([]*Tournament, error) {
+	all, err := dataStore.FetchTournaments(c.GameType, false)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(ByDateStart(*all))
+	ts := []*Tournament{}
+	for i := range *all {
+		if t := &(*all)[i]; c.includes(t) {
+			ts = append(ts, t)
+		}
+	}
+	return ts, nil
+}
+
+// fetchCircuitStandings totals up every player's points in the circuit.
+func fetchCircuitStandings(c *Circuit) ([]*CircuitStanding, error) {
+	ts, err := fetchCircuitTournaments(c)
+	if err != nil {
+		return nil, err
+	}
+
+	playerDict := make(map[string]Player)
+	for _, p := range dataStore.FetchPlayers() {
+		playerDict[p.ID] = p
+	}
+
+	var tiers []TournamentTier
+	if c.WeightByTier {
+		gt, err := dataStore.FetchGameType(c.GameType)
+		if err != nil {
+			return nil, err
+		}
+		tiers = gt.Tiers
+	}
+
+	standings := map[string]*CircuitStanding{}
+	for _, t := range ts {
+		results, err := dataStore.FetchResultsForTournament(t.ID)
+		if err != nil {
+			return nil, err
+		}
+		weight := tierWeight(tiers, t.Tier)
+		for _, r := range results {
+			if r.Place <= 0 {
+				continue
+			}
+			s, ok := standings[r.Player]
+			if !ok {
+				s = &CircuitStanding{Player: playerDict[r.Player]}
+				standings[r.Player] = s
+			}
+			s.Results = append(s.Results, &CircuitResult{
+				Tournament: t,
+				Place:      r.Place,
+				Points:     c.pointsFor(r.Place, t.PlayerCount) * weight,
+			})
+		}
+	}
+
+	sorted := []*CircuitStanding{}
+	for _, s := range standings {
+		best := make([]*CircuitResult, len(s.Results))
+		copy(best, s.Results)
+		sort.Stable(ByResultPoints(best))
+		for i, r := range best {
+			if c.BestOf > 0 && i >= c.BestOf {
+				break
+			}
+			r.Counted = true
+			s.Points += r.Points
+		}
+		sorted = append(sorted, s)
+	}
+	sort.Sort(ByCircuitPoints(sorted))
+	for i, s := range sorted {
+		s.Rank = i + 1
+		if i > 0 && s.Points == sorted[i-1].Points {
+			s.Rank = sorted[i-1].Rank
+		}
+	}
+	return sorted, nil
+}
+
+// PointsText is the points table as it's entered in the edit form.
+func (c *Circuit) PointsText() string {
+	lines := make([]string, len(c.Points))
+	for i, p := range c.Points {
+		lines[i] = fmt.Sprintf("%d=%g", p.Place, p.Points)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasTournament is used by the edit form to check the circuit's tournaments.
+func (c *Circuit) HasTournament(id string) bool {
+	for _, t := range c.Tournaments {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TiersText is the circuit's tiers as they're entered in the circuit forms.
+func (c *Circuit) TiersText() string {
+	return strings.Join(c.Tiers, ", ")
+}
+
+// readCircuitForm fills in a circuit from the add and edit forms. The points
+// table is one "place=points" entry per line.
+func readCircuitForm(r *http.Request, c *Circuit) error {
+	r.ParseForm()
+	c.Name = r.FormValue("name")
+	c.URLPath = r.FormValue("urlpath")
+	c.GameType = r.FormValue("gametype")
+	if c.Name == "" || c.URLPath == "" {
+		return errors.New("a circuit needs a name and a URL path")
+	}
+
+	c.Tournaments = []string{}
+	for _, id := range r.Form["tournaments"] {
+		if id != "" {
+			c.Tournaments = append(c.Tournaments, id)
+		}
+	}
+
+	c.DateStart, c.DateEnd = time.Time{}, time.Time{}
+	if d := r.FormValue("datestart"); d != "" {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return errors.New("start date must be YYYY-MM-DD")
+		}
+		c.DateStart = t
+	}
+	if d := r.FormValue("dateend"); d != "" {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return errors.New("end date must be YYYY-MM-DD")
+		}
+		c.DateEnd = t
+	}
+
+	c.MinEntrants, _ = strconv.Atoi(r.FormValue("minentrants"))
+	c.Tiers = []string{}
+	for _, tier := range strings.Split(r.FormValue("tiers"), ",") {
+		if tier = strings.TrimSpace(tier); tier != "" {
+			c.Tiers = append(c.Tiers, tier)
+		}
+	}
+	c.ScaleEntrants, _ = strconv.Atoi(r.FormValue("scaleentrants"))
+	c.BestOf, _ = strconv.Atoi(r.FormValue("bestof"))
+	c.WeightByTier = r.FormValue("weightbytier") == "on"
+
+	c.Points = []CircuitPoints{}
+	for _, line := range strings.Split(r.FormValue("points"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "=")
+		if len(parts) != 2 {
+			return errors.New("points must be place=points")
+		}
+		place, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+		points, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil || place < 1 {
+			return errors.New("points must be place=points")
+		}
+		c.Points = append(c.Points, CircuitPoints{place, points})
+	}
+	if len(c.Points) == 0 {
+		return errors.New("a circuit needs a points table")
+	}
+	sort.Sort(ByCircuitPlace(c.Points))
+	return nil
+}
+
+type ByCircuitPlace []CircuitPoints
+
+func (a ByCircuitPlace) Len() int           { return len(a) }
+func (a ByCircuitPlace) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByCircuitPlace) Less(i, j int) bool { return a[i].Place < a[j].Place }
+
+func circuitsHandler(w http.ResponseWriter, r *http.Request) {
+	_, canEdit := isLoggedIn(r)
+	data := struct {
+		Circuits []Circuit
+		CanEdit  bool
+	}{
+		dataStore.FetchCircuits(),
+		canEdit,
+	}
+	renderTemplate(w, r, "circuits", data)
+}
+
+func circuitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c, err := dataStore.FetchCircuitByURLPath(vars["circuit"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	standings, err := fetchCircuitStandings(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ts, err := fetchCircuitTournaments(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, canEdit := isLoggedIn(r)
+	data := struct {
+		Circuit     *Circuit
+		Standings   []*CircuitStanding
+		Tournaments []*Tournament
+		CanEdit     bool
+	}{
+		c,
+		standings,
+		ts,
+		canEdit,
+	}
+	renderTemplate(w, r, "circuit", data)
+}
+
+// circuitFormData is what the add and edit forms need. Circuit is nil when
+// adding.
+func circuitFormData(c *Circuit) interface{} {
+	ts, err := dataStore.FetchTournaments("", false)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return struct {
+		Circuit     *Circuit
+		GameTypes   []GameType
+		Tournaments *[]Tournament
+	}{
+		c,
+		dataStore.FetchGameTypes(),
+		ts,
+	}
+}
+
+func addCircuitHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "editCircuit", circuitFormData(nil))
+}
+
+func saveCircuitHandler(w http.ResponseWriter, r *http.Request) {
+	var c Circuit
+	if err := readCircuitForm(r, &c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataStore.AddCircuit(c)
+	http.Redirect(w, r, "/circuit/"+c.URLPath, http.StatusFound)
+}
+
+func editCircuitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c, err := dataStore.FetchCircuitByURLPath(vars["circuit"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "editCircuit", circuitFormData(c))
+}
+
+func saveEditCircuitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c, err := dataStore.FetchCircuitByURLPath(vars["circuit"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := readCircuitForm(r, c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dataStore.UpdateCircuit(c)
+	if err != nil {
+		fmt.Println(err)
+	}
+	http.Redirect(w, r, "/circuit/"+c.URLPath, http.StatusFound)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCircuitIncludesTiers(t *testing.T) {
+	c := &Circuit{GameType: "melee", Tiers: []string{"S", "A"}}
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tier string
+		want bool
+	}{
+		{"S", true},
+		{"A", true},
+		{"B", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		tournament := &Tournament{GameType: "melee", DateStart: date, Tier: test.tier}
+		if got := c.includes(tournament); got != test.want {
+			t.Errorf("includes tier %q = %v, want %v", test.tier, got, test.want)
+		}
+	}
+
+	c.Tiers = nil
+	if !c.includes(&Tournament{GameType: "melee", DateStart: date}) {
+		t.Errorf("a circuit without tiers should include every tier")
+	}
+}
+
+func TestCircuitPointsFor(t *testing.T) {
+	points := []CircuitPoints{{1, 100}, {2, 70}, {3, 50}, {5, 30}, {9, 10}}
+	tests := []struct {
+		points        []CircuitPoints
+		scaleEntrants int
+		place         int
+		entrants      int
+		want          float64
+	}{
+		{points, 0, 1, 32, 100},
+		{points, 0, 2, 32, 70},
+		// Places share the points of the last step at or above them
+		{points, 0, 4, 32, 50},
+		{points, 0, 8, 32, 30},
+		{points, 0, 9, 32, 10},
+		{points, 0, 40, 32, 10},
+		{[]CircuitPoints{{3, 10}}, 0, 1, 32, 0},
+		{points, 32, 1, 32, 100},
+		{points, 32, 1, 64, 200},
+		{points, 32, 4, 16, 25},
+	}
+	for _, test := range tests {
+		c := &Circuit{Points: test.points, ScaleEntrants: test.scaleEntrants}
+		if got := c.pointsFor(test.place, test.entrants); got != test.want {
+			t.Errorf("pointsFor(%d, %d) scaled by %d = %v, want %v", test.place, test.entrants, test.scaleEntrants, got, test.want)
+		}
+	}
+}
+
+func TestCircuitStandings(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	players := map[string]string{}
+	for _, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		players[name] = dataStore.AddPlayer(Player{Nickname: name})
+	}
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	places := []map[string]int{
+		{"Alice": 1, "Bob": 2, "Carol": 3, "Dave": 3},
+		// Dave entered but wasn't placed, so it doesn't count
+		{"Bob": 1, "Carol": 2, "Alice": 5, "Dave": 0},
+		{"Dave": 1, "Alice": 2, "Bob": 3, "Carol": 5},
+	}
+	for i, placed := range places {
+		id := dataStore.AddTournament(Tournament{GameType: "melee", DateStart: date.AddDate(0, i, 0), PlayerCount: 16})
+		results := []*TournamentResult{}
+		for name, place := range placed {
+			results = append(results, &TournamentResult{TournamentID: id, Player: players[name], Place: place})
+		}
+		if err := dataStore.AddTournamentResults(results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Circuit{GameType: "melee", Points: []CircuitPoints{{1, 100}, {2, 60}, {3, 40}, {5, 20}}}
+	tests := []struct {
+		bestOf  int
+		players []string
+		points  []float64
+		ranks   []int
+	}{
+		{0, []string{"Bob", "Alice", "Dave", "Carol"}, []float64{200, 180, 140, 120}, []int{1, 2, 3, 4}},
+		// Alice and Bob tie on their best two, and Dave is ranked after both
+		{2, []string{"Alice", "Bob", "Dave", "Carol"}, []float64{160, 160, 140, 100}, []int{1, 1, 3, 4}},
+	}
+	for _, test := range tests {
+		c.BestOf = test.bestOf
+		standings, err := fetchCircuitStandings(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, points, ranks := []string{}, []float64{}, []int{}
+		for _, s := range standings {
+			names = append(names, s.Player.Nickname)
+			points = append(points, s.Points)
+			ranks = append(ranks, s.Rank)
+
+			counted := 0
+			for _, r := range s.Results {
+				if r.Counted {
+					counted++
+				}
+			}
+			want := len(s.Results)
+			if test.bestOf > 0 && want > test.bestOf {
+				want = test.bestOf
+			}
+			if counted != want {
+				t.Errorf("best of %d: %d of %s's %d results counted, want %d", test.bestOf, counted, s.Player.Nickname, len(s.Results), want)
+			}
+		}
+		if !reflect.DeepEqual(names, test.players) || !reflect.DeepEqual(points, test.points) || !reflect.DeepEqual(ranks, test.ranks) {
+			t.Errorf("best of %d: got %v with %v ranked %v, want %v with %v ranked %v",
+				test.bestOf, names, points, ranks, test.players, test.points, test.ranks)
+		}
+
+		// Alice's fifth place is her worst result
+		for _, s := range standings {
+			if s.Player.Nickname != "Alice" {
+				continue
+			}
+			for _, r := range s.Results {
+				if want := test.bestOf == 0 || r.Place != 5; r.Counted != want {
+					t.Errorf("best of %d: Alice's place %d counted = %v, want %v", test.bestOf, r.Place, r.Counted, want)
+				}
+			}
+		}
+	}
+}
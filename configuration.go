@@ -10,9 +10,13 @@ import (
 )
 
 type Configuration struct {
+	Storage              string `json:"storage"`
 	RethinkConnection    string `json:"rethinkConnection"`
+	SQLitePath           string `json:"sqlitePath"`
 	ChallongeApiKey      string `json:"challongeApiKey"`
 	ChallongeDevUsername string `json:"challongeDevUsername"`
+	StartGGApiKey        string `json:"startggApiKey"`
+	StartGGEndpoint      string `json:"startggEndpoint"`
 	MapquestApiKey       string `json:"mapquestApiKey"`
 	CookieKey            string `json:"cookieKey"`
 }
@@ -1,29 +1,145 @@
 package main
 
 import (
-	"log"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
 
-	r "gopkg.in/dancannon/gorethink.v2"
+	"github.com/gorilla/sessions"
 )
 
-type DataStore struct {
-	session *r.Session
-}
+// DataStore is the storage layer used by the handlers. Each backend
+// (RethinkDB, SQLite, in-memory) implements the full interface.
+type DataStore interface {
+	GetID() string
+	NewSessionStore(keyPairs ...[]byte) (sessions.Store, error)
+
+	// Players
+	AddPlayer(p Player) string
+	UpdatePlayer(p *Player) error
+	MergePlayers(keepID string, mergeID string) error
+	FetchPlayer(id string) (*Player, error)
+	FetchPlayerByNickname(nickname string) (*Player, error)
+	FetchPlayerByURLPath(urlpath string) (*Player, error)
+	FetchPlayersSearch(nickname string) ([]*Player, error)
+	FetchPlayers() []Player
+
+	// Game types
+	AddGameType(gt GameType) string
+	UpdateGameType(gt *GameType) error
+	FetchGameType(id string) (*GameType, error)
+	FetchGameTypeByURLPath(urlpath string) (*GameType, error)
+	FetchGameTypes() []GameType
+
+	// Matches
+	AddMatch(m Match) string
+	AddMatches(ms []*Match) error
+	UpdateMatch(m *Match) error
+	DeleteMatch(id string) error
+	FetchMatch(id string) (*Match, error)
+	FetchMatchesForPlayer(id string, includeHidden bool) []Match
+	FetchMatchesForPlayers(p1 string, p2 string, includeHidden bool) *[]Match
+	FetchMatchesForTournament(id string, includeHidden bool) []Match
+	FetchMatchesForGameType(gameType string, includeHidden bool) []Match
+	FetchMatchesForGameTypeBetween(gameType string, start time.Time, end time.Time, includeHidden bool) []Match
+
+	// Tournaments
+	AddTournament(t Tournament) string
+	UpdateTournament(t *Tournament) error
+	DeleteTournament(id string) error
+	FetchTournament(id string) (*Tournament, error)
+	FetchTournamentByBracketURL(url string) (*Tournament, error)
+	FetchTournaments(gametype string, editing bool) (*[]Tournament, error)
+	FetchTournamentPools(id string) ([]*Tournament, error)
+	FetchTournamentTiers(gametype string) (map[string]string, error)
+	FetchTournamentsForPlayer(id string) ([]*Tournament, error)
+	FetchTournamentsForPlayers(player1 string, player2 string) ([]*Tournament, error)
+
+	// Tournament results
+	AddTournamentResults(rs []*TournamentResult) error
+	UpdateTournamentResult(tr *TournamentResult) error
+	FetchTournamentResult(id string) (*TournamentResult, error)
+	FetchResultsForTournament(tournamentID string) ([]*TournamentResult, error)
+	FetchResultsForPlayer(playerID string) ([]*TournamentResult, error)
+
+	// Ratings
+	FetchRatings(gameType string) ([]*Rating, error)
+	FetchRating(gameType string, player string) (*Rating, error)
+	SaveRatings(rs []*Rating) error
+	ReplaceRatings(gameType string, rs []*Rating) error
+	AddRatingChanges(cs []*RatingChange) error
+	ReplaceRatingChanges(gameType string, cs []*RatingChange) error
+	FetchRatingChanges(gameType string, player string) ([]*RatingChange, error)
+
+	// Participant handles
+	SaveParticipantHandles(hs []*ParticipantHandle) error
+	FetchParticipantHandles(handle string) ([]*ParticipantHandle, error)
+
+	// Uploaded brackets
+	SaveUploadedBracket(ub *UploadedBracket) error
+	FetchUploadedBracket(tournamentID string) (*UploadedBracket, error)
 
-func (ds *DataStore) GetSession() *r.Session {
-	return ds.session
+	// Native brackets
+	SaveNativeBracket(nb *NativeBracket) error
+	FetchNativeBracket(tournamentID string) (*NativeBracket, error)
+
+	// Circuits
+	AddCircuit(c Circuit) string
+	UpdateCircuit(c *Circuit) error
+	FetchCircuitByURLPath(urlpath string) (*Circuit, error)
+	FetchCircuits() []Circuit
+
+	// Regions
+	AddRegion(rg Region) string
+	UpdateRegion(rg *Region) error
+	FetchRegionByURLPath(urlpath string) (*Region, error)
+	FetchRegions() []Region
+
+	// Seasons
+	AddSeason(s Season) string
+	UpdateSeason(s *Season) error
+	FetchSeason(id string) (*Season, error)
+	FetchSeasons(gameType string) ([]*Season, error)
+
+	// Users
+	AddUser(u User) string
+	UpdateUser(u *User) error
+	FetchUserByEmail(email string) *User
+	FetchUsers() []User
+	CountUsers() (int, error)
 }
 
-func (ds *DataStore) GetID() string {
-	c, err := r.UUID().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		log.Fatalln(err)
+const (
+	storageRethinkDB = "rethinkdb"
+	storageMemory    = "memory"
+	storageSQLite    = "sqlite"
+)
+
+var errNotFound = errors.New("not found")
+
+// newDataStore creates the backend selected by the site configuration.
+// RethinkDB is used when no storage is configured.
+func newDataStore(c *Configuration) (DataStore, error) {
+	switch c.Storage {
+	case "", storageRethinkDB:
+		return newRethinkDataStore(c.RethinkConnection)
+	case storageMemory:
+		return newMemoryDataStore(), nil
+	case storageSQLite:
+		return newSQLiteDataStore(c.SQLitePath)
 	}
-	var uuid string
-	err = c.One(&uuid)
-	if err != nil {
-		log.Fatalln(err)
+	return nil, fmt.Errorf("unknown storage %q", c.Storage)
+}
+
+// newUUID returns a random (version 4) UUID for backends that can't
+// generate their own keys.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return uuid
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
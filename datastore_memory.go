@@ -0,0 +1,871 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// MemoryDataStore keeps everything in process memory. Nothing is persisted,
+// so it's meant for local development and tests.
+type MemoryDataStore struct {
+	mu                sync.RWMutex
+	users             map[string]User
+	players           map[string]Player
+	gameTypes         map[string]GameType
+	matches           map[string]Match
+	tournaments       map[string]Tournament
+	tournamentResults map[string]TournamentResult
+	ratings           map[string]Rating
+	ratingChanges     []RatingChange
+	handles           map[string]ParticipantHandle
+	uploadedBrackets  map[string]UploadedBracket
+	nativeBrackets    map[string]NativeBracket
+	seasons           map[string]Season
+	regions           map[string]Region
+	circuits          map[string]Circuit
+}
+
+func newMemoryDataStore() *MemoryDataStore {
+	return &MemoryDataStore{
+		users:             map[string]User{},
+		players:           map[string]Player{},
+		gameTypes:         map[string]GameType{},
+		matches:           map[string]Match{},
+		tournaments:       map[string]Tournament{},
+		tournamentResults: map[string]TournamentResult{},
+		ratings:           map[string]Rating{},
+		handles:           map[string]ParticipantHandle{},
+		uploadedBrackets:  map[string]UploadedBracket{},
+		nativeBrackets:    map[string]NativeBracket{},
+		seasons:           map[string]Season{},
+		regions:           map[string]Region{},
+		circuits:          map[string]Circuit{},
+	}
+}
+
+func (ds *MemoryDataStore) GetID() string {
+	return newUUID()
+}
+
+func (ds *MemoryDataStore) NewSessionStore(keyPairs ...[]byte) (sessions.Store, error) {
+	return sessions.NewCookieStore(keyPairs...), nil
+}
+
+// Players
+
+func (ds *MemoryDataStore) AddPlayer(p Player) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if p.ID == "" {
+		p.ID = newUUID()
+	}
+	ds.players[p.ID] = p
+	return p.ID
+}
+
+func (ds *MemoryDataStore) UpdatePlayer(p *Player) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.players[p.ID]; !ok {
+		return errNotFound
+	}
+	ds.players[p.ID] = *p
+	return nil
+}
+
+func (ds *MemoryDataStore) MergePlayers(keepID string, mergeID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for id, m := range ds.matches {
+		if m.Player1 == mergeID {
+			m.Player1 = keepID
+		}
+		if m.Player2 == mergeID {
+			m.Player2 = keepID
+		}
+		ds.matches[id] = m
+	}
+	for id, tr := range ds.tournamentResults {
+		if tr.Player == mergeID {
+			tr.Player = keepID
+			ds.tournamentResults[id] = tr
+		}
+	}
+	for id, h := range ds.handles {
+		if h.Player != mergeID {
+			continue
+		}
+		delete(ds.handles, id)
+		var kept *ParticipantHandle
+		if k, ok := ds.handles[participantHandleID(h.Handle, keepID)]; ok {
+			kept = &k
+		}
+		h = mergedHandle(h, keepID, kept)
+		ds.handles[h.ID] = h
+	}
+	delete(ds.players, mergeID)
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchPlayer(id string) (*Player, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	p, ok := ds.players[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &p, nil
+}
+
+func (ds *MemoryDataStore) findPlayer(match func(p Player) bool) (*Player, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, p := range ds.players {
+		if match(p) {
+			return &p, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (ds *MemoryDataStore) FetchPlayerByNickname(nickname string) (*Player, error) {
+	return ds.findPlayer(func(p Player) bool { return p.Nickname == nickname })
+}
+
+func (ds *MemoryDataStore) FetchPlayerByURLPath(urlpath string) (*Player, error) {
+	return ds.findPlayer(func(p Player) bool { return p.URLPath == urlpath })
+}
+
+func (ds *MemoryDataStore) FetchPlayersSearch(nickname string) ([]*Player, error) {
+	re, err := regexp.Compile("(?i)" + nickname)
+	if err != nil {
+		return nil, err
+	}
+	players := []*Player{}
+	for _, p := range ds.FetchPlayers() {
+		if len(players) == 10 {
+			break
+		}
+		if re.MatchString(p.Nickname) {
+			p := p
+			players = append(players, &p)
+		}
+	}
+	return players, nil
+}
+
+func (ds *MemoryDataStore) FetchPlayers() []Player {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	players := []Player{}
+	for _, p := range ds.players {
+		players = append(players, p)
+	}
+	sort.Sort(ByNickname(players))
+	return players
+}
+
+// Game types
+
+func (ds *MemoryDataStore) AddGameType(gt GameType) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if gt.ID == "" {
+		gt.ID = newUUID()
+	}
+	ds.gameTypes[gt.ID] = gt
+	return gt.ID
+}
+
+func (ds *MemoryDataStore) UpdateGameType(gt *GameType) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.gameTypes[gt.ID]; !ok {
+		return errNotFound
+	}
+	ds.gameTypes[gt.ID] = *gt
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchGameType(id string) (*GameType, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	gt, ok := ds.gameTypes[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &gt, nil
+}
+
+func (ds *MemoryDataStore) FetchGameTypeByURLPath(urlpath string) (*GameType, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, gt := range ds.gameTypes {
+		if gt.URLPath == urlpath {
+			return &gt, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (ds *MemoryDataStore) FetchGameTypes() []GameType {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	gameTypes := []GameType{}
+	for _, gt := range ds.gameTypes {
+		gameTypes = append(gameTypes, gt)
+	}
+	sort.Sort(ByGameTypeName(gameTypes))
+	return gameTypes
+}
+
+// Matches
+
+func (ds *MemoryDataStore) AddMatch(m Match) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if m.ID == "" {
+		m.ID = newUUID()
+	}
+	ds.matches[m.ID] = m
+	return m.ID
+}
+
+func (ds *MemoryDataStore) AddMatches(ms []*Match) error {
+	for _, m := range ms {
+		m.ID = ds.AddMatch(*m)
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) UpdateMatch(m *Match) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.matches[m.ID]; !ok {
+		return errNotFound
+	}
+	ds.matches[m.ID] = *m
+	return nil
+}
+
+func (ds *MemoryDataStore) DeleteMatch(id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.matches[id]; !ok {
+		return errNotFound
+	}
+	delete(ds.matches, id)
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchMatch(id string) (*Match, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	m, ok := ds.matches[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &m, nil
+}
+
+// filterMatches returns the matches accepted by keep, oldest first.
+func (ds *MemoryDataStore) filterMatches(includeHidden bool, keep func(m Match) bool) []Match {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	matches := []Match{}
+	for _, m := range ds.matches {
+		if (includeHidden || !m.Hidden) && keep(m) {
+			matches = append(matches, m)
+		}
+	}
+	sort.Sort(ByDate(matches))
+	return matches
+}
+
+func reverseMatches(matches []Match) []Match {
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+func (ds *MemoryDataStore) FetchMatchesForPlayer(id string, includeHidden bool) []Match {
+	return reverseMatches(ds.filterMatches(includeHidden, func(m Match) bool {
+		return m.Player1 == id || m.Player2 == id
+	}))
+}
+
+func (ds *MemoryDataStore) FetchMatchesForPlayers(p1 string, p2 string, includeHidden bool) *[]Match {
+	matches := reverseMatches(ds.filterMatches(includeHidden, func(m Match) bool {
+		return (m.Player1 == p1 && m.Player2 == p2) || (m.Player1 == p2 && m.Player2 == p1)
+	}))
+	return &matches
+}
+
+func (ds *MemoryDataStore) FetchMatchesForTournament(id string, includeHidden bool) []Match {
+	return ds.filterMatches(includeHidden, func(m Match) bool {
+		return m.Tournament == id
+	})
+}
+
+func (ds *MemoryDataStore) FetchMatchesForGameType(gameType string, includeHidden bool) []Match {
+	return ds.filterMatches(includeHidden, func(m Match) bool {
+		return m.GameType == gameType
+	})
+}
+
+func (ds *MemoryDataStore) FetchMatchesForGameTypeBetween(gameType string, start time.Time, end time.Time, includeHidden bool) []Match {
+	return ds.filterMatches(includeHidden, func(m Match) bool {
+		return m.GameType == gameType && !m.Date.Before(start) && m.Date.Before(end)
+	})
+}
+
+// Tournaments
+
+func (ds *MemoryDataStore) AddTournament(t Tournament) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if t.ID == "" {
+		t.ID = newUUID()
+	}
+	ds.tournaments[t.ID] = t
+	return t.ID
+}
+
+func (ds *MemoryDataStore) UpdateTournament(t *Tournament) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.tournaments[t.ID]; !ok {
+		return errNotFound
+	}
+	ds.tournaments[t.ID] = *t
+	return nil
+}
+
+func (ds *MemoryDataStore) DeleteTournament(id string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for mid, m := range ds.matches {
+		if m.Tournament == id {
+			delete(ds.matches, mid)
+		}
+	}
+	for rid, tr := range ds.tournamentResults {
+		if tr.TournamentID == id {
+			delete(ds.tournamentResults, rid)
+		}
+	}
+	delete(ds.uploadedBrackets, id)
+	delete(ds.nativeBrackets, id)
+	delete(ds.tournaments, id)
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchTournament(id string) (*Tournament, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	t, ok := ds.tournaments[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &t, nil
+}
+
+func (ds *MemoryDataStore) FetchTournamentByBracketURL(url string) (*Tournament, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, t := range ds.tournaments {
+		if t.BracketURL == url {
+			return &t, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (ds *MemoryDataStore) FetchTournaments(gametype string, editing bool) (*[]Tournament, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	ts := []Tournament{}
+	for _, t := range ds.tournaments {
+		if t.PoolOf != "" {
+			continue
+		}
+		if gametype != "" && t.GameType != gametype {
+			continue
+		}
+		if !editing && t.Editing {
+			continue
+		}
+		ts = append(ts, t)
+	}
+	sort.Sort(sort.Reverse(ByDateStart(ts)))
+	return &ts, nil
+}
+
+func (ds *MemoryDataStore) FetchTournamentPools(id string) ([]*Tournament, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	ts := []*Tournament{}
+	for _, t := range ds.tournaments {
+		if t.PoolOf == id {
+			t := t
+			ts = append(ts, &t)
+		}
+	}
+	sort.Sort(ByTournamentName(ts))
+	return ts, nil
+}
+
+func (ds *MemoryDataStore) FetchTournamentTiers(gametype string) (map[string]string, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	tiers := map[string]string{}
+	for _, t := range ds.tournaments {
+		if t.GameType == gametype {
+			tiers[t.ID] = t.Tier
+		}
+	}
+	return tiers, nil
+}
+
+// tournamentsForMatches returns the distinct tournaments the matches were
+// played in.
+func (ds *MemoryDataStore) tournamentsForMatches(matches []Match) []*Tournament {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	seen := map[string]bool{}
+	ts := []*Tournament{}
+	for _, m := range matches {
+		if seen[m.Tournament] {
+			continue
+		}
+		seen[m.Tournament] = true
+		if t, ok := ds.tournaments[m.Tournament]; ok {
+			ts = append(ts, &t)
+		}
+	}
+	return ts
+}
+
+func (ds *MemoryDataStore) FetchTournamentsForPlayer(id string) ([]*Tournament, error) {
+	return ds.tournamentsForMatches(ds.FetchMatchesForPlayer(id, true)), nil
+}
+
+func (ds *MemoryDataStore) FetchTournamentsForPlayers(player1 string, player2 string) ([]*Tournament, error) {
+	return ds.tournamentsForMatches(*ds.FetchMatchesForPlayers(player1, player2, true)), nil
+}
+
+// Tournament results
+
+func (ds *MemoryDataStore) AddTournamentResults(rs []*TournamentResult) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, tr := range rs {
+		tr := *tr
+		if tr.ID == "" {
+			tr.ID = newUUID()
+		}
+		tr.Tournament = nil
+		ds.tournamentResults[tr.ID] = tr
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) UpdateTournamentResult(tr *TournamentResult) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.tournamentResults[tr.ID]; !ok {
+		return errNotFound
+	}
+	updated := *tr
+	updated.Tournament = nil
+	ds.tournamentResults[tr.ID] = updated
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchTournamentResult(id string) (*TournamentResult, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	tr, ok := ds.tournamentResults[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &tr, nil
+}
+
+func (ds *MemoryDataStore) FetchResultsForTournament(tournamentID string) ([]*TournamentResult, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	results := []*TournamentResult{}
+	for _, tr := range ds.tournamentResults {
+		if tr.TournamentID == tournamentID {
+			tr := tr
+			results = append(results, &tr)
+		}
+	}
+	sort.Sort(ByPlace(results))
+	return results, nil
+}
+
+func (ds *MemoryDataStore) FetchResultsForPlayer(playerID string) ([]*TournamentResult, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	results := []*TournamentResult{}
+	for _, tr := range ds.tournamentResults {
+		if tr.Player != playerID {
+			continue
+		}
+		t, ok := ds.tournaments[tr.TournamentID]
+		if !ok {
+			continue
+		}
+		tr := tr
+		tr.Tournament = &t
+		results = append(results, &tr)
+	}
+	sort.Sort(sort.Reverse(ByTournamentDate(results)))
+	return results, nil
+}
+
+// Ratings
+
+func (ds *MemoryDataStore) FetchRatings(gameType string) ([]*Rating, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	ratings := []*Rating{}
+	for _, r := range ds.ratings {
+		if r.GameType == gameType {
+			r := r
+			ratings = append(ratings, &r)
+		}
+	}
+	return ratings, nil
+}
+
+func (ds *MemoryDataStore) FetchRating(gameType string, player string) (*Rating, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	r, ok := ds.ratings[ratingID(gameType, player)]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &r, nil
+}
+
+func (ds *MemoryDataStore) SaveRatings(rs []*Rating) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, r := range rs {
+		ds.ratings[r.ID] = *r
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) ReplaceRatings(gameType string, rs []*Rating) error {
+	ds.mu.Lock()
+	for id, r := range ds.ratings {
+		if r.GameType == gameType {
+			delete(ds.ratings, id)
+		}
+	}
+	ds.mu.Unlock()
+	return ds.SaveRatings(rs)
+}
+
+func (ds *MemoryDataStore) AddRatingChanges(cs []*RatingChange) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, c := range cs {
+		if c.ID == "" {
+			c.ID = newUUID()
+		}
+		ds.ratingChanges = append(ds.ratingChanges, *c)
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) ReplaceRatingChanges(gameType string, cs []*RatingChange) error {
+	ds.mu.Lock()
+	kept := []RatingChange{}
+	for _, c := range ds.ratingChanges {
+		if c.GameType != gameType {
+			kept = append(kept, c)
+		}
+	}
+	ds.ratingChanges = kept
+	ds.mu.Unlock()
+	return ds.AddRatingChanges(cs)
+}
+
+func (ds *MemoryDataStore) FetchRatingChanges(gameType string, player string) ([]*RatingChange, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	changes := []*RatingChange{}
+	for _, c := range ds.ratingChanges {
+		if c.GameType == gameType && c.Player == player {
+			c := c
+			changes = append(changes, &c)
+		}
+	}
+	sort.Sort(ByRatingMatches(changes))
+	return changes, nil
+}
+
+// Participant handles
+
+func (ds *MemoryDataStore) SaveParticipantHandles(hs []*ParticipantHandle) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, h := range hs {
+		ds.handles[h.ID] = *h
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchParticipantHandles(handle string) ([]*ParticipantHandle, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	hs := []*ParticipantHandle{}
+	for _, h := range ds.handles {
+		if h.Handle == handle {
+			h := h
+			hs = append(hs, &h)
+		}
+	}
+	return hs, nil
+}
+
+// Uploaded brackets
+
+func (ds *MemoryDataStore) SaveUploadedBracket(ub *UploadedBracket) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.uploadedBrackets[ub.ID] = *ub
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchUploadedBracket(tournamentID string) (*UploadedBracket, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	ub, ok := ds.uploadedBrackets[tournamentID]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &ub, nil
+}
+
+// Native brackets
+
+// copyNativeBracket copies a native bracket's slices, so callers can't change
+// the stored bracket without saving it.
+func copyNativeBracket(nb NativeBracket) NativeBracket {
+	nb.Entrants = append([]NativeEntrant{}, nb.Entrants...)
+	nb.Matches = append([]NativeMatch{}, nb.Matches...)
+	return nb
+}
+
+func (ds *MemoryDataStore) SaveNativeBracket(nb *NativeBracket) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.nativeBrackets[nb.ID] = copyNativeBracket(*nb)
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchNativeBracket(tournamentID string) (*NativeBracket, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	nb, ok := ds.nativeBrackets[tournamentID]
+	if !ok {
+		return nil, errNotFound
+	}
+	nb = copyNativeBracket(nb)
+	return &nb, nil
+}
+
+// Circuits
+
+func (ds *MemoryDataStore) AddCircuit(c Circuit) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if c.ID == "" {
+		c.ID = newUUID()
+	}
+	ds.circuits[c.ID] = c
+	return c.ID
+}
+
+func (ds *MemoryDataStore) UpdateCircuit(c *Circuit) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.circuits[c.ID]; !ok {
+		return errNotFound
+	}
+	ds.circuits[c.ID] = *c
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchCircuitByURLPath(urlpath string) (*Circuit, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, c := range ds.circuits {
+		if c.URLPath == urlpath {
+			return &c, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (ds *MemoryDataStore) FetchCircuits() []Circuit {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	circuits := []Circuit{}
+	for _, c := range ds.circuits {
+		circuits = append(circuits, c)
+	}
+	sort.Sort(ByCircuitName(circuits))
+	return circuits
+}
+
+// Regions
+
+func (ds *MemoryDataStore) AddRegion(rg Region) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if rg.ID == "" {
+		rg.ID = newUUID()
+	}
+	ds.regions[rg.ID] = rg
+	return rg.ID
+}
+
+func (ds *MemoryDataStore) UpdateRegion(rg *Region) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.regions[rg.ID]; !ok {
+		return errNotFound
+	}
+	ds.regions[rg.ID] = *rg
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchRegionByURLPath(urlpath string) (*Region, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, rg := range ds.regions {
+		if rg.URLPath == urlpath {
+			return &rg, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (ds *MemoryDataStore) FetchRegions() []Region {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	regions := []Region{}
+	for _, rg := range ds.regions {
+		regions = append(regions, rg)
+	}
+	sort.Sort(ByRegionName(regions))
+	return regions
+}
+
+// Seasons
+
+func (ds *MemoryDataStore) AddSeason(s Season) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if s.ID == "" {
+		s.ID = newUUID()
+	}
+	ds.seasons[s.ID] = s
+	return s.ID
+}
+
+func (ds *MemoryDataStore) UpdateSeason(s *Season) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.seasons[s.ID]; !ok {
+		return errNotFound
+	}
+	ds.seasons[s.ID] = *s
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchSeason(id string) (*Season, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	s, ok := ds.seasons[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &s, nil
+}
+
+func (ds *MemoryDataStore) FetchSeasons(gameType string) ([]*Season, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	seasons := []*Season{}
+	for _, s := range ds.seasons {
+		if s.GameType == gameType {
+			s := s
+			seasons = append(seasons, &s)
+		}
+	}
+	sort.Sort(BySeasonStart(seasons))
+	return seasons, nil
+}
+
+// Users
+
+func (ds *MemoryDataStore) AddUser(u User) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if u.ID == "" {
+		u.ID = newUUID()
+	}
+	ds.users[u.ID] = u
+	return u.ID
+}
+
+func (ds *MemoryDataStore) UpdateUser(u *User) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.users[u.ID]; !ok {
+		return errNotFound
+	}
+	ds.users[u.ID] = *u
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchUserByEmail(email string) *User {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, u := range ds.users {
+		if u.Email == email {
+			return &u
+		}
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) FetchUsers() []User {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	users := []User{}
+	for _, u := range ds.users {
+		users = append(users, u)
+	}
+	sort.Sort(ByEmail(users))
+	return users
+}
+
+func (ds *MemoryDataStore) CountUsers() (int, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return len(ds.users), nil
+}
@@ -0,0 +1,1141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/boj/rethinkstore"
+	"github.com/gorilla/sessions"
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+// RethinkDataStore stores everything in a RethinkDB database.
+type RethinkDataStore struct {
+	address string
+	session *r.Session
+}
+
+func newRethinkDataStore(address string) (*RethinkDataStore, error) {
+	session, err := r.Connect(r.ConnectOpts{
+		Address:  address,
+		Database: "velvetdb",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RethinkDataStore{address: address, session: session}, nil
+}
+
+func (ds *RethinkDataStore) GetID() string {
+	c, err := r.UUID().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var uuid string
+	err = c.One(&uuid)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return uuid
+}
+
+func (ds *RethinkDataStore) NewSessionStore(keyPairs ...[]byte) (sessions.Store, error) {
+	return rethinkstore.NewRethinkStore(ds.address, "velvetdb", "sessions", 5, 5, keyPairs...)
+}
+
+func getUserTable() r.Term {
+	return r.Table("users")
+}
+
+func getPlayerTable() r.Term {
+	return r.Table("players")
+}
+
+func getGameTypeTable() r.Term {
+	return r.Table("gametypes")
+}
+
+func getMatchTable() r.Term {
+	return r.Table("matches")
+}
+
+func getTournamentTable() r.Term {
+	return r.Table("tournaments")
+}
+
+func getTournamentResultTable() r.Term {
+	return r.Table("tournamentresults")
+}
+
+func getCircuitTable() r.Term {
+	return r.Table("circuits")
+}
+
+func getRegionTable() r.Term {
+	return r.Table("regions")
+}
+
+func getSeasonTable() r.Term {
+	return r.Table("seasons")
+}
+
+func getRatingTable() r.Term {
+	return r.Table("ratings")
+}
+
+func getRatingChangeTable() r.Term {
+	return r.Table("ratingchanges")
+}
+
+func getParticipantHandleTable() r.Term {
+	return r.Table("participanthandles")
+}
+
+func getUploadedBracketTable() r.Term {
+	return r.Table("uploadedbrackets")
+}
+
+func getNativeBracketTable() r.Term {
+	return r.Table("nativebrackets")
+}
+
+// Players
+
+func (ds *RethinkDataStore) AddPlayer(player Player) string {
+	wr, err := getPlayerTable().Insert(player).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(wr.GeneratedKeys) != 0 {
+		return wr.GeneratedKeys[0]
+	}
+	return player.ID
+}
+
+func (ds *RethinkDataStore) UpdatePlayer(p *Player) error {
+	_, err := getPlayerTable().Get(p.ID).Replace(p).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) MergePlayers(keepID string, mergeID string) error {
+	// merge matches into the player to keep
+	_, err := getMatchTable().GetAllByIndex("player1", mergeID).Update(map[string]interface{}{
+		"player1": keepID,
+	}).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+
+	_, err = getMatchTable().GetAllByIndex("player2", mergeID).Update(map[string]interface{}{
+		"player2": keepID,
+	}).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+
+	// merge tournament results into the player to keep
+	_, err = getTournamentResultTable().GetAllByIndex("player", mergeID).Update(map[string]interface{}{
+		"player": keepID,
+	}).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+
+	// re-point the player's participant handles at the player to keep
+	if err := ds.mergeParticipantHandles(keepID, mergeID); err != nil {
+		return err
+	}
+
+	// delete the player
+	_, err = getPlayerTable().Get(mergeID).Delete().RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) mergeParticipantHandles(keepID string, mergeID string) error {
+	query := getParticipantHandleTable().Filter(map[string]interface{}{
+		"player": mergeID,
+	})
+	c, err := query.Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return err
+	}
+	merged := []ParticipantHandle{}
+	if err := c.All(&merged); err != nil {
+		return err
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	hs := []ParticipantHandle{}
+	for _, h := range merged {
+		var kept *ParticipantHandle
+		others, err := ds.FetchParticipantHandles(h.Handle)
+		if err != nil {
+			return err
+		}
+		for _, o := range others {
+			if o.Player == keepID {
+				kept = o
+			}
+		}
+		hs = append(hs, mergedHandle(h, keepID, kept))
+	}
+	_, err = getParticipantHandleTable().Insert(hs, r.InsertOpts{Conflict: "replace"}).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	_, err = query.Delete().RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchPlayer(id string) (*Player, error) {
+	c, err := getPlayerTable().Get(id).Run(ds.session)
+	defer c.Close()
+
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var player *Player
+	err = c.One(&player)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return player, nil
+}
+
+func (ds *RethinkDataStore) FetchPlayerByNickname(nickname string) (*Player, error) {
+	c, err := getPlayerTable().Filter(map[string]interface{}{
+		"nickname": nickname,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var player *Player
+	err = c.One(&player)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return player, nil
+}
+
+func (ds *RethinkDataStore) FetchPlayerByURLPath(urlpath string) (*Player, error) {
+	c, err := getPlayerTable().GetAllByIndex("urlpath", urlpath).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var player *Player
+	err = c.One(&player)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return player, nil
+}
+
+func (ds *RethinkDataStore) FetchPlayersSearch(nickname string) ([]*Player, error) {
+	c, err := getPlayerTable().
+		Filter(r.Row.Field("nickname").Match("(?i)" + nickname)).
+		OrderBy("nickname").Limit(10).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	players := []*Player{}
+	err = c.All(&players)
+	if err != nil {
+		return nil, err
+	}
+	return players, nil
+}
+
+func (ds *RethinkDataStore) FetchPlayers() []Player {
+	c, err := getPlayerTable().OrderBy("nickname").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	players := []Player{}
+	err = c.All(&players)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return players
+}
+
+// Game types
+
+func (ds *RethinkDataStore) AddGameType(gameType GameType) string {
+	wr, err := getGameTypeTable().Insert(gameType).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return wr.GeneratedKeys[0]
+}
+
+func (ds *RethinkDataStore) UpdateGameType(gt *GameType) error {
+	wr, err := getGameTypeTable().Get(gt.ID).Replace(gt).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) FetchGameType(ID string) (*GameType, error) {
+	c, err := getGameTypeTable().Get(ID).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	var gt *GameType
+	err = c.One(&gt)
+	if err != nil {
+		return nil, err
+	}
+	return gt, nil
+}
+
+func (ds *RethinkDataStore) FetchGameTypeByURLPath(p string) (*GameType, error) {
+	c, err := getGameTypeTable().Filter(map[string]interface{}{
+		"urlpath": p,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	var gt *GameType
+	err = c.One(&gt)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return gt, nil
+}
+
+func (ds *RethinkDataStore) FetchGameTypes() []GameType {
+	c, err := getGameTypeTable().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	gameTypes := []GameType{}
+	err = c.All(&gameTypes)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return gameTypes
+}
+
+// Matches
+
+func (ds *RethinkDataStore) AddMatch(m Match) string {
+	wr, err := getMatchTable().Insert(m).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return wr.GeneratedKeys[0]
+}
+
+// AddMatches fills in the IDs generated for the new matches, which come back
+// in insert order.
+func (ds *RethinkDataStore) AddMatches(ms []*Match) error {
+	wr, err := getMatchTable().Insert(ms).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	keys := wr.GeneratedKeys
+	for _, m := range ms {
+		if m.ID == "" && len(keys) > 0 {
+			m.ID, keys = keys[0], keys[1:]
+		}
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) UpdateMatch(m *Match) error {
+	wr, err := getMatchTable().Get(m.ID).Replace(m).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) DeleteMatch(id string) error {
+	_, err := getMatchTable().Get(id).Delete().RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchMatch(id string) (*Match, error) {
+	c, err := getMatchTable().Get(id).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	var m *Match
+	err = c.One(&m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (ds *RethinkDataStore) FetchMatchesForPlayer(id string, includeHidden bool) []Match {
+	query := getMatchTable().GetAllByIndex("players", id)
+	if !includeHidden {
+		query = query.Filter(map[string]interface{}{"hidden": false})
+	}
+
+	c, err := query.OrderBy(r.Desc("date")).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	matches := []Match{}
+	err = c.All(&matches)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return matches
+}
+
+func (ds *RethinkDataStore) FetchMatchesForPlayers(p1 string, p2 string, includeHidden bool) *[]Match {
+	query := betweenPlayerPair(p1, p2).OrderBy(r.OrderByOpts{Index: r.Desc("player_pair_date")})
+	if !includeHidden {
+		query = query.Filter(map[string]interface{}{"hidden": false})
+	}
+	c, err := query.Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	matches := []Match{}
+	err = c.All(&matches)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return &matches
+}
+
+func (ds *RethinkDataStore) FetchMatchesForTournament(id string, includeHidden bool) []Match {
+	query := getMatchTable().GetAllByIndex("tournament", id)
+	if !includeHidden {
+		query = query.Filter(map[string]interface{}{"hidden": false})
+	}
+
+	c, err := query.OrderBy("date").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	matches := []Match{}
+	err = c.All(&matches)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return matches
+}
+
+func (ds *RethinkDataStore) FetchMatchesForGameType(gameType string, includeHidden bool) []Match {
+	return ds.fetchMatchesForGameTypeBetween(gameType, r.MinVal, r.MaxVal, includeHidden)
+}
+
+// FetchMatchesForGameTypeBetween returns the matches played from start up to,
+// but not including, end.
+func (ds *RethinkDataStore) FetchMatchesForGameTypeBetween(gameType string, start time.Time, end time.Time, includeHidden bool) []Match {
+	return ds.fetchMatchesForGameTypeBetween(gameType, start, end, includeHidden)
+}
+
+func (ds *RethinkDataStore) fetchMatchesForGameTypeBetween(gameType string, start interface{}, end interface{}, includeHidden bool) []Match {
+	query := getMatchTable().Between(
+		[]interface{}{gameType, start},
+		[]interface{}{gameType, end},
+		r.BetweenOpts{Index: "gametype_date"},
+	).OrderBy(r.OrderByOpts{Index: "gametype_date"})
+	if !includeHidden {
+		query = query.Filter(map[string]interface{}{"hidden": false})
+	}
+
+	c, err := query.Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	matches := []Match{}
+	err = c.All(&matches)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return matches
+}
+
+// betweenPlayerPair selects every match between two players using the
+// player_pair_date index, which keys each match by its lower player ID first.
+func betweenPlayerPair(p1 string, p2 string) r.Term {
+	if p2 < p1 {
+		p1, p2 = p2, p1
+	}
+	return getMatchTable().Between(
+		[]interface{}{p1, p2, r.MinVal},
+		[]interface{}{p1, p2, r.MaxVal},
+		r.BetweenOpts{Index: "player_pair_date"},
+	)
+}
+
+// Tournaments
+
+func (ds *RethinkDataStore) AddTournament(t Tournament) string {
+	wr, err := getTournamentTable().Insert(&t).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return wr.GeneratedKeys[0]
+}
+
+func (ds *RethinkDataStore) UpdateTournament(t *Tournament) error {
+	wr, err := getTournamentTable().Get(t.ID).Replace(t).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) DeleteTournament(ID string) error {
+	// Delete the tournament matches
+	_, err := getMatchTable().Filter(map[string]interface{}{
+		"tournament": ID,
+	}).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	// Delete the tournament results
+	_, err = getTournamentResultTable().Filter(map[string]interface{}{
+		"tournament": ID,
+	}).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	// Delete the uploaded or native bracket, if there is one
+	_, err = getUploadedBracketTable().Get(ID).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	_, err = getNativeBracketTable().Get(ID).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	// Delete the tournament
+	_, err = getTournamentTable().Get(ID).Delete().RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchTournament(id string) (*Tournament, error) {
+	c, err := getTournamentTable().Get(id).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	var t Tournament
+	err = c.One(&t)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ds *RethinkDataStore) FetchTournamentByBracketURL(url string) (*Tournament, error) {
+	c, err := getTournamentTable().Filter(map[string]interface{}{
+		"bracket_url": url,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	var t Tournament
+	err = c.One(&t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ds *RethinkDataStore) FetchTournaments(gametype string, editing bool) (*[]Tournament, error) {
+	query := getTournamentTable()
+	filter := map[string]interface{}{
+		"pool_of": "",
+	}
+
+	if gametype != "" {
+		filter["gametype"] = gametype
+	}
+	if !editing {
+		filter["editing"] = false
+	}
+	c, err := query.Filter(filter).OrderBy(r.Desc("date_start")).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	var t []Tournament
+	err = c.All(&t)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ds *RethinkDataStore) FetchTournamentPools(ID string) ([]*Tournament, error) {
+	query := getTournamentTable()
+	c, err := query.Filter(map[string]interface{}{
+		"pool_of": ID,
+	}).OrderBy("name").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	var t []*Tournament
+	err = c.All(&t)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// FetchTournamentTiers maps every tournament of a game type, pools included,
+// to its tier.
+func (ds *RethinkDataStore) FetchTournamentTiers(gametype string) (map[string]string, error) {
+	c, err := getTournamentTable().Filter(map[string]interface{}{
+		"gametype": gametype,
+	}).Pluck("id", "tier").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	var ts []Tournament
+	if err := c.All(&ts); err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	tiers := map[string]string{}
+	for _, t := range ts {
+		tiers[t.ID] = t.Tier
+	}
+	return tiers, nil
+}
+
+func (ds *RethinkDataStore) FetchTournamentsForPlayer(ID string) ([]*Tournament, error) {
+	c, err := getMatchTable().GetAllByIndex("players", ID).
+		EqJoin("tournament", getTournamentTable()).
+		Without("left").Field("right").Distinct().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var t []*Tournament
+	err = c.All(&t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (ds *RethinkDataStore) FetchTournamentsForPlayers(player1 string, player2 string) ([]*Tournament, error) {
+	c, err := betweenPlayerPair(player1, player2).
+		EqJoin("tournament", getTournamentTable()).
+		Without("left").Field("right").Distinct().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	matches := []*Tournament{}
+	err = c.All(&matches)
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// Tournament results
+
+func (ds *RethinkDataStore) AddTournamentResults(rs []*TournamentResult) error {
+	_, err := getTournamentResultTable().Insert(rs).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) UpdateTournamentResult(tr *TournamentResult) error {
+	wr, err := getTournamentResultTable().Get(tr.ID).Replace(tr).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) FetchTournamentResult(resultID string) (*TournamentResult, error) {
+	c, err := getTournamentResultTable().Get(resultID).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var result *TournamentResult
+	err = c.One(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (ds *RethinkDataStore) FetchResultsForTournament(tournamentID string) ([]*TournamentResult, error) {
+	c, err := getTournamentResultTable().Filter(map[string]interface{}{
+		"tournament": tournamentID,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*TournamentResult
+	err = c.All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(ByPlace(results))
+	return results, nil
+}
+
+func (ds *RethinkDataStore) FetchResultsForPlayer(playerID string) ([]*TournamentResult, error) {
+	c, err := getTournamentResultTable().GetAllByIndex("player", playerID).
+		EqJoin("tournament", getTournamentTable()).
+		OrderBy(r.Desc(r.Row.Field("right").Field("date_start"))).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	type joinType struct {
+		Left  *TournamentResult
+		Right *Tournament
+	}
+	var result joinType
+	results := []*TournamentResult{}
+	for c.Next(&result) {
+		result.Left.Tournament = result.Right
+		results = append(results, result.Left)
+	}
+	if err = c.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Ratings
+
+func (ds *RethinkDataStore) FetchRatings(gameType string) ([]*Rating, error) {
+	c, err := getRatingTable().GetAllByIndex("gametype", gameType).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ratings := []*Rating{}
+	err = c.All(&ratings)
+	if err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+func (ds *RethinkDataStore) FetchRating(gameType string, player string) (*Rating, error) {
+	c, err := getRatingTable().Get(ratingID(gameType, player)).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var rating *Rating
+	err = c.One(&rating)
+	if err != nil {
+		return nil, err
+	}
+	return rating, nil
+}
+
+func (ds *RethinkDataStore) SaveRatings(rs []*Rating) error {
+	_, err := getRatingTable().Insert(rs, r.InsertOpts{Conflict: "replace"}).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) ReplaceRatings(gameType string, rs []*Rating) error {
+	_, err := getRatingTable().GetAllByIndex("gametype", gameType).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	return ds.SaveRatings(rs)
+}
+
+func (ds *RethinkDataStore) AddRatingChanges(cs []*RatingChange) error {
+	_, err := getRatingChangeTable().Insert(cs).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) ReplaceRatingChanges(gameType string, cs []*RatingChange) error {
+	_, err := getRatingChangeTable().GetAllByIndex("gametype", gameType).Delete().RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if len(cs) == 0 {
+		return nil
+	}
+	return ds.AddRatingChanges(cs)
+}
+
+func (ds *RethinkDataStore) FetchRatingChanges(gameType string, player string) ([]*RatingChange, error) {
+	c, err := getRatingChangeTable().
+		Between([]interface{}{gameType, player, r.MinVal}, []interface{}{gameType, player, r.MaxVal},
+			r.BetweenOpts{Index: "gametype_player_matches"}).
+		OrderBy(r.OrderByOpts{Index: "gametype_player_matches"}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*RatingChange{}
+	err = c.All(&changes)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Participant handles
+
+func (ds *RethinkDataStore) SaveParticipantHandles(hs []*ParticipantHandle) error {
+	_, err := getParticipantHandleTable().Insert(hs, r.InsertOpts{Conflict: "replace"}).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchParticipantHandles(handle string) ([]*ParticipantHandle, error) {
+	c, err := getParticipantHandleTable().GetAllByIndex("handle", handle).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	hs := []*ParticipantHandle{}
+	err = c.All(&hs)
+	if err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
+// Uploaded brackets
+
+func (ds *RethinkDataStore) SaveUploadedBracket(ub *UploadedBracket) error {
+	_, err := getUploadedBracketTable().Insert(ub, r.InsertOpts{Conflict: "replace"}).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchUploadedBracket(tournamentID string) (*UploadedBracket, error) {
+	c, err := getUploadedBracketTable().Get(tournamentID).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var ub *UploadedBracket
+	err = c.One(&ub)
+	if err != nil {
+		return nil, err
+	}
+	return ub, nil
+}
+
+// Native brackets
+
+func (ds *RethinkDataStore) SaveNativeBracket(nb *NativeBracket) error {
+	_, err := getNativeBracketTable().Insert(nb, r.InsertOpts{Conflict: "replace"}).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchNativeBracket(tournamentID string) (*NativeBracket, error) {
+	c, err := getNativeBracketTable().Get(tournamentID).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var nb *NativeBracket
+	err = c.One(&nb)
+	if err != nil {
+		return nil, err
+	}
+	return nb, nil
+}
+
+// Circuits
+
+func (ds *RethinkDataStore) AddCircuit(c Circuit) string {
+	wr, err := getCircuitTable().Insert(c).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(wr.GeneratedKeys) != 0 {
+		return wr.GeneratedKeys[0]
+	}
+	return c.ID
+}
+
+func (ds *RethinkDataStore) UpdateCircuit(c *Circuit) error {
+	wr, err := getCircuitTable().Get(c.ID).Replace(c).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) FetchCircuitByURLPath(p string) (*Circuit, error) {
+	c, err := getCircuitTable().Filter(map[string]interface{}{
+		"urlpath": p,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var circuit *Circuit
+	err = c.One(&circuit)
+	if err != nil {
+		return nil, err
+	}
+	return circuit, nil
+}
+
+func (ds *RethinkDataStore) FetchCircuits() []Circuit {
+	c, err := getCircuitTable().OrderBy("name").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	circuits := []Circuit{}
+	err = c.All(&circuits)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return circuits
+}
+
+// Regions
+
+func (ds *RethinkDataStore) AddRegion(rg Region) string {
+	wr, err := getRegionTable().Insert(rg).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(wr.GeneratedKeys) != 0 {
+		return wr.GeneratedKeys[0]
+	}
+	return rg.ID
+}
+
+func (ds *RethinkDataStore) UpdateRegion(rg *Region) error {
+	wr, err := getRegionTable().Get(rg.ID).Replace(rg).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) FetchRegionByURLPath(p string) (*Region, error) {
+	c, err := getRegionTable().Filter(map[string]interface{}{
+		"urlpath": p,
+	}).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var rg *Region
+	err = c.One(&rg)
+	if err != nil {
+		return nil, err
+	}
+	return rg, nil
+}
+
+func (ds *RethinkDataStore) FetchRegions() []Region {
+	c, err := getRegionTable().OrderBy("name").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	regions := []Region{}
+	err = c.All(&regions)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return regions
+}
+
+// Seasons
+
+func (ds *RethinkDataStore) AddSeason(s Season) string {
+	wr, err := getSeasonTable().Insert(s).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(wr.GeneratedKeys) != 0 {
+		return wr.GeneratedKeys[0]
+	}
+	return s.ID
+}
+
+func (ds *RethinkDataStore) UpdateSeason(s *Season) error {
+	wr, err := getSeasonTable().Get(s.ID).Replace(s).RunWrite(ds.session)
+	if err != nil {
+		return err
+	}
+	if wr.Errors > 0 {
+		return errors.New(wr.FirstError)
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) FetchSeason(id string) (*Season, error) {
+	c, err := getSeasonTable().Get(id).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var s *Season
+	err = c.One(&s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (ds *RethinkDataStore) FetchSeasons(gameType string) ([]*Season, error) {
+	c, err := getSeasonTable().GetAllByIndex("gametype", gameType).OrderBy("date_start").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	seasons := []*Season{}
+	err = c.All(&seasons)
+	if err != nil {
+		return nil, err
+	}
+	return seasons, nil
+}
+
+// Users
+
+func (ds *RethinkDataStore) AddUser(user User) string {
+	resp, err := getUserTable().Insert(&user).RunWrite(ds.session)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return resp.GeneratedKeys[0]
+}
+
+func (ds *RethinkDataStore) UpdateUser(u *User) error {
+	_, err := getUserTable().Get(u.ID).Replace(u).RunWrite(ds.session)
+	return err
+}
+
+func (ds *RethinkDataStore) FetchUserByEmail(email string) *User {
+	c, err := getUserTable().GetAllByIndex("email", email).Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	var user User
+	err = c.One(&user)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return &user
+}
+
+func (ds *RethinkDataStore) FetchUsers() []User {
+	c, err := getUserTable().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		fmt.Println(err)
+	}
+	var u []User
+	err = c.All(&u)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return u
+}
+
+func (ds *RethinkDataStore) CountUsers() (int, error) {
+	c, err := getUserTable().Count().Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = c.One(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
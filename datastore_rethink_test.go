@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+// The RethinkDB benchmarks compare each indexed lookup with the Filter scan it
+// replaced. They need a scratch RethinkDB server, since the first run seeds
+// its velvetdb database with benchMatches matches:
+//
+//	VELVETDB_BENCHRETHINK=localhost:28015 go test -run NONE -bench Rethink
+
+const (
+	benchMatches  = 40000
+	benchPlayers  = 200
+	benchGameType = "bench-gametype"
+	benchEmail    = "bench@example.com"
+)
+
+var (
+	benchRethinkOnce  sync.Once
+	benchRethinkStore *RethinkDataStore
+	benchRethinkErr   error
+)
+
+func rethinkBenchStore(b *testing.B) *RethinkDataStore {
+	address := os.Getenv("VELVETDB_BENCHRETHINK")
+	if address == "" {
+		b.Skip("set VELVETDB_BENCHRETHINK to a scratch RethinkDB server to run")
+	}
+	benchRethinkOnce.Do(func() {
+		benchRethinkStore, benchRethinkErr = seedRethinkBenchStore(address)
+	})
+	if benchRethinkErr != nil {
+		b.Fatal(benchRethinkErr)
+	}
+	b.ResetTimer()
+	return benchRethinkStore
+}
+
+func benchPlayerID(i int) string {
+	return "bench-player-" + strconv.Itoa(i)
+}
+
+func seedRethinkBenchStore(address string) (*RethinkDataStore, error) {
+	ds, err := newRethinkDataStore(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := runMigrations(ds); err != nil {
+		return nil, err
+	}
+	c, err := getMatchTable().GetAllByIndex("gametype", benchGameType).Count().Run(ds.session)
+	if err != nil {
+		return nil, err
+	}
+	var seeded int
+	err = c.One(&seeded)
+	c.Close()
+	if err != nil || seeded >= benchMatches {
+		return ds, err
+	}
+
+	players := []Player{}
+	for i := 0; i < benchPlayers; i++ {
+		players = append(players, Player{
+			ID:       benchPlayerID(i),
+			Nickname: "Bench " + strconv.Itoa(i),
+			URLPath:  "bench-" + strconv.Itoa(i),
+		})
+	}
+	opts := r.InsertOpts{Conflict: "replace"}
+	if _, err := getPlayerTable().Insert(players, opts).RunWrite(ds.session); err != nil {
+		return nil, err
+	}
+	if ds.FetchUserByEmail(benchEmail) == nil {
+		ds.AddUser(User{Email: benchEmail})
+	}
+
+	// Matches are spread over three years between random pairs of players
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	batch := []*Match{}
+	for i := seeded; i < benchMatches; i++ {
+		p1 := rnd.Intn(benchPlayers)
+		p2 := (p1 + 1 + rnd.Intn(benchPlayers-1)) % benchPlayers
+		batch = append(batch, &Match{
+			GameType:     benchGameType,
+			Date:         start.Add(time.Duration(rnd.Intn(3*365*24)) * time.Hour),
+			Player1:      benchPlayerID(p1),
+			Player2:      benchPlayerID(p2),
+			Player1score: rnd.Intn(3),
+			Player2score: rnd.Intn(3),
+		})
+		if len(batch) == 1000 || i == benchMatches-1 {
+			if err := ds.AddMatches(batch); err != nil {
+				return nil, err
+			}
+			batch = []*Match{}
+		}
+	}
+	return ds, nil
+}
+
+func runBenchQuery(b *testing.B, ds *RethinkDataStore, query r.Term, dest interface{}) {
+	c, err := query.Run(ds.session)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.All(dest); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkRethinkMatchesForPlayerFilter(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	id := benchPlayerID(0)
+	for i := 0; i < b.N; i++ {
+		var matches []Match
+		runBenchQuery(b, ds, getMatchTable().Filter(
+			r.Or(r.Row.Field("player1").Eq(id), r.Row.Field("player2").Eq(id)),
+		).OrderBy(r.Desc("date")), &matches)
+	}
+}
+
+func BenchmarkRethinkMatchesForPlayer(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		ds.FetchMatchesForPlayer(benchPlayerID(0), true)
+	}
+}
+
+func BenchmarkRethinkMatchesForPlayersFilter(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	p1, p2 := benchPlayerID(0), benchPlayerID(1)
+	for i := 0; i < b.N; i++ {
+		var matches []Match
+		runBenchQuery(b, ds, getMatchTable().Filter(r.Or(
+			r.Row.Field("player1").Eq(p1).And(r.Row.Field("player2").Eq(p2)),
+			r.Row.Field("player1").Eq(p2).And(r.Row.Field("player2").Eq(p1)),
+		)).OrderBy(r.Desc("date")), &matches)
+	}
+}
+
+func BenchmarkRethinkMatchesForPlayers(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		ds.FetchMatchesForPlayers(benchPlayerID(0), benchPlayerID(1), true)
+	}
+}
+
+func BenchmarkRethinkPlayerByURLPathFilter(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		var players []Player
+		runBenchQuery(b, ds, getPlayerTable().Filter(map[string]interface{}{
+			"urlpath": "bench-0",
+		}), &players)
+	}
+}
+
+func BenchmarkRethinkPlayerByURLPath(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		ds.FetchPlayerByURLPath("bench-0")
+	}
+}
+
+func BenchmarkRethinkUserByEmailFilter(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		var users []User
+		runBenchQuery(b, ds, getUserTable().Filter(map[string]interface{}{
+			"email": benchEmail,
+		}), &users)
+	}
+}
+
+func BenchmarkRethinkUserByEmail(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		ds.FetchUserByEmail(benchEmail)
+	}
+}
+
+// The rankings are rebuilt from every match of a game type in date order.
+func BenchmarkRethinkMatchesForGameTypeFilter(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		var matches []Match
+		runBenchQuery(b, ds, getMatchTable().Filter(map[string]interface{}{
+			"gametype": benchGameType,
+		}).OrderBy("date"), &matches)
+	}
+}
+
+func BenchmarkRethinkMatchesForGameType(b *testing.B) {
+	ds := rethinkBenchStore(b)
+	for i := 0; i < b.N; i++ {
+		ds.FetchMatchesForGameType(benchGameType, true)
+	}
+}
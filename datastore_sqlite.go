@@ -0,0 +1,1196 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/mattn/go-sqlite3"
+	"gopkg.in/dancannon/gorethink.v2/types"
+)
+
+func init() {
+	// Register a driver with a REGEXP function so player search can use the
+	// same patterns as RethinkDB's match.
+	sql.Register("sqlite3_velvetdb", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", func(pattern, s string) (bool, error) {
+				return regexp.MatchString(pattern, s)
+			}, true)
+		},
+	})
+}
+
+// SQLiteDataStore stores everything in a single SQLite database file.
+type SQLiteDataStore struct {
+	db *sql.DB
+}
+
+func newSQLiteDataStore(path string) (*SQLiteDataStore, error) {
+	if path == "" {
+		path = "velvetdb.sqlite"
+	}
+	// No foreign keys are declared, since a match's tournament can be empty.
+	// MergePlayers and DeleteTournament keep references consistent instead.
+	db, err := sql.Open("sqlite3_velvetdb", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time, so don't bother pooling.
+	db.SetMaxOpenConns(1)
+	return &SQLiteDataStore{db: db}, nil
+}
+
+func (ds *SQLiteDataStore) GetID() string {
+	return newUUID()
+}
+
+func (ds *SQLiteDataStore) NewSessionStore(keyPairs ...[]byte) (sessions.Store, error) {
+	return newSQLiteSessionStore(ds.db, keyPairs...), nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func encodeStrings(s []string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func decodeStrings(s string) []string {
+	var out []string
+	json.Unmarshal([]byte(s), &out)
+	return out
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// inTx runs fn in a transaction, rolling back if it returns an error.
+func (ds *SQLiteDataStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execOne runs an update that must affect exactly one row.
+func (ds *SQLiteDataStore) execOne(query string, args ...interface{}) error {
+	res, err := ds.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// Players
+
+const playerColumns = `id, nickname, tag, aliases, image, urlpath, first_name, last_name,
+	facts, characters, twitter, twitch, city, state, lat, lon`
+
+func scanPlayer(s rowScanner) (*Player, error) {
+	var p Player
+	var aliases, facts, characters string
+	err := s.Scan(&p.ID, &p.Nickname, &p.Tag, &aliases, &p.Image, &p.URLPath, &p.FirstName, &p.LastName,
+		&facts, &characters, &p.Twitter, &p.Twitch, &p.City, &p.State, &p.Location.Lat, &p.Location.Lon)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Aliases = decodeStrings(aliases)
+	p.Facts = decodeStrings(facts)
+	p.Characters = decodeStrings(characters)
+	return &p, nil
+}
+
+func (ds *SQLiteDataStore) queryPlayers(query string, args ...interface{}) ([]*Player, error) {
+	rows, err := ds.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	players := []*Player{}
+	for rows.Next() {
+		p, err := scanPlayer(rows)
+		if err != nil {
+			return nil, err
+		}
+		players = append(players, p)
+	}
+	return players, rows.Err()
+}
+
+func (ds *SQLiteDataStore) AddPlayer(p Player) string {
+	if p.ID == "" {
+		p.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO players (`+playerColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Nickname, p.Tag, encodeStrings(p.Aliases), p.Image, p.URLPath, p.FirstName, p.LastName,
+		encodeStrings(p.Facts), encodeStrings(p.Characters), p.Twitter, p.Twitch, p.City, p.State,
+		p.Location.Lat, p.Location.Lon)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return p.ID
+}
+
+func (ds *SQLiteDataStore) UpdatePlayer(p *Player) error {
+	return ds.execOne(`UPDATE players SET nickname = ?, tag = ?, aliases = ?, image = ?, urlpath = ?,
+		first_name = ?, last_name = ?, facts = ?, characters = ?, twitter = ?, twitch = ?,
+		city = ?, state = ?, lat = ?, lon = ? WHERE id = ?`,
+		p.Nickname, p.Tag, encodeStrings(p.Aliases), p.Image, p.URLPath, p.FirstName, p.LastName,
+		encodeStrings(p.Facts), encodeStrings(p.Characters), p.Twitter, p.Twitch, p.City, p.State,
+		p.Location.Lat, p.Location.Lon, p.ID)
+}
+
+func (ds *SQLiteDataStore) MergePlayers(keepID string, mergeID string) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		stmts := []string{
+			`UPDATE matches SET player1 = ? WHERE player1 = ?`,
+			`UPDATE matches SET player2 = ? WHERE player2 = ?`,
+			`UPDATE tournamentresults SET player = ? WHERE player = ?`,
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt, keepID, mergeID); err != nil {
+				return err
+			}
+		}
+		if err := mergeSQLiteHandles(tx, keepID, mergeID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM players WHERE id = ?`, mergeID)
+		return err
+	})
+}
+
+// mergeSQLiteHandles re-points a merged player's participant handles at the
+// player they were merged into.
+func mergeSQLiteHandles(tx *sql.Tx, keepID string, mergeID string) error {
+	rows, err := tx.Query(`SELECT `+participantHandleColumns+` FROM participanthandles WHERE player = ?`, mergeID)
+	if err != nil {
+		return err
+	}
+	merged := []ParticipantHandle{}
+	for rows.Next() {
+		var h ParticipantHandle
+		if err := rows.Scan(&h.ID, &h.Handle, &h.Player, &h.LastSeen); err != nil {
+			rows.Close()
+			return err
+		}
+		merged = append(merged, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range merged {
+		var kept *ParticipantHandle
+		var k ParticipantHandle
+		err := tx.QueryRow(`SELECT `+participantHandleColumns+` FROM participanthandles WHERE id = ?`,
+			participantHandleID(h.Handle, keepID)).Scan(&k.ID, &k.Handle, &k.Player, &k.LastSeen)
+		switch {
+		case err == nil:
+			kept = &k
+		case err != sql.ErrNoRows:
+			return err
+		}
+		h = mergedHandle(h, keepID, kept)
+		_, err = tx.Exec(`INSERT OR REPLACE INTO participanthandles (`+participantHandleColumns+`)
+			VALUES (?, ?, ?, ?)`,
+			h.ID, h.Handle, h.Player, h.LastSeen.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM participanthandles WHERE player = ?`, mergeID)
+	return err
+}
+
+func (ds *SQLiteDataStore) FetchPlayer(id string) (*Player, error) {
+	return scanPlayer(ds.db.QueryRow(`SELECT `+playerColumns+` FROM players WHERE id = ?`, id))
+}
+
+func (ds *SQLiteDataStore) FetchPlayerByNickname(nickname string) (*Player, error) {
+	return scanPlayer(ds.db.QueryRow(`SELECT `+playerColumns+` FROM players WHERE nickname = ?`, nickname))
+}
+
+func (ds *SQLiteDataStore) FetchPlayerByURLPath(urlpath string) (*Player, error) {
+	return scanPlayer(ds.db.QueryRow(`SELECT `+playerColumns+` FROM players WHERE urlpath = ?`, urlpath))
+}
+
+func (ds *SQLiteDataStore) FetchPlayersSearch(nickname string) ([]*Player, error) {
+	return ds.queryPlayers(`SELECT `+playerColumns+` FROM players
+		WHERE nickname REGEXP ? ORDER BY nickname LIMIT 10`, "(?i)"+nickname)
+}
+
+func (ds *SQLiteDataStore) FetchPlayers() []Player {
+	ps, err := ds.queryPlayers(`SELECT ` + playerColumns + ` FROM players ORDER BY nickname`)
+	if err != nil {
+		fmt.Println(err)
+	}
+	players := []Player{}
+	for _, p := range ps {
+		players = append(players, *p)
+	}
+	return players
+}
+
+// Game types
+
+const gameTypeColumns = `id, name, urlpath, rating_system, k_factor, start_rating, result_mode,
+	tournament_weight, casual_weight, max_deviation, min_matches, min_opponents, min_tournaments,
+	active_months, decay_per_month, tiers, weight_by_tier`
+
+func scanGameType(s rowScanner) (*GameType, error) {
+	var gt GameType
+	var tiers string
+	err := s.Scan(&gt.ID, &gt.Name, &gt.URLPath, &gt.RatingSystem, &gt.KFactor, &gt.StartRating, &gt.ResultMode,
+		&gt.TournamentWeight, &gt.CasualWeight, &gt.MaxDeviation, &gt.MinMatches, &gt.MinOpponents,
+		&gt.MinTournaments, &gt.ActiveMonths, &gt.DecayPerMonth, &tiers, &gt.WeightByTier)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(tiers), &gt.Tiers)
+	return &gt, nil
+}
+
+func encodeTiers(tiers []TournamentTier) string {
+	if tiers == nil {
+		tiers = []TournamentTier{}
+	}
+	b, _ := json.Marshal(tiers)
+	return string(b)
+}
+
+func (ds *SQLiteDataStore) AddGameType(gt GameType) string {
+	if gt.ID == "" {
+		gt.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO gametypes (`+gameTypeColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		gt.ID, gt.Name, gt.URLPath, gt.RatingSystem, gt.KFactor, gt.StartRating, gt.ResultMode,
+		gt.TournamentWeight, gt.CasualWeight, gt.MaxDeviation, gt.MinMatches, gt.MinOpponents,
+		gt.MinTournaments, gt.ActiveMonths, gt.DecayPerMonth, encodeTiers(gt.Tiers), gt.WeightByTier)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return gt.ID
+}
+
+func (ds *SQLiteDataStore) UpdateGameType(gt *GameType) error {
+	return ds.execOne(`UPDATE gametypes SET name = ?, urlpath = ?, rating_system = ?, k_factor = ?,
+		start_rating = ?, result_mode = ?, tournament_weight = ?, casual_weight = ?, max_deviation = ?,
+		min_matches = ?, min_opponents = ?, min_tournaments = ?, active_months = ?, decay_per_month = ?,
+		tiers = ?, weight_by_tier = ? WHERE id = ?`,
+		gt.Name, gt.URLPath, gt.RatingSystem, gt.KFactor, gt.StartRating, gt.ResultMode,
+		gt.TournamentWeight, gt.CasualWeight, gt.MaxDeviation, gt.MinMatches, gt.MinOpponents,
+		gt.MinTournaments, gt.ActiveMonths, gt.DecayPerMonth, encodeTiers(gt.Tiers), gt.WeightByTier, gt.ID)
+}
+
+func (ds *SQLiteDataStore) FetchGameType(id string) (*GameType, error) {
+	return scanGameType(ds.db.QueryRow(`SELECT `+gameTypeColumns+` FROM gametypes WHERE id = ?`, id))
+}
+
+func (ds *SQLiteDataStore) FetchGameTypeByURLPath(urlpath string) (*GameType, error) {
+	return scanGameType(ds.db.QueryRow(`SELECT `+gameTypeColumns+` FROM gametypes WHERE urlpath = ?`, urlpath))
+}
+
+func (ds *SQLiteDataStore) FetchGameTypes() []GameType {
+	gameTypes := []GameType{}
+	rows, err := ds.db.Query(`SELECT ` + gameTypeColumns + ` FROM gametypes ORDER BY name`)
+	if err != nil {
+		fmt.Println(err)
+		return gameTypes
+	}
+	defer rows.Close()
+	for rows.Next() {
+		gt, err := scanGameType(rows)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		gameTypes = append(gameTypes, *gt)
+	}
+	return gameTypes
+}
+
+// Matches
+
+const matchColumns = `id, tournament, tournament_match_id, p1_prev_tm, p2_prev_tm, gametype, date,
+	player1, player2, player1_score, player2_score, round, hidden, upset_rating, upset_seed`
+
+func scanMatch(s rowScanner) (*Match, error) {
+	var m Match
+	var p1Prev, p2Prev sql.NullString
+	err := s.Scan(&m.ID, &m.Tournament, &m.TournamentMatchID, &p1Prev, &p2Prev, &m.GameType, &m.Date,
+		&m.Player1, &m.Player2, &m.Player1score, &m.Player2score, &m.Round, &m.Hidden, &m.UpsetRating, &m.UpsetSeed)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Player1PrevTournamentMatch = nullString(p1Prev)
+	m.Player2PrevTournamentMatch = nullString(p2Prev)
+	return &m, nil
+}
+
+func (ds *SQLiteDataStore) queryMatches(query string, args ...interface{}) []Match {
+	matches := []Match{}
+	rows, err := ds.db.Query(query, args...)
+	if err != nil {
+		fmt.Println(err)
+		return matches
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMatch(rows)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		matches = append(matches, *m)
+	}
+	return matches
+}
+
+func insertMatch(e interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, m *Match) error {
+	_, err := e.Exec(`INSERT INTO matches (`+matchColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.Tournament, m.TournamentMatchID, m.Player1PrevTournamentMatch, m.Player2PrevTournamentMatch,
+		m.GameType, m.Date.UTC(), m.Player1, m.Player2, m.Player1score, m.Player2score, m.Round, m.Hidden,
+		m.UpsetRating, m.UpsetSeed)
+	return err
+}
+
+func (ds *SQLiteDataStore) AddMatch(m Match) string {
+	if m.ID == "" {
+		m.ID = newUUID()
+	}
+	if err := insertMatch(ds.db, &m); err != nil {
+		fmt.Println(err)
+	}
+	return m.ID
+}
+
+func (ds *SQLiteDataStore) AddMatches(ms []*Match) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		for _, m := range ms {
+			if m.ID == "" {
+				m.ID = newUUID()
+			}
+			if err := insertMatch(tx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDataStore) UpdateMatch(m *Match) error {
+	return ds.execOne(`UPDATE matches SET tournament = ?, tournament_match_id = ?, p1_prev_tm = ?, p2_prev_tm = ?,
+		gametype = ?, date = ?, player1 = ?, player2 = ?, player1_score = ?, player2_score = ?,
+		round = ?, hidden = ?, upset_rating = ?, upset_seed = ? WHERE id = ?`,
+		m.Tournament, m.TournamentMatchID, m.Player1PrevTournamentMatch, m.Player2PrevTournamentMatch,
+		m.GameType, m.Date.UTC(), m.Player1, m.Player2, m.Player1score, m.Player2score, m.Round, m.Hidden,
+		m.UpsetRating, m.UpsetSeed, m.ID)
+}
+
+func (ds *SQLiteDataStore) DeleteMatch(id string) error {
+	return ds.execOne(`DELETE FROM matches WHERE id = ?`, id)
+}
+
+func (ds *SQLiteDataStore) FetchMatch(id string) (*Match, error) {
+	return scanMatch(ds.db.QueryRow(`SELECT `+matchColumns+` FROM matches WHERE id = ?`, id))
+}
+
+// hiddenClause limits a match query to visible matches unless includeHidden
+// is set.
+func hiddenClause(includeHidden bool) string {
+	if includeHidden {
+		return ""
+	}
+	return " AND hidden = 0"
+}
+
+func (ds *SQLiteDataStore) FetchMatchesForPlayer(id string, includeHidden bool) []Match {
+	return ds.queryMatches(`SELECT `+matchColumns+` FROM matches
+		WHERE (player1 = ? OR player2 = ?)`+hiddenClause(includeHidden)+` ORDER BY date DESC`, id, id)
+}
+
+func (ds *SQLiteDataStore) FetchMatchesForPlayers(p1 string, p2 string, includeHidden bool) *[]Match {
+	matches := ds.queryMatches(`SELECT `+matchColumns+` FROM matches
+		WHERE ((player1 = ? AND player2 = ?) OR (player1 = ? AND player2 = ?))`+hiddenClause(includeHidden)+`
+		ORDER BY date DESC`, p1, p2, p2, p1)
+	return &matches
+}
+
+func (ds *SQLiteDataStore) FetchMatchesForTournament(id string, includeHidden bool) []Match {
+	return ds.queryMatches(`SELECT `+matchColumns+` FROM matches
+		WHERE tournament = ?`+hiddenClause(includeHidden)+` ORDER BY date`, id)
+}
+
+func (ds *SQLiteDataStore) FetchMatchesForGameType(gameType string, includeHidden bool) []Match {
+	return ds.queryMatches(`SELECT `+matchColumns+` FROM matches
+		WHERE gametype = ?`+hiddenClause(includeHidden)+` ORDER BY date`, gameType)
+}
+
+func (ds *SQLiteDataStore) FetchMatchesForGameTypeBetween(gameType string, start time.Time, end time.Time, includeHidden bool) []Match {
+	return ds.queryMatches(`SELECT `+matchColumns+` FROM matches
+		WHERE gametype = ? AND date >= ? AND date < ?`+hiddenClause(includeHidden)+` ORDER BY date`,
+		gameType, start.UTC(), end.UTC())
+}
+
+// Tournaments
+
+const tournamentColumns = `id, gametype, name, bracket_url, vod_url, pool_of, date_start, date_end,
+	city, state, lat, lon, player_count, editing, strength, tier`
+
+// prefixColumns qualifies each column in a column list with a table alias.
+func prefixColumns(alias string, columns string) string {
+	cols := strings.Split(columns, ",")
+	for i, c := range cols {
+		cols[i] = alias + "." + strings.TrimSpace(c)
+	}
+	return strings.Join(cols, ", ")
+}
+
+func scanTournament(s rowScanner) (*Tournament, error) {
+	var t Tournament
+	err := s.Scan(&t.ID, &t.GameType, &t.Name, &t.BracketURL, &t.VODUrl, &t.PoolOf, &t.DateStart, &t.DateEnd,
+		&t.City, &t.State, &t.Location.Lat, &t.Location.Lon, &t.PlayerCount, &t.Editing,
+		&t.Strength, &t.Tier)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (ds *SQLiteDataStore) queryTournaments(query string, args ...interface{}) ([]*Tournament, error) {
+	rows, err := ds.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ts := []*Tournament{}
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
+
+func (ds *SQLiteDataStore) AddTournament(t Tournament) string {
+	if t.ID == "" {
+		t.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO tournaments (`+tournamentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.GameType, t.Name, t.BracketURL, t.VODUrl, t.PoolOf, t.DateStart.UTC(), t.DateEnd.UTC(),
+		t.City, t.State, t.Location.Lat, t.Location.Lon, t.PlayerCount, t.Editing, t.Strength, t.Tier)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return t.ID
+}
+
+func (ds *SQLiteDataStore) UpdateTournament(t *Tournament) error {
+	return ds.execOne(`UPDATE tournaments SET gametype = ?, name = ?, bracket_url = ?, vod_url = ?, pool_of = ?,
+		date_start = ?, date_end = ?, city = ?, state = ?, lat = ?, lon = ?, player_count = ?, editing = ?,
+		strength = ?, tier = ? WHERE id = ?`,
+		t.GameType, t.Name, t.BracketURL, t.VODUrl, t.PoolOf, t.DateStart.UTC(), t.DateEnd.UTC(),
+		t.City, t.State, t.Location.Lat, t.Location.Lon, t.PlayerCount, t.Editing, t.Strength, t.Tier, t.ID)
+}
+
+func (ds *SQLiteDataStore) DeleteTournament(id string) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		stmts := []string{
+			`DELETE FROM matches WHERE tournament = ?`,
+			`DELETE FROM tournamentresults WHERE tournament = ?`,
+			`DELETE FROM uploadedbrackets WHERE id = ?`,
+			`DELETE FROM nativebrackets WHERE id = ?`,
+			`DELETE FROM tournaments WHERE id = ?`,
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDataStore) FetchTournament(id string) (*Tournament, error) {
+	return scanTournament(ds.db.QueryRow(`SELECT `+tournamentColumns+` FROM tournaments WHERE id = ?`, id))
+}
+
+func (ds *SQLiteDataStore) FetchTournamentByBracketURL(url string) (*Tournament, error) {
+	return scanTournament(ds.db.QueryRow(`SELECT `+tournamentColumns+` FROM tournaments
+		WHERE bracket_url = ? LIMIT 1`, url))
+}
+
+func (ds *SQLiteDataStore) FetchTournaments(gametype string, editing bool) (*[]Tournament, error) {
+	query := `SELECT ` + tournamentColumns + ` FROM tournaments WHERE pool_of = ''`
+	args := []interface{}{}
+	if gametype != "" {
+		query += ` AND gametype = ?`
+		args = append(args, gametype)
+	}
+	if !editing {
+		query += ` AND editing = 0`
+	}
+	ts, err := ds.queryTournaments(query+` ORDER BY date_start DESC`, args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	t := []Tournament{}
+	for _, tournament := range ts {
+		t = append(t, *tournament)
+	}
+	return &t, nil
+}
+
+func (ds *SQLiteDataStore) FetchTournamentPools(id string) ([]*Tournament, error) {
+	return ds.queryTournaments(`SELECT `+tournamentColumns+` FROM tournaments
+		WHERE pool_of = ? ORDER BY name`, id)
+}
+
+func (ds *SQLiteDataStore) FetchTournamentTiers(gametype string) (map[string]string, error) {
+	rows, err := ds.db.Query(`SELECT id, tier FROM tournaments WHERE gametype = ?`, gametype)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tiers := map[string]string{}
+	for rows.Next() {
+		var id, tier string
+		if err := rows.Scan(&id, &tier); err != nil {
+			return nil, err
+		}
+		tiers[id] = tier
+	}
+	return tiers, rows.Err()
+}
+
+func (ds *SQLiteDataStore) FetchTournamentsForPlayer(id string) ([]*Tournament, error) {
+	return ds.queryTournaments(`SELECT `+prefixColumns("t", tournamentColumns)+` FROM tournaments t
+		WHERE t.id IN (SELECT tournament FROM matches WHERE player1 = ? OR player2 = ?)`, id, id)
+}
+
+func (ds *SQLiteDataStore) FetchTournamentsForPlayers(player1 string, player2 string) ([]*Tournament, error) {
+	return ds.queryTournaments(`SELECT `+prefixColumns("t", tournamentColumns)+` FROM tournaments t
+		WHERE t.id IN (SELECT tournament FROM matches
+			WHERE (player1 = ? AND player2 = ?) OR (player1 = ? AND player2 = ?))`,
+		player1, player2, player2, player1)
+}
+
+// Tournament results
+
+const tournamentResultColumns = `id, tournament, player, seed, placement`
+
+func scanTournamentResult(s rowScanner) (*TournamentResult, error) {
+	var tr TournamentResult
+	err := s.Scan(&tr.ID, &tr.TournamentID, &tr.Player, &tr.Seed, &tr.Place)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tr, nil
+}
+
+func (ds *SQLiteDataStore) AddTournamentResults(rs []*TournamentResult) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		for _, tr := range rs {
+			if tr.ID == "" {
+				tr.ID = newUUID()
+			}
+			_, err := tx.Exec(`INSERT INTO tournamentresults (`+tournamentResultColumns+`)
+				VALUES (?, ?, ?, ?, ?)`, tr.ID, tr.TournamentID, tr.Player, tr.Seed, tr.Place)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDataStore) UpdateTournamentResult(tr *TournamentResult) error {
+	return ds.execOne(`UPDATE tournamentresults SET tournament = ?, player = ?, seed = ?, placement = ?
+		WHERE id = ?`, tr.TournamentID, tr.Player, tr.Seed, tr.Place, tr.ID)
+}
+
+func (ds *SQLiteDataStore) FetchTournamentResult(id string) (*TournamentResult, error) {
+	return scanTournamentResult(ds.db.QueryRow(`SELECT `+tournamentResultColumns+`
+		FROM tournamentresults WHERE id = ?`, id))
+}
+
+func (ds *SQLiteDataStore) FetchResultsForTournament(tournamentID string) ([]*TournamentResult, error) {
+	rows, err := ds.db.Query(`SELECT `+tournamentResultColumns+` FROM tournamentresults
+		WHERE tournament = ? ORDER BY placement`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*TournamentResult{}
+	for rows.Next() {
+		tr, err := scanTournamentResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, tr)
+	}
+	return results, rows.Err()
+}
+
+func (ds *SQLiteDataStore) FetchResultsForPlayer(playerID string) ([]*TournamentResult, error) {
+	rows, err := ds.db.Query(`SELECT `+prefixColumns("r", tournamentResultColumns)+`, `+
+		prefixColumns("t", tournamentColumns)+`
+		FROM tournamentresults r JOIN tournaments t ON t.id = r.tournament
+		WHERE r.player = ? ORDER BY t.date_start DESC`, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*TournamentResult{}
+	for rows.Next() {
+		var tr TournamentResult
+		var t Tournament
+		err := rows.Scan(&tr.ID, &tr.TournamentID, &tr.Player, &tr.Seed, &tr.Place,
+			&t.ID, &t.GameType, &t.Name, &t.BracketURL, &t.VODUrl, &t.PoolOf, &t.DateStart, &t.DateEnd,
+			&t.City, &t.State, &t.Location.Lat, &t.Location.Lon, &t.PlayerCount, &t.Editing,
+			&t.Strength, &t.Tier)
+		if err != nil {
+			return nil, err
+		}
+		tr.Tournament = &t
+		results = append(results, &tr)
+	}
+	return results, rows.Err()
+}
+
+// Ratings
+
+const ratingColumns = `id, player, gametype, rating, mean, deviation, volatility, matches, last_match`
+
+func scanRating(s rowScanner) (*Rating, error) {
+	var r Rating
+	err := s.Scan(&r.ID, &r.Player, &r.GameType, &r.Rating, &r.Mean, &r.Deviation, &r.Volatility,
+		&r.Matches, &r.LastMatch)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (ds *SQLiteDataStore) FetchRatings(gameType string) ([]*Rating, error) {
+	rows, err := ds.db.Query(`SELECT `+ratingColumns+` FROM ratings WHERE gametype = ?`, gameType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ratings := []*Rating{}
+	for rows.Next() {
+		r, err := scanRating(rows)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
+func (ds *SQLiteDataStore) FetchRating(gameType string, player string) (*Rating, error) {
+	return scanRating(ds.db.QueryRow(`SELECT `+ratingColumns+` FROM ratings WHERE id = ?`,
+		ratingID(gameType, player)))
+}
+
+func saveRatings(tx *sql.Tx, rs []*Rating) error {
+	for _, r := range rs {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO ratings (`+ratingColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.ID, r.Player, r.GameType, r.Rating, r.Mean, r.Deviation, r.Volatility, r.Matches,
+			r.LastMatch.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *SQLiteDataStore) SaveRatings(rs []*Rating) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		return saveRatings(tx, rs)
+	})
+}
+
+func (ds *SQLiteDataStore) ReplaceRatings(gameType string, rs []*Rating) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM ratings WHERE gametype = ?`, gameType); err != nil {
+			return err
+		}
+		return saveRatings(tx, rs)
+	})
+}
+
+const ratingChangeColumns = `id, player, gametype, match_id, opponent, rating_before, rating_after, matches, date`
+
+func scanRatingChange(s rowScanner) (*RatingChange, error) {
+	var c RatingChange
+	err := s.Scan(&c.ID, &c.Player, &c.GameType, &c.Match, &c.Opponent, &c.Before, &c.After,
+		&c.Matches, &c.Date)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func addRatingChanges(tx *sql.Tx, cs []*RatingChange) error {
+	for _, c := range cs {
+		if c.ID == "" {
+			c.ID = newUUID()
+		}
+		_, err := tx.Exec(`INSERT INTO ratingchanges (`+ratingChangeColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, c.Player, c.GameType, c.Match, c.Opponent, c.Before, c.After, c.Matches, c.Date.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *SQLiteDataStore) AddRatingChanges(cs []*RatingChange) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		return addRatingChanges(tx, cs)
+	})
+}
+
+func (ds *SQLiteDataStore) ReplaceRatingChanges(gameType string, cs []*RatingChange) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM ratingchanges WHERE gametype = ?`, gameType); err != nil {
+			return err
+		}
+		return addRatingChanges(tx, cs)
+	})
+}
+
+func (ds *SQLiteDataStore) FetchRatingChanges(gameType string, player string) ([]*RatingChange, error) {
+	rows, err := ds.db.Query(`SELECT `+ratingChangeColumns+` FROM ratingchanges
+		WHERE gametype = ? AND player = ? ORDER BY matches`, gameType, player)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []*RatingChange{}
+	for rows.Next() {
+		c, err := scanRatingChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// Participant handles
+
+const participantHandleColumns = `id, handle, player, last_seen`
+
+func (ds *SQLiteDataStore) SaveParticipantHandles(hs []*ParticipantHandle) error {
+	return ds.inTx(func(tx *sql.Tx) error {
+		for _, h := range hs {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO participanthandles (`+participantHandleColumns+`)
+				VALUES (?, ?, ?, ?)`,
+				h.ID, h.Handle, h.Player, h.LastSeen.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDataStore) FetchParticipantHandles(handle string) ([]*ParticipantHandle, error) {
+	rows, err := ds.db.Query(`SELECT `+participantHandleColumns+` FROM participanthandles WHERE handle = ?`, handle)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hs := []*ParticipantHandle{}
+	for rows.Next() {
+		var h ParticipantHandle
+		if err := rows.Scan(&h.ID, &h.Handle, &h.Player, &h.LastSeen); err != nil {
+			return nil, err
+		}
+		hs = append(hs, &h)
+	}
+	return hs, rows.Err()
+}
+
+// Uploaded brackets
+
+func (ds *SQLiteDataStore) SaveUploadedBracket(ub *UploadedBracket) error {
+	_, err := ds.db.Exec(`INSERT OR REPLACE INTO uploadedbrackets (id, data, uploaded_at) VALUES (?, ?, ?)`,
+		ub.ID, ub.Data, ub.UploadedAt.UTC())
+	return err
+}
+
+func (ds *SQLiteDataStore) FetchUploadedBracket(tournamentID string) (*UploadedBracket, error) {
+	var ub UploadedBracket
+	err := ds.db.QueryRow(`SELECT id, data, uploaded_at FROM uploadedbrackets WHERE id = ?`, tournamentID).
+		Scan(&ub.ID, &ub.Data, &ub.UploadedAt)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ub, nil
+}
+
+// Native brackets
+
+// Native brackets are only ever read and written whole, so they're stored as
+// JSON.
+func (ds *SQLiteDataStore) SaveNativeBracket(nb *NativeBracket) error {
+	data, err := json.Marshal(nb)
+	if err != nil {
+		return err
+	}
+	_, err = ds.db.Exec(`INSERT OR REPLACE INTO nativebrackets (id, data) VALUES (?, ?)`, nb.ID, string(data))
+	return err
+}
+
+func (ds *SQLiteDataStore) FetchNativeBracket(tournamentID string) (*NativeBracket, error) {
+	var data string
+	err := ds.db.QueryRow(`SELECT data FROM nativebrackets WHERE id = ?`, tournamentID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var nb NativeBracket
+	if err := json.Unmarshal([]byte(data), &nb); err != nil {
+		return nil, err
+	}
+	return &nb, nil
+}
+
+// Circuits
+
+const circuitColumns = `id, name, urlpath, gametype, tournaments, date_start, date_end, min_entrants,
+	points, scale_entrants, best_of, weight_by_tier, tiers`
+
+func scanCircuit(s rowScanner) (*Circuit, error) {
+	var c Circuit
+	var tournaments, points, tiers string
+	err := s.Scan(&c.ID, &c.Name, &c.URLPath, &c.GameType, &tournaments, &c.DateStart, &c.DateEnd,
+		&c.MinEntrants, &points, &c.ScaleEntrants, &c.BestOf, &c.WeightByTier, &tiers)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Tournaments = decodeStrings(tournaments)
+	c.Tiers = decodeStrings(tiers)
+	json.Unmarshal([]byte(points), &c.Points)
+	return &c, nil
+}
+
+func encodeCircuitPoints(points []CircuitPoints) string {
+	b, _ := json.Marshal(points)
+	return string(b)
+}
+
+func (ds *SQLiteDataStore) AddCircuit(c Circuit) string {
+	if c.ID == "" {
+		c.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO circuits (`+circuitColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Name, c.URLPath, c.GameType, encodeStrings(c.Tournaments), c.DateStart.UTC(), c.DateEnd.UTC(),
+		c.MinEntrants, encodeCircuitPoints(c.Points), c.ScaleEntrants, c.BestOf, c.WeightByTier, encodeStrings(c.Tiers))
+	if err != nil {
+		fmt.Println(err)
+	}
+	return c.ID
+}
+
+func (ds *SQLiteDataStore) UpdateCircuit(c *Circuit) error {
+	return ds.execOne(`UPDATE circuits SET name = ?, urlpath = ?, gametype = ?, tournaments = ?,
+		date_start = ?, date_end = ?, min_entrants = ?, points = ?, scale_entrants = ?, best_of = ?,
+		weight_by_tier = ?, tiers = ? WHERE id = ?`,
+		c.Name, c.URLPath, c.GameType, encodeStrings(c.Tournaments), c.DateStart.UTC(), c.DateEnd.UTC(),
+		c.MinEntrants, encodeCircuitPoints(c.Points), c.ScaleEntrants, c.BestOf, c.WeightByTier,
+		encodeStrings(c.Tiers), c.ID)
+}
+
+func (ds *SQLiteDataStore) FetchCircuitByURLPath(urlpath string) (*Circuit, error) {
+	return scanCircuit(ds.db.QueryRow(`SELECT `+circuitColumns+` FROM circuits WHERE urlpath = ?`, urlpath))
+}
+
+func (ds *SQLiteDataStore) FetchCircuits() []Circuit {
+	circuits := []Circuit{}
+	rows, err := ds.db.Query(`SELECT ` + circuitColumns + ` FROM circuits ORDER BY name`)
+	if err != nil {
+		fmt.Println(err)
+		return circuits
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCircuit(rows)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		circuits = append(circuits, *c)
+	}
+	return circuits
+}
+
+// Regions
+
+const regionColumns = `id, name, urlpath, scope, lat, lon, radius, polygon`
+
+func scanRegion(s rowScanner) (*Region, error) {
+	var rg Region
+	var polygon string
+	err := s.Scan(&rg.ID, &rg.Name, &rg.URLPath, &rg.Scope, &rg.Center.Lat, &rg.Center.Lon, &rg.Radius,
+		&polygon)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rg.Polygon = decodePoints(polygon)
+	return &rg, nil
+}
+
+// encodePoints stores a list of points as JSON [lat, lon] pairs.
+func encodePoints(points []types.Point) string {
+	pairs := make([][2]float64, len(points))
+	for i, p := range points {
+		pairs[i] = [2]float64{p.Lat, p.Lon}
+	}
+	b, _ := json.Marshal(pairs)
+	return string(b)
+}
+
+func decodePoints(s string) []types.Point {
+	var pairs [][2]float64
+	json.Unmarshal([]byte(s), &pairs)
+	points := make([]types.Point, len(pairs))
+	for i, p := range pairs {
+		points[i] = types.Point{Lat: p[0], Lon: p[1]}
+	}
+	return points
+}
+
+func (ds *SQLiteDataStore) AddRegion(rg Region) string {
+	if rg.ID == "" {
+		rg.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO regions (`+regionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rg.ID, rg.Name, rg.URLPath, rg.Scope, rg.Center.Lat, rg.Center.Lon, rg.Radius, encodePoints(rg.Polygon))
+	if err != nil {
+		fmt.Println(err)
+	}
+	return rg.ID
+}
+
+func (ds *SQLiteDataStore) UpdateRegion(rg *Region) error {
+	return ds.execOne(`UPDATE regions SET name = ?, urlpath = ?, scope = ?, lat = ?, lon = ?, radius = ?,
+		polygon = ? WHERE id = ?`,
+		rg.Name, rg.URLPath, rg.Scope, rg.Center.Lat, rg.Center.Lon, rg.Radius, encodePoints(rg.Polygon), rg.ID)
+}
+
+func (ds *SQLiteDataStore) FetchRegionByURLPath(urlpath string) (*Region, error) {
+	return scanRegion(ds.db.QueryRow(`SELECT `+regionColumns+` FROM regions WHERE urlpath = ?`, urlpath))
+}
+
+func (ds *SQLiteDataStore) FetchRegions() []Region {
+	regions := []Region{}
+	rows, err := ds.db.Query(`SELECT ` + regionColumns + ` FROM regions ORDER BY name`)
+	if err != nil {
+		fmt.Println(err)
+		return regions
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rg, err := scanRegion(rows)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		regions = append(regions, *rg)
+	}
+	return regions
+}
+
+// Seasons
+
+const seasonColumns = `id, gametype, name, date_start, date_end, carry_over`
+
+func scanSeason(s rowScanner) (*Season, error) {
+	var season Season
+	err := s.Scan(&season.ID, &season.GameType, &season.Name, &season.DateStart, &season.DateEnd,
+		&season.CarryOver)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &season, nil
+}
+
+func (ds *SQLiteDataStore) AddSeason(s Season) string {
+	if s.ID == "" {
+		s.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO seasons (`+seasonColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		s.ID, s.GameType, s.Name, s.DateStart.UTC(), s.DateEnd.UTC(), s.CarryOver)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return s.ID
+}
+
+func (ds *SQLiteDataStore) UpdateSeason(s *Season) error {
+	return ds.execOne(`UPDATE seasons SET gametype = ?, name = ?, date_start = ?, date_end = ?, carry_over = ?
+		WHERE id = ?`, s.GameType, s.Name, s.DateStart.UTC(), s.DateEnd.UTC(), s.CarryOver, s.ID)
+}
+
+func (ds *SQLiteDataStore) FetchSeason(id string) (*Season, error) {
+	return scanSeason(ds.db.QueryRow(`SELECT `+seasonColumns+` FROM seasons WHERE id = ?`, id))
+}
+
+func (ds *SQLiteDataStore) FetchSeasons(gameType string) ([]*Season, error) {
+	rows, err := ds.db.Query(`SELECT `+seasonColumns+` FROM seasons WHERE gametype = ? ORDER BY date_start`,
+		gameType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seasons := []*Season{}
+	for rows.Next() {
+		s, err := scanSeason(rows)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	return seasons, rows.Err()
+}
+
+// Users
+
+const userColumns = `id, email, player, password, permission`
+
+func scanUser(s rowScanner) (*User, error) {
+	var u User
+	err := s.Scan(&u.ID, &u.Email, &u.Player, &u.Password, &u.PermissionLevel)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (ds *SQLiteDataStore) AddUser(u User) string {
+	if u.ID == "" {
+		u.ID = newUUID()
+	}
+	_, err := ds.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		u.ID, u.Email, u.Player, u.Password, u.PermissionLevel)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return u.ID
+}
+
+func (ds *SQLiteDataStore) UpdateUser(u *User) error {
+	return ds.execOne(`UPDATE users SET email = ?, player = ?, password = ?, permission = ? WHERE id = ?`,
+		u.Email, u.Player, u.Password, u.PermissionLevel, u.ID)
+}
+
+func (ds *SQLiteDataStore) FetchUserByEmail(email string) *User {
+	u, err := scanUser(ds.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return u
+}
+
+func (ds *SQLiteDataStore) FetchUsers() []User {
+	users := []User{}
+	rows, err := ds.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY email`)
+	if err != nil {
+		fmt.Println(err)
+		return users
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		users = append(users, *u)
+	}
+	return users
+}
+
+func (ds *SQLiteDataStore) CountUsers() (int, error) {
+	var count int
+	err := ds.db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
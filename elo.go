@@ -2,13 +2,35 @@ package main
 
 import "math"
 
+// The defaults for game types that don't set their own K factor or start
+// rating.
+const (
+	eloKFactor     = 32
+	eloStartRating = 1000
+)
+
 type Elo struct {
-	k float64
+	k     float64
+	start int
+	rules matchRules
+}
+
+func newElo(k float64, start int, rules matchRules) *Elo {
+	if k <= 0 {
+		k = eloKFactor
+	}
+	if start <= 0 {
+		start = eloStartRating
+	}
+	return &Elo{k: k, start: start, rules: rules}
 }
 
+// EloDict is a player's place in the rankings. Deviation is only set for
+// Glicko-2 game types.
 type EloDict struct {
-	Player Player
-	Rank   int
+	Player    Player
+	Rank      int
+	Deviation float64
 }
 
 type ByRank []*EloDict
@@ -23,7 +45,62 @@ func (a ByRank) Less(i, j int) bool {
 }
 
 func NewEloDict(p Player) *EloDict {
-	return &EloDict{Rank: 1000, Player: p}
+	return &EloDict{Rank: eloStartRating, Player: p}
+}
+
+func (e *Elo) NewRating(gameType string, player string) *Rating {
+	return &Rating{
+		ID:       ratingID(gameType, player),
+		Player:   player,
+		GameType: gameType,
+		Rating:   e.start,
+	}
+}
+
+func (e *Elo) Incremental() bool {
+	return true
+}
+
+func (e *Elo) Rate(gameType string, ratings map[string]*Rating, matches []Match) []*RatingChange {
+	changes := []*RatingChange{}
+	for _, m := range matches {
+		weight := e.rules.weight(m)
+		if weight == 0 {
+			continue
+		}
+		rs := ratingsFor(e, gameType, ratings, m.Player1, m.Player2)
+		changes = append(changes, e.rateMatch(rs[0], rs[1], m, weight)...)
+	}
+	return changes
+}
+
+// rateMatch applies the result of a match to both players' ratings and
+// returns the change for each of them. The weight scales the K factor.
+func (e *Elo) rateMatch(r1 *Rating, r2 *Rating, m Match, weight float64) []*RatingChange {
+	before1, before2 := r1.Rating, r2.Rating
+
+	expectedScore1 := e.getExpected(r1.Rating, r2.Rating)
+	expectedScore2 := e.getExpected(r2.Rating, r1.Rating)
+
+	player1results := e.rules.score(m)
+
+	r1.Rating = e.updateRating(expectedScore1, player1results, r1.Rating, weight)
+	r2.Rating = e.updateRating(expectedScore2, 1-player1results, r2.Rating, weight)
+
+	for _, r := range []*Rating{r1, r2} {
+		r.Matches++
+		r.LastMatch = m.Date
+	}
+	return []*RatingChange{
+		newRatingChange(r1, m, m.Player2, before1),
+		newRatingChange(r2, m, m.Player1, before2),
+	}
+}
+
+// Predict uses the expected score, which is the share of games won unless
+// only sets count.
+func (e *Elo) Predict(r1 *Rating, r2 *Rating) (float64, bool) {
+	return e.getExpected(r1.Rating, r2.Rating), e.rules.resultMode != resultModeSets
 }
 
 func (e *Elo) getExpected(a, b int) float64 {
@@ -46,6 +123,6 @@ func round(f float64) int {
 	return int(math.Floor(f + .5))
 }
 
-func (e *Elo) updateRating(expected float64, actual float64, current int) int {
-	return round(float64(current) + e.k*(actual-expected))
+func (e *Elo) updateRating(expected float64, actual float64, current int, weight float64) int {
+	return round(float64(current) + weight*e.k*(actual-expected))
 }
@@ -15,8 +15,11 @@ func faceoffHandler(w http.ResponseWriter, r *http.Request) {
 		Player1Games int
 		Player2Games int
 		Matches      []Match
+		Prediction   *Prediction
 	}
 
+	bestOf := readBestOf(r.FormValue("bestof"))
+
 	gameMatches := []GameTypeMatches{}
 	tournamentMap := map[string]*Tournament{}
 	var player1 *Player
@@ -24,20 +27,21 @@ func faceoffHandler(w http.ResponseWriter, r *http.Request) {
 	if p1 != "" && p2 != "" {
 		var err1 error
 		var err2 error
-		player1, err1 = fetchPlayerByURLPath(p1)
-		player2, err2 = fetchPlayerByURLPath(p2)
+		player1, err1 = dataStore.FetchPlayerByURLPath(p1)
+		player2, err2 = dataStore.FetchPlayerByURLPath(p2)
 		if err1 != nil || err2 != nil {
 			http.Redirect(w, r, "/faceoff", http.StatusFound)
+			return
 		}
 
 		_, logged := isLoggedIn(r)
-		matches := fetchMatchesForPlayers(player1.ID, player2.ID, logged)
+		matches := dataStore.FetchMatchesForPlayers(player1.ID, player2.ID, logged)
 
 		// sort matches by game
 		gameIndex := map[string]int{}
 		for _, m := range *matches {
 			if _, ok := gameIndex[m.GameType]; !ok {
-				gt, _ := fetchGameType(m.GameType)
+				gt, _ := dataStore.FetchGameType(m.GameType)
 				gameMatches = append(gameMatches, GameTypeMatches{
 					GameType: gt,
 					Matches:  []Match{},
@@ -68,8 +72,23 @@ func faceoffHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
+		// predict the next set in every game type either of them is rated in
+		for _, gt := range dataStore.FetchGameTypes() {
+			gt := gt
+			if _, ok := gameIndex[gt.ID]; !ok {
+				_, err1 := dataStore.FetchRating(gt.ID, player1.ID)
+				_, err2 := dataStore.FetchRating(gt.ID, player2.ID)
+				if err1 != nil && err2 != nil {
+					continue
+				}
+				gameMatches = append(gameMatches, GameTypeMatches{GameType: &gt, Matches: []Match{}})
+				gameIndex[gt.ID] = len(gameMatches) - 1
+			}
+			gameMatches[gameIndex[gt.ID]].Prediction = predictMatch(&gt, player1.ID, player2.ID, bestOf)
+		}
+
 		// build tournament map
-		ts, _ := fetchTournamentsForPlayers(player1.ID, player2.ID)
+		ts, _ := dataStore.FetchTournamentsForPlayers(player1.ID, player2.ID)
 		for _, t := range ts {
 			tournamentMap[t.ID] = t
 		}
@@ -79,11 +98,13 @@ func faceoffHandler(w http.ResponseWriter, r *http.Request) {
 		TournamentMap map[string]*Tournament
 		Player1       *Player
 		Player2       *Player
+		BestOf        int
 	}{
 		gameMatches,
 		tournamentMap,
 		player1,
 		player2,
+		bestOf,
 	}
 	renderTemplate(w, r, "faceoff", data)
 }
@@ -5,13 +5,7 @@ import (
 )
 
 func usersExist() bool {
-	c, err := getUserTable().Count().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return false
-	}
-	var count int
-	err = c.One(&count)
+	count, err := dataStore.CountUsers()
 	if err != nil {
 		return false
 	}
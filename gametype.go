@@ -3,83 +3,188 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
-	r "gopkg.in/dancannon/gorethink.v2"
+	"github.com/gorilla/mux"
 )
 
+const (
+	ratingSystemElo       = "elo"
+	ratingSystemGlicko2   = "glicko2"
+	ratingSystemTrueSkill = "trueskill"
+
+	resultModeGames = "games"
+	resultModeSets  = "sets"
+)
+
+// GameType is a game that matches are played in, along with the settings
+// it's ranked with:
+//
+// RatingSystem picks the engine; empty means Elo. KFactor (Elo only) and
+// StartRating fall back to the engine's defaults when they're zero.
+// ResultMode is "games" to score a match by the share of games won, or
+// "sets" to only count who won. Tournament and casual matches are weighted
+// by TournamentWeight and CasualWeight; a weight of 0 leaves them unrated.
+// Players whose Glicko-2 or TrueSkill deviation is above MaxDeviation are left
+// out of the rankings.
+//
+// To be ranked, a player also needs MinMatches matches against MinOpponents
+// different opponents, and MinTournaments tournaments in the last
+// ActiveMonths months. Players who haven't played in ActiveMonths months lose
+// DecayPerMonth points for every month after that.
+//
+// Tournaments are sorted into Tiers by the strength of their attendees. With
+// WeightByTier, a tournament match's weight is also multiplied by its tier's.
 type GameType struct {
-	ID      string `gorethink:"id,omitempty"`
-	Name    string `gorethink:"name"`
-	URLPath string `gorethink:"urlpath"`
+	ID               string           `gorethink:"id,omitempty"`
+	Name             string           `gorethink:"name"`
+	URLPath          string           `gorethink:"urlpath"`
+	RatingSystem     string           `gorethink:"rating_system"`
+	KFactor          float64          `gorethink:"k_factor"`
+	StartRating      int              `gorethink:"start_rating"`
+	ResultMode       string           `gorethink:"result_mode"`
+	TournamentWeight float64          `gorethink:"tournament_weight"`
+	CasualWeight     float64          `gorethink:"casual_weight"`
+	MaxDeviation     float64          `gorethink:"max_deviation"`
+	MinMatches       int              `gorethink:"min_matches"`
+	MinOpponents     int              `gorethink:"min_opponents"`
+	MinTournaments   int              `gorethink:"min_tournaments"`
+	ActiveMonths     int              `gorethink:"active_months"`
+	DecayPerMonth    int              `gorethink:"decay_per_month"`
+	Tiers            []TournamentTier `gorethink:"tiers"`
+	WeightByTier     bool             `gorethink:"weight_by_tier"`
 }
 
-func getGameTypeTable() r.Term {
-	return r.Table("gametypes")
+// sameRankingSettings reports whether two versions of a game type would
+// produce the same ratings.
+func sameRankingSettings(a *GameType, b *GameType) bool {
+	return a.RatingSystem == b.RatingSystem &&
+		a.KFactor == b.KFactor &&
+		a.StartRating == b.StartRating &&
+		a.ResultMode == b.ResultMode &&
+		a.TournamentWeight == b.TournamentWeight &&
+		a.CasualWeight == b.CasualWeight &&
+		a.WeightByTier == b.WeightByTier &&
+		(!a.WeightByTier || a.TiersText() == b.TiersText())
 }
 
-func fetchGameTypes() []GameType {
-	c, err := getGameTypeTable().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
+// readGameTypeForm fills in a game type from the add and edit forms. Empty
+// weights count as 1.
+func readGameTypeForm(r *http.Request, gt *GameType) error {
+	gt.Name = r.FormValue("name")
+	gt.URLPath = r.FormValue("urlpath")
+	gt.RatingSystem = r.FormValue("ratingsystem")
+	gt.KFactor, _ = strconv.ParseFloat(r.FormValue("kfactor"), 64)
+	gt.StartRating, _ = strconv.Atoi(r.FormValue("startrating"))
+	gt.ResultMode = r.FormValue("resultmode")
+	gt.MaxDeviation, _ = strconv.ParseFloat(r.FormValue("maxdeviation"), 64)
+	gt.MinMatches, _ = strconv.Atoi(r.FormValue("minmatches"))
+	gt.MinOpponents, _ = strconv.Atoi(r.FormValue("minopponents"))
+	gt.MinTournaments, _ = strconv.Atoi(r.FormValue("mintournaments"))
+	gt.ActiveMonths, _ = strconv.Atoi(r.FormValue("activemonths"))
+	gt.DecayPerMonth, _ = strconv.Atoi(r.FormValue("decaypermonth"))
+
+	gt.TournamentWeight = 1
+	if w, err := strconv.ParseFloat(r.FormValue("tournamentweight"), 64); err == nil && w >= 0 {
+		gt.TournamentWeight = w
+	}
+	gt.CasualWeight = 1
+	if w, err := strconv.ParseFloat(r.FormValue("casualweight"), 64); err == nil && w >= 0 {
+		gt.CasualWeight = w
 	}
 
-	gameTypes := []GameType{}
-	err = c.All(&gameTypes)
+	tiers, err := readTiers(r.FormValue("tiers"))
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
-	return gameTypes
+	gt.Tiers = tiers
+	gt.WeightByTier = r.FormValue("weightbytier") == "on"
+	return nil
 }
 
-func fetchGameType(ID string) (*GameType, error) {
-	c, err := getGameTypeTable().Get(ID).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
+type ByGameTypeName []GameType
+
+func (a ByGameTypeName) Len() int           { return len(a) }
+func (a ByGameTypeName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByGameTypeName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+func addGameTypeHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "addGameType", nil)
+}
+
+func saveGameTypeHandler(w http.ResponseWriter, r *http.Request) {
+	var gt GameType
+	if err := readGameTypeForm(r, &gt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	var gt *GameType
-	err = c.One(&gt)
+	dataStore.AddGameType(gt)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func editGameTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
 	if err != nil {
-		return nil, err
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		GameType *GameType
+	}{
+		gt,
 	}
-	return gt, nil
+	renderTemplate(w, r, "editGameType", data)
 }
 
-func fetchGameTypeByURLPath(p string) (*GameType, error) {
-	c, err := getGameTypeTable().Filter(map[string]interface{}{
-		"urlpath": p,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
+func saveEditGameTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		http.NotFound(w, r)
+		return
 	}
 
-	var gt *GameType
-	err = c.One(&gt)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
+	before := *gt
+	if err := readGameTypeForm(r, gt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	return gt, nil
-}
 
-func addGameType(gameType GameType) string {
-	wr, err := getGameTypeTable().Insert(gameType).RunWrite(dataStore.GetSession())
+	err = dataStore.UpdateGameType(gt)
 	if err != nil {
 		fmt.Println(err)
 	}
-	return wr.GeneratedKeys[0]
+	if before.TiersText() != gt.TiersText() {
+		retierTournaments(gt)
+	}
+	if !sameRankingSettings(&before, gt) {
+		rebuildRatings(gt.ID)
+	}
+	http.Redirect(w, r, "/rankings/"+gt.URLPath, http.StatusFound)
 }
 
-func addGameTypeHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, r, "addGameType", nil)
+func saveGameTypeStrengthsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	updateTournamentStrengths(gt)
+	http.Redirect(w, r, "/tournaments/"+gt.URLPath, http.StatusFound)
 }
 
-func saveGameTypeHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
-	urlpath := r.FormValue("urlpath")
-	addGameType(GameType{Name: name, URLPath: urlpath})
-	http.Redirect(w, r, "/", http.StatusFound)
+// saveGameTypeRatingsHandler rebuilds a game type's ratings and their history
+// from its matches.
+func saveGameTypeRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rebuildRatings(gt.ID)
+	http.Redirect(w, r, "/rankings/"+gt.URLPath, http.StatusFound)
 }
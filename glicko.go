@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Glicko-2 as described in Mark Glickman's "Example of the Glicko-2 system".
+// Ratings are shown on the Glicko scale and converted to the Glicko-2 scale
+// (mu, phi), centred on glickoStartRating, for the calculations.
+const (
+	glickoStartRating     = 1500
+	glickoStartDeviation  = 350
+	glickoStartVolatility = 0.06
+	glickoTau             = 0.5
+	glickoScale           = 173.7178
+	glickoEpsilon         = 0.000001
+
+	// glickoPeriod is the length of a rating period. All matches played in
+	// a period are rated together at the end of it.
+	glickoPeriod = 7 * 24 * time.Hour
+)
+
+type Glicko2 struct {
+	tau   float64
+	start int
+	rules matchRules
+}
+
+func newGlicko2(start int, rules matchRules) *Glicko2 {
+	if start <= 0 {
+		start = glickoStartRating
+	}
+	return &Glicko2{tau: glickoTau, start: start, rules: rules}
+}
+
+func (g *Glicko2) NewRating(gameType string, player string) *Rating {
+	return &Rating{
+		ID:         ratingID(gameType, player),
+		Player:     player,
+		GameType:   gameType,
+		Rating:     g.start,
+		Deviation:  glickoStartDeviation,
+		Volatility: glickoStartVolatility,
+	}
+}
+
+// Incremental is false because a match changes the ratings of everyone who
+// played in the same rating period.
+func (g *Glicko2) Incremental() bool {
+	return false
+}
+
+// Predict is the expected score with both players' deviations combined.
+func (g *Glicko2) Predict(r1 *Rating, r2 *Rating) (float64, bool) {
+	p1, p2 := newGlickoPlayer(r1), newGlickoPlayer(r2)
+	phi := math.Sqrt(p1.phi*p1.phi + p2.phi*p2.phi)
+	return glickoE(p1.mu, p2.mu, phi), g.rules.resultMode != resultModeSets
+}
+
+type glickoResult struct {
+	opponentMu  float64
+	opponentPhi float64
+	score       float64
+	weight      float64
+}
+
+// glickoPlayer is a player's state while replaying matches.
+type glickoPlayer struct {
+	rating    *Rating
+	mu        float64
+	phi       float64
+	sigma     float64
+	period    int64
+	results   []glickoResult
+	before    int
+	lastMatch string
+}
+
+func newGlickoPlayer(r *Rating) *glickoPlayer {
+	return &glickoPlayer{
+		rating: r,
+		mu:     (float64(r.Rating) - glickoStartRating) / glickoScale,
+		phi:    r.Deviation / glickoScale,
+		sigma:  r.Volatility,
+		period: -1,
+	}
+}
+
+func glickoPeriodOf(t time.Time) int64 {
+	return t.Unix() / int64(glickoPeriod/time.Second)
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu, opponentMu, opponentPhi float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(opponentPhi)*(mu-opponentMu)))
+}
+
+// idle widens a player's deviation for rating periods they sat out.
+func (p *glickoPlayer) idle(periods int64) {
+	if periods <= 0 {
+		return
+	}
+	p.phi = math.Sqrt(p.phi*p.phi + float64(periods)*p.sigma*p.sigma)
+}
+
+// update rates a player on every result from the period that just ended.
+// Each result counts in proportion to its weight.
+func (g *Glicko2) update(p *glickoPlayer) {
+	var vInv, delta float64
+	for _, r := range p.results {
+		gPhi := glickoG(r.opponentPhi)
+		e := glickoE(p.mu, r.opponentMu, r.opponentPhi)
+		vInv += r.weight * gPhi * gPhi * e * (1 - e)
+		delta += r.weight * gPhi * (r.score - e)
+	}
+	v := 1 / vInv
+
+	sigma := g.volatility(p.phi, p.sigma, v, v*delta)
+	phiStar := math.Sqrt(p.phi*p.phi + sigma*sigma)
+	p.phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	p.mu += p.phi * p.phi * delta
+	p.sigma = sigma
+	p.results = nil
+}
+
+// volatility finds the new volatility with the Illinois algorithm.
+func (g *Glicko2) volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(g.tau*g.tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*g.tau) < 0 {
+			k++
+		}
+		B = a - k*g.tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+// store copies a player's state back to their Rating.
+func (p *glickoPlayer) store() {
+	p.rating.Rating = round(p.mu*glickoScale + glickoStartRating)
+	p.rating.Deviation = p.phi * glickoScale
+	p.rating.Volatility = p.sigma
+}
+
+// Rate replays matches one rating period at a time. Each player gets one
+// rating change per period they played in.
+func (g *Glicko2) Rate(gameType string, ratings map[string]*Rating, matches []Match) []*RatingChange {
+	players := make(map[string]*glickoPlayer)
+	changes := []*RatingChange{}
+
+	var period int64 = -1
+	active := []*glickoPlayer{}
+
+	endPeriod := func() {
+		for _, p := range active {
+			g.update(p)
+			p.store()
+			changes = append(changes, &RatingChange{
+				Player:   p.rating.Player,
+				GameType: gameType,
+				Match:    p.lastMatch,
+				Before:   p.before,
+				After:    p.rating.Rating,
+				Matches:  p.rating.Matches,
+				Date:     p.rating.LastMatch,
+			})
+		}
+		active = active[:0]
+	}
+
+	join := func(id string) *glickoPlayer {
+		p, ok := players[id]
+		if !ok {
+			p = newGlickoPlayer(ratingsFor(g, gameType, ratings, id)[0])
+			players[id] = p
+		}
+		if p.period != period {
+			if p.period >= 0 {
+				p.idle(period - p.period - 1)
+			}
+			p.period = period
+			p.before = p.rating.Rating
+			active = append(active, p)
+		}
+		return p
+	}
+
+	for _, m := range matches {
+		weight := g.rules.weight(m)
+		if weight == 0 || m.Player1score+m.Player2score == 0 {
+			continue
+		}
+		if mp := glickoPeriodOf(m.Date); mp != period {
+			endPeriod()
+			period = mp
+		}
+		p1 := join(m.Player1)
+		p2 := join(m.Player2)
+
+		score := g.rules.score(m)
+		p1.results = append(p1.results, glickoResult{p2.mu, p2.phi, score, weight})
+		p2.results = append(p2.results, glickoResult{p1.mu, p1.phi, 1 - score, weight})
+
+		for _, p := range []*glickoPlayer{p1, p2} {
+			p.rating.Matches++
+			p.rating.LastMatch = m.Date
+			p.lastMatch = m.ID
+		}
+	}
+	endPeriod()
+
+	// Players who sat out the most recent periods are less certain.
+	if period >= 0 {
+		for _, p := range players {
+			p.idle(period - p.period)
+			p.store()
+		}
+	}
+	return changes
+}
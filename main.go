@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
@@ -9,7 +10,6 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/oxtoacart/bpool"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 type Page struct {
@@ -25,7 +25,7 @@ const UserKey key = 0
 var siteConfiguration *Configuration
 var templates map[string]*template.Template
 var bufpool *bpool.BufferPool
-var dataStore *DataStore
+var dataStore DataStore
 
 func parseTemplates() {
 	if templates == nil {
@@ -60,7 +60,7 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, templateName string,
 
 	var user *User
 	if email, ok := isLoggedIn(r); ok {
-		user = fetchUserByEmail(email)
+		user = dataStore.FetchUserByEmail(email)
 	}
 
 	page := Page{
@@ -84,17 +84,6 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, r, "home", nil)
 }
 
-func initializeTables() {
-	r.DBCreate("velvetdb").Run(dataStore.GetSession())
-	r.TableCreate("users").Run(dataStore.GetSession())
-	r.TableCreate("players").Run(dataStore.GetSession())
-	r.TableCreate("gametypes").Run(dataStore.GetSession())
-	r.TableCreate("matches").Run(dataStore.GetSession())
-	r.TableCreate("tournaments").Run(dataStore.GetSession())
-	r.TableCreate("tournamentresults").Run(dataStore.GetSession())
-	r.TableCreate("sessions").Run(dataStore.GetSession())
-}
-
 func isAdminMiddleware(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, ok := isLoggedIn(r)
@@ -113,7 +102,7 @@ func hasPermissionMiddleware(next func(http.ResponseWriter, *http.Request), perm
 			http.NotFound(w, r)
 			return
 		}
-		u := fetchUserByEmail(email)
+		u := dataStore.FetchUserByEmail(email)
 		if u == nil {
 			http.NotFound(w, r)
 			return
@@ -127,23 +116,52 @@ func hasPermissionMiddleware(next func(http.ResponseWriter, *http.Request), perm
 }
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply pending schema migrations and exit")
+	migrationStatus := flag.Bool("migrations", false, "list schema migrations and whether they've been applied, then exit")
+	flag.Parse()
+
 	siteConfiguration = getConfiguration()
-	session, err := r.Connect(r.ConnectOpts{
-		Address:  siteConfiguration.RethinkConnection,
-		Database: "velvetdb",
-	})
+	ds, err := newDataStore(siteConfiguration)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
-	dataStore = &DataStore{session: session}
+	dataStore = ds
+
+	if m, ok := dataStore.(Migrator); ok {
+		if *migrationStatus {
+			if err := printMigrationStatus(m); err != nil {
+				log.Fatalln(err.Error())
+			}
+			return
+		}
+		pending, err := pendingMigrations(m)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if err := runMigrations(m); err != nil {
+			log.Fatalln(err.Error())
+		}
+		backfillRatings(pending)
+	}
+	if *migrateOnly || *migrationStatus {
+		return
+	}
 
-	initializeTables()
 	initializeSessionStore()
 
 	bufpool = bpool.NewBufferPool(64)
 
 	parseTemplates()
 
+	r := newRouter()
+
+	fmt.Println("We're up and running!")
+
+	http.ListenAndServe(":3000", r)
+}
+
+// newRouter registers every route on the site.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 
 	// Serve files from the assets directory
@@ -161,6 +179,9 @@ func main() {
 	r.HandleFunc("/save/match/{match:[-a-zA-Z0-9]+}", isAdminMiddleware(saveEditMatchHandler))
 	r.HandleFunc("/addtournament", isAdminMiddleware(addTournamentHandler))
 	r.HandleFunc("/addpool/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(addPoolHandler))
+	r.HandleFunc("/uploadtournament", isAdminMiddleware(uploadTournamentHandler))
+	r.HandleFunc("/addbracket", isAdminMiddleware(addNativeBracketHandler))
+	r.HandleFunc("/bracket/{tournament:[-a-zA-Z0-9]+}", viewNativeBracketHandler)
 	r.HandleFunc("/save/addpool", isAdminMiddleware(savePoolHandler))
 	r.HandleFunc("/tournaments", viewTournamentsHandler)
 	r.HandleFunc("/tournaments/{gametype}", viewTournamentsHandler)
@@ -168,13 +189,23 @@ func main() {
 	r.HandleFunc("/save/addplayer", isAdminMiddleware(savePlayerHandler))
 	r.HandleFunc("/save/editplayer/{playerNick:[-a-zA-Z0-9]+}", isAdminMiddleware(saveEditPlayerHandler))
 	r.HandleFunc("/save/addgametype", isAdminMiddleware(saveGameTypeHandler))
+	r.HandleFunc("/edit/gametype/{gametype}", isAdminMiddleware(editGameTypeHandler))
+	r.HandleFunc("/save/gametype/{gametype}", isAdminMiddleware(saveEditGameTypeHandler))
+	r.HandleFunc("/save/gametype/{gametype}/strengths", isAdminMiddleware(saveGameTypeStrengthsHandler))
+	r.HandleFunc("/save/gametype/{gametype}/ratings", isAdminMiddleware(saveGameTypeRatingsHandler))
 	r.HandleFunc("/save/addtournament", isAdminMiddleware(saveTournamentHandler))
+	r.HandleFunc("/save/uploadtournament", isAdminMiddleware(saveUploadTournamentHandler))
+	r.HandleFunc("/save/addbracket", isAdminMiddleware(saveNativeBracketHandler))
+	r.HandleFunc("/save/bracket/{tournament:[-a-zA-Z0-9]+}/match/{match:[0-9]+}", isAdminMiddleware(saveNativeBracketMatchHandler))
+	r.HandleFunc("/save/bracket/finalize/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(saveFinalizeNativeBracketHandler))
 	r.HandleFunc("/edit/tournament/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(editTournamentHandler))
 	r.HandleFunc("/save/tournament/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(saveEditTournamentHandler))
 	r.HandleFunc("/save/addtournamentmatch/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(saveTournamentMatchesHandler))
 	r.HandleFunc("/tournament/addmatches/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(addTournamentMatchesHandler))
 	r.HandleFunc("/tournament/{tournament:[-a-zA-Z0-9]+}", viewTournamentHandler)
 	r.HandleFunc("/tournament/delete/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(deleteTournamentHandler))
+	r.HandleFunc("/tournament/resync/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(resyncTournamentHandler))
+	r.HandleFunc("/save/tournament/resync/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(saveResyncTournamentHandler))
 	r.HandleFunc("/save/tournament/delete/{tournament:[-a-zA-Z0-9]+}", isAdminMiddleware(saveDeleteTournamentHandler))
 
 	// Tournament results
@@ -195,6 +226,25 @@ func main() {
 	// Rankings
 	r.HandleFunc("/rankings", rankingsHandler)
 	r.HandleFunc("/rankings/{gametype}", rankingsHandler)
+	r.HandleFunc("/rankings/{gametype}/season/{season:[-a-zA-Z0-9]+}", rankingsHandler)
+	r.HandleFunc("/rankings/{gametype}/{region}", rankingsHandler)
+	r.HandleFunc("/upsets/{gametype}", upsetsHandler)
+	r.HandleFunc("/seeding", seedingHandler)
+	r.HandleFunc("/regions", regionsHandler)
+	r.HandleFunc("/circuits", circuitsHandler)
+	r.HandleFunc("/circuit/{circuit}", circuitHandler)
+	r.HandleFunc("/addcircuit", isAdminMiddleware(addCircuitHandler))
+	r.HandleFunc("/save/addcircuit", isAdminMiddleware(saveCircuitHandler))
+	r.HandleFunc("/edit/circuit/{circuit}", isAdminMiddleware(editCircuitHandler))
+	r.HandleFunc("/save/circuit/{circuit}", isAdminMiddleware(saveEditCircuitHandler))
+	r.HandleFunc("/addregion", isAdminMiddleware(addRegionHandler))
+	r.HandleFunc("/save/addregion", isAdminMiddleware(saveRegionHandler))
+	r.HandleFunc("/edit/region/{region}", isAdminMiddleware(editRegionHandler))
+	r.HandleFunc("/save/region/{region}", isAdminMiddleware(saveEditRegionHandler))
+	r.HandleFunc("/addseason/{gametype}", isAdminMiddleware(addSeasonHandler))
+	r.HandleFunc("/save/addseason/{gametype}", isAdminMiddleware(saveSeasonHandler))
+	r.HandleFunc("/edit/season/{season:[-a-zA-Z0-9]+}", isAdminMiddleware(editSeasonHandler))
+	r.HandleFunc("/save/season/{season:[-a-zA-Z0-9]+}", isAdminMiddleware(saveEditSeasonHandler))
 
 	// auth
 	r.HandleFunc("/users", hasPermissionMiddleware(userListHandler, getPermissionLevels().CanModifyUsers))
@@ -210,14 +260,23 @@ func main() {
 	api := r.PathPrefix("/api/v1").Subrouter()
 	api.Methods("OPTIONS").HandlerFunc(handleAPIPreflight)
 	api.HandleFunc("/gametypes", handleAPIGameTypes)
+	api.HandleFunc("/gametypes/{gametype}/seasons", handleAPISeasons)
+	api.HandleFunc("/gametypes/{gametype}/upsets", handleAPIUpsets)
+	api.HandleFunc("/seasons/{id:[-a-zA-Z0-9]+}/rankings", handleAPISeasonRankings)
+	api.HandleFunc("/regions", handleAPIRegions)
+	api.HandleFunc("/circuits", handleAPICircuits)
+	api.HandleFunc("/circuits/{circuit}/standings", handleAPICircuitStandings)
+	api.HandleFunc("/regions/{region}/rankings", handleAPIRegionRankings)
 	api.HandleFunc("/players", handleAPIPlayers)
 	api.HandleFunc("/players/search", handleAPIPlayersSearch)
 	api.HandleFunc("/players/{id:[-a-zA-Z0-9]+}", handleAPIPlayer)
 	api.HandleFunc("/players/{id:[-a-zA-Z0-9]+}/tournamentresults", handleAPIPlayerTournamentResults)
+	api.HandleFunc("/players/{id:[-a-zA-Z0-9]+}/seedperformance", handleAPIPlayerSeedPerformance)
 	api.HandleFunc("/players/{id:[-a-zA-Z0-9]+}/matches", handleAPIPlayerMatches)
+	api.HandleFunc("/players/{id:[-a-zA-Z0-9]+}/ratings", handleAPIPlayerRatings)
 	api.HandleFunc("/players/{p1:[-a-zA-Z0-9]+}/{p2:[-a-zA-Z0-9]+}/matches", handleAPIFaceoff)
+	api.HandleFunc("/predict", handleAPIPredict)
+	api.HandleFunc("/seeding", handleAPISeeding)
 
-	fmt.Println("We're up and running!")
-
-	http.ListenAndServe(":3000", r)
+	return r
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/oxtoacart/bpool"
+)
+
+// testSite runs the whole site against a fresh in-memory store, with an
+// admin logged in on the returned client.
+func testSite(t *testing.T) (*httptest.Server, *http.Client) {
+	dataStore = newMemoryDataStore()
+	siteConfiguration = &Configuration{
+		Storage:   storageMemory,
+		CookieKey: "abcdefabcdefabcdefabcdefabcdefab",
+	}
+	initializeSessionStore()
+	bufpool = bpool.NewBufferPool(64)
+	parseTemplates()
+
+	srv := httptest.NewServer(newRouter())
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	admin := url.Values{"email": {"admin@example.com"}, "password": {"password"}}
+	postForm(t, client, srv.URL+"/firstrun/save", admin)
+	postForm(t, client, srv.URL+"/save/login", admin)
+	return srv, client
+}
+
+func postForm(t *testing.T, client *http.Client, u string, form url.Values) {
+	resp, err := client.PostForm(u, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: %s", u, resp.Status)
+	}
+}
+
+func get(t *testing.T, client *http.Client, u string) string {
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", u, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRankingsAfterMatch(t *testing.T) {
+	srv, client := testSite(t)
+	defer srv.Close()
+
+	postForm(t, client, srv.URL+"/save/addgametype", url.Values{"name": {"Melee"}, "urlpath": {"melee"}})
+	for _, nickname := range []string{"Alice", "Bob"} {
+		postForm(t, client, srv.URL+"/save/addplayer", url.Values{"nickname": {nickname}})
+	}
+	gt, err := dataStore.FetchGameTypeByURLPath("melee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := dataStore.FetchPlayerByNickname("Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := dataStore.FetchPlayerByNickname("Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postForm(t, client, srv.URL+"/save/addmatch", url.Values{
+		"gametype":     {gt.ID},
+		"player1":      {bob.ID},
+		"player2":      {alice.ID},
+		"player1score": {"1"},
+		"player2score": {"3"},
+	})
+
+	// Anyone can see the rankings, and the winner is ranked first
+	body := get(t, http.DefaultClient, srv.URL+"/rankings/melee")
+	a, b := strings.Index(body, "Alice"), strings.Index(body, "Bob")
+	if a == -1 || b == -1 {
+		t.Fatalf("rankings should list both players:\n%s", body)
+	}
+	if a > b {
+		t.Errorf("Alice beat Bob, so should be ranked above them")
+	}
+}
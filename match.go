@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 type Match struct {
@@ -24,100 +23,21 @@ type Match struct {
 	Player2score               int       `gorethink:"player2_score"`
 	Round                      int       `gorethink:"round"`
 	Hidden                     bool      `gorethink:"hidden"`
+	UpsetRating                int       `gorethink:"upset_rating"`
+	UpsetSeed                  int       `gorethink:"upset_seed"`
 }
 
-func getMatchTable() r.Term {
-	return r.Table("matches")
-}
-
-func fetchMatch(id string) (*Match, error) {
-	c, err := getMatchTable().Get(id).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
-	var m *Match
-	err = c.One(&m)
-	if err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func fetchMatchesForPlayer(id string, includeHidden bool) []Match {
-	filter := r.Or(r.Row.Field("player1").Eq(id), r.Row.Field("player2").Eq(id))
-	if !includeHidden {
-		filter = r.And(r.Row.Field("hidden").Eq(false), filter)
-	}
-
-	c, err := getMatchTable().Filter(filter).
-		OrderBy(r.Desc("date")).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-	matches := []Match{}
-	err = c.All(&matches)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return matches
-}
-
-func fetchMatchesForPlayers(p1 string, p2 string, includeHidden bool) *[]Match {
-	filter := r.Or(
-		r.Row.Field("player1").Eq(p1).And(r.Row.Field("player2").Eq(p2)),
-		r.Row.Field("player1").Eq(p2).And(r.Row.Field("player2").Eq(p1)),
-	)
-	if !includeHidden {
-		r.And(filter, r.Row.Field("hidden").Eq(false))
-	}
-	c, err := getMatchTable().Filter(filter).
-		OrderBy(r.Desc("date")).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-	matches := []Match{}
-	err = c.All(&matches)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return &matches
-}
-
-func fetchMatchesForTournament(id string, includeHidden bool) []Match {
-	filter := map[string]interface{}{"tournament": id}
-	if !includeHidden {
-		filter["hidden"] = false
-	}
-
-	c, err := getMatchTable().Filter(filter).OrderBy("date").Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-	matches := []Match{}
-	err = c.All(&matches)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return matches
-}
+type ByDate []Match
 
-func addMatch(m Match) string {
-	wr, err := getMatchTable().Insert(m).RunWrite(dataStore.GetSession())
-	if err != nil {
-		fmt.Println(err)
-	}
-	return wr.GeneratedKeys[0]
-}
+func (a ByDate) Len() int           { return len(a) }
+func (a ByDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByDate) Less(i, j int) bool { return a[i].Date.Before(a[j].Date) }
 
 func addMatchHandler(w http.ResponseWriter, r *http.Request) {
 	// get players
-	players := fetchPlayers()
+	players := dataStore.FetchPlayers()
 
-	gameTypes := fetchGameTypes()
+	gameTypes := dataStore.FetchGameTypes()
 
 	data := struct {
 		Players   []Player
@@ -134,9 +54,9 @@ func editMatchHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	matchID := vars["match"]
 
-	players := fetchPlayers()
+	players := dataStore.FetchPlayers()
 
-	m, err := fetchMatch(matchID)
+	m, err := dataStore.FetchMatch(matchID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -158,33 +78,25 @@ func saveEditMatchHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	matchID := vars["match"]
 
-	_, err := fetchMatch(matchID)
+	m, err := dataStore.FetchMatch(matchID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	p1 := r.FormValue("p1")
-	p2 := r.FormValue("p2")
-	p1score, _ := strconv.Atoi(r.FormValue("p1score"))
-	p2score, _ := strconv.Atoi(r.FormValue("p2score"))
-	hidden := r.FormValue("hidden") == "hidden"
-
-	wr, werr := getMatchTable().Get(matchID).Update(map[string]interface{}{
-		"hidden":        hidden,
-		"player1":       p1,
-		"player2":       p2,
-		"player1_score": p1score,
-		"player2_score": p2score,
-	}).RunWrite(dataStore.GetSession())
-	if werr != nil {
+	m.Player1 = r.FormValue("p1")
+	m.Player2 = r.FormValue("p2")
+	m.Player1score, _ = strconv.Atoi(r.FormValue("p1score"))
+	m.Player2score, _ = strconv.Atoi(r.FormValue("p2score"))
+	m.Hidden = r.FormValue("hidden") == "hidden"
+
+	err = dataStore.UpdateMatch(m)
+	if err != nil {
 		fmt.Println(err)
 	}
-	if wr.Errors > 0 {
-		fmt.Println(wr.FirstError)
-	}
+	rebuildRatings(m.GameType)
 
-	newMatch, err := fetchMatch(matchID)
+	newMatch, err := dataStore.FetchMatch(matchID)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -195,7 +107,7 @@ func saveEditMatchHandler(w http.ResponseWriter, r *http.Request) {
 		Saved   bool
 	}{
 		newMatch,
-		fetchPlayers(),
+		dataStore.FetchPlayers(),
 		true,
 	}
 	renderTemplate(w, r, "editMatch", data)
@@ -211,14 +123,16 @@ func saveMatchHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusBadRequest)
 	}
 
-	addMatch(Match{
+	m := Match{
 		Player1:      player1,
 		Player2:      player2,
 		GameType:     gameType,
 		Player1score: player1score,
 		Player2score: player2score,
 		Date:         time.Now(),
-	})
+	}
+	m.ID = dataStore.AddMatch(m)
+	updateRatings([]Match{m})
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Migration is one versioned change to a backend's schema. Migrations are
+// applied in version order and recorded so each one only runs once.
+type Migration struct {
+	Version     int
+	Description string
+}
+
+// Migrator is implemented by backends that keep a persistent schema.
+type Migrator interface {
+	Migrations() []Migration
+	AppliedMigrations() (map[int]bool, error)
+	ApplyMigration(version int) error
+}
+
+type ByVersion []Migration
+
+func (a ByVersion) Len() int           { return len(a) }
+func (a ByVersion) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByVersion) Less(i, j int) bool { return a[i].Version < a[j].Version }
+
+func pendingMigrations(m Migrator) ([]Migration, error) {
+	applied, err := m.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, migration := range m.Migrations() {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	sort.Sort(ByVersion(pending))
+	return pending, nil
+}
+
+// runMigrations applies every pending migration, stopping at the first one
+// that fails.
+func runMigrations(m Migrator) error {
+	pending, err := pendingMigrations(m)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		fmt.Printf("Applying migration %d: %s\n", migration.Version, migration.Description)
+		if err := m.ApplyMigration(migration.Version); err != nil {
+			return fmt.Errorf("migration %d failed: %v", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+func printMigrationStatus(m Migrator) error {
+	applied, err := m.AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	migrations := m.Migrations()
+	sort.Sort(ByVersion(migrations))
+	for _, migration := range migrations {
+		status := "pending"
+		if applied[migration.Version] {
+			status = "applied"
+		}
+		fmt.Printf("%4d  %-8s %s\n", migration.Version, status, migration.Description)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	r "gopkg.in/dancannon/gorethink.v2"
+)
+
+type rethinkMigration struct {
+	Migration
+	up func(s *r.Session) error
+}
+
+var rethinkMigrations = []rethinkMigration{
+	{Migration{1, "create tables"}, func(s *r.Session) error {
+		return createRethinkTables(s, "users", "players", "gametypes", "matches",
+			"tournaments", "tournamentresults", "sessions")
+	}},
+	{Migration{2, "add secondary indexes for player, game type and tournament lookups"}, func(s *r.Session) error {
+		return createRethinkIndexes(s, map[string][]string{
+			"matches":           {"player1", "player2", "gametype", "tournament"},
+			"tournamentresults": {"player"},
+			"players":           {"urlpath"},
+		})
+	}},
+	{Migration{3, "add compound indexes for head-to-heads, rankings and logins"}, func(s *r.Session) error {
+		if err := createRethinkIndexes(s, map[string][]string{"users": {"email"}}); err != nil {
+			return err
+		}
+		return createRethinkIndexFuncs(s, []rethinkIndex{
+			{"matches", "players", func(row r.Term) interface{} {
+				return []interface{}{row.Field("player1"), row.Field("player2")}
+			}, r.IndexCreateOpts{Multi: true}},
+			// The pair is stored lowest ID first so a head-to-head is one
+			// range no matter which side each player was on.
+			{"matches", "player_pair_date", func(row r.Term) interface{} {
+				return r.Branch(row.Field("player1").Lt(row.Field("player2")),
+					[]interface{}{row.Field("player1"), row.Field("player2"), row.Field("date")},
+					[]interface{}{row.Field("player2"), row.Field("player1"), row.Field("date")})
+			}, r.IndexCreateOpts{}},
+			{"matches", "gametype_date", func(row r.Term) interface{} {
+				return []interface{}{row.Field("gametype"), row.Field("date")}
+			}, r.IndexCreateOpts{}},
+		})
+	}},
+	{Migration{4, "add ratings table"}, func(s *r.Session) error {
+		if err := createRethinkTables(s, "ratings"); err != nil {
+			return err
+		}
+		return createRethinkIndexes(s, map[string][]string{"ratings": {"gametype"}})
+	}},
+	{Migration{5, "add rating history"}, func(s *r.Session) error {
+		if err := createRethinkTables(s, "ratingchanges"); err != nil {
+			return err
+		}
+		if err := createRethinkIndexes(s, map[string][]string{"ratingchanges": {"gametype"}}); err != nil {
+			return err
+		}
+		return createRethinkIndexFuncs(s, []rethinkIndex{
+			{"ratingchanges", "gametype_player_matches", func(row r.Term) interface{} {
+				return []interface{}{row.Field("gametype"), row.Field("player"), row.Field("matches")}
+			}, r.IndexCreateOpts{}},
+		})
+	}},
+	{Migration{6, "default match weights for existing game types"}, func(s *r.Session) error {
+		_, err := r.Table("gametypes").Update(func(gt r.Term) interface{} {
+			return map[string]interface{}{
+				"tournament_weight": gt.Field("tournament_weight").Default(1),
+				"casual_weight":     gt.Field("casual_weight").Default(1),
+			}
+		}).RunWrite(s)
+		return err
+	}},
+	{Migration{7, "add seasons table"}, func(s *r.Session) error {
+		if err := createRethinkTables(s, "seasons"); err != nil {
+			return err
+		}
+		return createRethinkIndexes(s, map[string][]string{"seasons": {"gametype"}})
+	}},
+	{Migration{8, "add regions table"}, func(s *r.Session) error {
+		return createRethinkTables(s, "regions")
+	}},
+	{Migration{9, "add circuits table"}, func(s *r.Session) error {
+		return createRethinkTables(s, "circuits")
+	}},
+	{Migration{10, "add participant handles table"}, func(s *r.Session) error {
+		if err := createRethinkTables(s, "participanthandles"); err != nil {
+			return err
+		}
+		return createRethinkIndexes(s, map[string][]string{"participanthandles": {"handle"}})
+	}},
+	{Migration{11, "add uploaded brackets table"}, func(s *r.Session) error {
+		return createRethinkTables(s, "uploadedbrackets")
+	}},
+	{Migration{12, "add native brackets table"}, func(s *r.Session) error {
+		return createRethinkTables(s, "nativebrackets")
+	}},
+	{Migration{13, "add circuit tier rules"}, func(s *r.Session) error {
+		_, err := r.Table("circuits").Update(func(c r.Term) interface{} {
+			return map[string]interface{}{"tiers": c.Field("tiers").Default([]string{})}
+		}).RunWrite(s)
+		return err
+	}},
+}
+
+// rethinkIndex is a secondary index built from a function of each document.
+type rethinkIndex struct {
+	table string
+	name  string
+	fn    func(row r.Term) interface{}
+	opts  r.IndexCreateOpts
+}
+
+func getMigrationTable() r.Term {
+	return r.Table("migrations")
+}
+
+// rethinkListContains runs a term that returns a list of names (DBList,
+// TableList, IndexList) and reports whether name is in it.
+func rethinkListContains(s *r.Session, list r.Term, name string) (bool, error) {
+	c, err := list.Run(s)
+	defer c.Close()
+	if err != nil {
+		return false, err
+	}
+	var names []string
+	err = c.All(&names)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func createRethinkTables(s *r.Session, tables ...string) error {
+	for _, table := range tables {
+		exists, err := rethinkListContains(s, r.TableList(), table)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := r.TableCreate(table).RunWrite(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRethinkIndexes creates simple secondary indexes on the given fields
+// and waits for them to be ready.
+func createRethinkIndexes(s *r.Session, indexes map[string][]string) error {
+	tables := []string{}
+	for table := range indexes {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, field := range indexes[table] {
+			exists, err := rethinkListContains(s, r.Table(table).IndexList(), field)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if _, err := r.Table(table).IndexCreate(field).RunWrite(s); err != nil {
+				return err
+			}
+		}
+		if _, err := r.Table(table).IndexWait().Run(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createRethinkIndexFuncs(s *r.Session, indexes []rethinkIndex) error {
+	for _, index := range indexes {
+		exists, err := rethinkListContains(s, r.Table(index.table).IndexList(), index.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = r.Table(index.table).IndexCreateFunc(index.name, index.fn, index.opts).RunWrite(s)
+		if err != nil {
+			return err
+		}
+		if _, err := r.Table(index.table).IndexWait(index.name).Run(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *RethinkDataStore) Migrations() []Migration {
+	migrations := []Migration{}
+	for _, m := range rethinkMigrations {
+		migrations = append(migrations, m.Migration)
+	}
+	return migrations
+}
+
+// AppliedMigrations also creates the database and the migrations table, since
+// it's the first thing run against a fresh server.
+func (ds *RethinkDataStore) AppliedMigrations() (map[int]bool, error) {
+	exists, err := rethinkListContains(ds.session, r.DBList(), "velvetdb")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := r.DBCreate("velvetdb").RunWrite(ds.session); err != nil {
+			return nil, err
+		}
+	}
+	if err := createRethinkTables(ds.session, "migrations"); err != nil {
+		return nil, err
+	}
+
+	c, err := getMigrationTable().Field("id").Run(ds.session)
+	defer c.Close()
+	if err != nil {
+		return nil, err
+	}
+	var versions []int
+	err = c.All(&versions)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
+
+func (ds *RethinkDataStore) ApplyMigration(version int) error {
+	for _, m := range rethinkMigrations {
+		if m.Version != version {
+			continue
+		}
+		if err := m.up(ds.session); err != nil {
+			return err
+		}
+		_, err := getMigrationTable().Insert(map[string]interface{}{
+			"id":          m.Version,
+			"description": m.Description,
+			"applied_at":  r.Now(),
+		}).RunWrite(ds.session)
+		return err
+	}
+	return fmt.Errorf("no migration with version %d", version)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqliteMigration struct {
+	Migration
+	statements []string
+}
+
+var sqliteMigrations = []sqliteMigration{
+	{Migration{1, "create tables"}, []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			player TEXT NOT NULL DEFAULT '',
+			password TEXT NOT NULL,
+			permission INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS players (
+			id TEXT PRIMARY KEY,
+			nickname TEXT NOT NULL,
+			tag TEXT NOT NULL DEFAULT '',
+			aliases TEXT NOT NULL DEFAULT 'null',
+			image TEXT NOT NULL DEFAULT '',
+			urlpath TEXT NOT NULL,
+			first_name TEXT NOT NULL DEFAULT '',
+			last_name TEXT NOT NULL DEFAULT '',
+			facts TEXT NOT NULL DEFAULT 'null',
+			characters TEXT NOT NULL DEFAULT 'null',
+			twitter TEXT NOT NULL DEFAULT '',
+			twitch TEXT NOT NULL DEFAULT '',
+			city TEXT NOT NULL DEFAULT '',
+			state TEXT NOT NULL DEFAULT '',
+			lat REAL NOT NULL DEFAULT 0,
+			lon REAL NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS gametypes (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			urlpath TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS matches (
+			id TEXT PRIMARY KEY,
+			tournament TEXT NOT NULL DEFAULT '',
+			tournament_match_id TEXT NOT NULL DEFAULT '',
+			p1_prev_tm TEXT,
+			p2_prev_tm TEXT,
+			gametype TEXT NOT NULL,
+			date DATETIME NOT NULL,
+			player1 TEXT NOT NULL,
+			player2 TEXT NOT NULL,
+			player1_score INTEGER NOT NULL DEFAULT 0,
+			player2_score INTEGER NOT NULL DEFAULT 0,
+			round INTEGER NOT NULL DEFAULT 0,
+			hidden BOOLEAN NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS tournaments (
+			id TEXT PRIMARY KEY,
+			gametype TEXT NOT NULL,
+			name TEXT NOT NULL,
+			bracket_url TEXT NOT NULL DEFAULT '',
+			vod_url TEXT NOT NULL DEFAULT '',
+			pool_of TEXT NOT NULL DEFAULT '',
+			date_start DATETIME NOT NULL,
+			date_end DATETIME NOT NULL,
+			city TEXT NOT NULL DEFAULT '',
+			state TEXT NOT NULL DEFAULT '',
+			lat REAL NOT NULL DEFAULT 0,
+			lon REAL NOT NULL DEFAULT 0,
+			player_count INTEGER NOT NULL DEFAULT 0,
+			editing BOOLEAN NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS tournamentresults (
+			id TEXT PRIMARY KEY,
+			tournament TEXT NOT NULL,
+			player TEXT NOT NULL,
+			seed INTEGER NOT NULL DEFAULT 0,
+			placement INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL,
+			expires DATETIME NOT NULL
+		)`,
+	}},
+	{Migration{2, "add secondary indexes for player, game type and tournament lookups"}, []string{
+		`CREATE INDEX IF NOT EXISTS matches_player1 ON matches (player1)`,
+		`CREATE INDEX IF NOT EXISTS matches_player2 ON matches (player2)`,
+		`CREATE INDEX IF NOT EXISTS matches_gametype ON matches (gametype)`,
+		`CREATE INDEX IF NOT EXISTS matches_tournament ON matches (tournament)`,
+		`CREATE INDEX IF NOT EXISTS tournamentresults_player ON tournamentresults (player)`,
+		`CREATE INDEX IF NOT EXISTS players_urlpath ON players (urlpath)`,
+	}},
+	{Migration{3, "add compound indexes for head-to-heads, rankings and logins"}, []string{
+		`CREATE INDEX IF NOT EXISTS users_email ON users (email)`,
+		`CREATE INDEX IF NOT EXISTS matches_player1_date ON matches (player1, date)`,
+		`CREATE INDEX IF NOT EXISTS matches_player2_date ON matches (player2, date)`,
+		`CREATE INDEX IF NOT EXISTS matches_player_pair_date ON matches (player1, player2, date)`,
+		`CREATE INDEX IF NOT EXISTS matches_gametype_date ON matches (gametype, date)`,
+	}},
+	{Migration{4, "add ratings table"}, []string{
+		`CREATE TABLE IF NOT EXISTS ratings (
+			id TEXT PRIMARY KEY,
+			player TEXT NOT NULL,
+			gametype TEXT NOT NULL,
+			rating INTEGER NOT NULL,
+			matches INTEGER NOT NULL DEFAULT 0,
+			last_match DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ratings_gametype ON ratings (gametype)`,
+	}},
+	{Migration{5, "add rating history"}, []string{
+		`CREATE TABLE IF NOT EXISTS ratingchanges (
+			id TEXT PRIMARY KEY,
+			player TEXT NOT NULL,
+			gametype TEXT NOT NULL,
+			match_id TEXT NOT NULL,
+			opponent TEXT NOT NULL,
+			rating_before INTEGER NOT NULL,
+			rating_after INTEGER NOT NULL,
+			matches INTEGER NOT NULL,
+			date DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ratingchanges_gametype_player_matches
+			ON ratingchanges (gametype, player, matches)`,
+	}},
+	{Migration{6, "add Glicko-2 settings and rating deviation"}, []string{
+		`ALTER TABLE gametypes ADD COLUMN rating_system TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE gametypes ADD COLUMN max_deviation REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE ratings ADD COLUMN deviation REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE ratings ADD COLUMN volatility REAL NOT NULL DEFAULT 0`,
+	}},
+	{Migration{7, "add unrounded ratings for TrueSkill"}, []string{
+		`ALTER TABLE ratings ADD COLUMN mean REAL NOT NULL DEFAULT 0`,
+	}},
+	{Migration{8, "add per-game-type ranking settings"}, []string{
+		`ALTER TABLE gametypes ADD COLUMN k_factor REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN start_rating INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN result_mode TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE gametypes ADD COLUMN tournament_weight REAL NOT NULL DEFAULT 1`,
+		`ALTER TABLE gametypes ADD COLUMN casual_weight REAL NOT NULL DEFAULT 1`,
+	}},
+	{Migration{9, "add seasons table"}, []string{
+		`CREATE TABLE IF NOT EXISTS seasons (
+			id TEXT PRIMARY KEY,
+			gametype TEXT NOT NULL,
+			name TEXT NOT NULL,
+			date_start DATETIME NOT NULL,
+			date_end DATETIME NOT NULL,
+			carry_over REAL NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS seasons_gametype ON seasons (gametype)`,
+	}},
+	{Migration{10, "add ranking eligibility and decay settings"}, []string{
+		`ALTER TABLE gametypes ADD COLUMN min_matches INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN min_opponents INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN min_tournaments INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN active_months INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE gametypes ADD COLUMN decay_per_month INTEGER NOT NULL DEFAULT 0`,
+	}},
+	{Migration{11, "add regions table"}, []string{
+		`CREATE TABLE IF NOT EXISTS regions (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			urlpath TEXT NOT NULL UNIQUE,
+			scope TEXT NOT NULL,
+			lat REAL NOT NULL DEFAULT 0,
+			lon REAL NOT NULL DEFAULT 0,
+			radius REAL NOT NULL DEFAULT 0,
+			polygon TEXT NOT NULL DEFAULT '[]'
+		)`,
+	}},
+	{Migration{12, "add circuits table"}, []string{
+		`CREATE TABLE IF NOT EXISTS circuits (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			urlpath TEXT NOT NULL UNIQUE,
+			gametype TEXT NOT NULL,
+			tournaments TEXT NOT NULL DEFAULT '[]',
+			date_start DATETIME,
+			date_end DATETIME,
+			min_entrants INTEGER NOT NULL DEFAULT 0,
+			points TEXT NOT NULL DEFAULT '[]',
+			scale_entrants INTEGER NOT NULL DEFAULT 0,
+			best_of INTEGER NOT NULL DEFAULT 0
+		)`,
+	}},
+	{Migration{13, "add tournament strength tiers"}, []string{
+		`ALTER TABLE tournaments ADD COLUMN strength REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE tournaments ADD COLUMN tier TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE gametypes ADD COLUMN tiers TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE gametypes ADD COLUMN weight_by_tier INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE circuits ADD COLUMN weight_by_tier INTEGER NOT NULL DEFAULT 0`,
+	}},
+	{Migration{14, "add match upset factors"}, []string{
+		`ALTER TABLE matches ADD COLUMN upset_rating INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE matches ADD COLUMN upset_seed INTEGER NOT NULL DEFAULT 0`,
+	}},
+	{Migration{15, "add participant handles table"}, []string{
+		`CREATE TABLE IF NOT EXISTS participanthandles (
+			id TEXT PRIMARY KEY,
+			handle TEXT NOT NULL,
+			player TEXT NOT NULL,
+			last_seen DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS participanthandles_handle ON participanthandles (handle)`,
+	}},
+	{Migration{16, "add uploaded brackets table"}, []string{
+		`CREATE TABLE IF NOT EXISTS uploadedbrackets (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL,
+			uploaded_at DATETIME NOT NULL
+		)`,
+	}},
+	{Migration{17, "add native brackets table"}, []string{
+		`CREATE TABLE IF NOT EXISTS nativebrackets (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+	}},
+	{Migration{18, "add circuit tier rules"}, []string{
+		`ALTER TABLE circuits ADD COLUMN tiers TEXT NOT NULL DEFAULT '[]'`,
+	}},
+}
+
+func (ds *SQLiteDataStore) Migrations() []Migration {
+	migrations := []Migration{}
+	for _, m := range sqliteMigrations {
+		migrations = append(migrations, m.Migration)
+	}
+	return migrations
+}
+
+func (ds *SQLiteDataStore) AppliedMigrations() (map[int]bool, error) {
+	_, err := ds.db.Exec(`CREATE TABLE IF NOT EXISTS migrations (
+		id INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := ds.db.Query(`SELECT id FROM migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// ApplyMigration runs a migration and records it in one transaction, so a
+// failed migration leaves nothing behind.
+func (ds *SQLiteDataStore) ApplyMigration(version int) error {
+	for _, m := range sqliteMigrations {
+		if m.Version != version {
+			continue
+		}
+		return ds.inTx(func(tx *sql.Tx) error {
+			for _, stmt := range m.statements {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO migrations (id, description, applied_at) VALUES (?, ?, ?)`,
+				m.Version, m.Description, time.Now().UTC())
+			return err
+		})
+	}
+	return fmt.Errorf("no migration with version %d", version)
+}
@@ -0,0 +1,811 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+	"github.com/gorilla/mux"
+	"github.com/jasonwinn/geocoder"
+)
+
+const (
+	singleElimination = "single"
+	doubleElimination = "double"
+)
+
+const (
+	// nativeMatchPending matches are waiting on the matches their players come
+	// from.
+	nativeMatchPending  = "pending"
+	nativeMatchOpen     = "open"
+	nativeMatchComplete = "complete"
+	// nativeMatchBye matches are missing a player, so the other one goes
+	// through without playing.
+	nativeMatchBye = "bye"
+)
+
+// NativeEntrant is a player entered in a native bracket.
+type NativeEntrant struct {
+	Player string `gorethink:"player" json:"player"`
+	Name   string `gorethink:"name" json:"name"`
+	Seed   int    `gorethink:"seed" json:"seed"`
+	// Rank is the entrant's placing, once the bracket is finalized.
+	Rank int `gorethink:"rank" json:"rank"`
+}
+
+// NativeMatch is a match in a native bracket. Its players are empty until the
+// matches they come from are decided.
+type NativeMatch struct {
+	ID string `gorethink:"id" json:"id"`
+	// Round is numbered like Challonge's: winners rounds count up from 1 and
+	// are followed by the grand final, and losers rounds count down from -1.
+	Round   int    `gorethink:"round" json:"round"`
+	Player1 string `gorethink:"player1" json:"player1"`
+	Player2 string `gorethink:"player2" json:"player2"`
+	// Player1Prereq is the match player 1 comes from, if any, and
+	// Player1FromLoser is set if they're its loser rather than its winner.
+	Player1Prereq    string    `gorethink:"player1_prereq" json:"player1Prereq"`
+	Player1FromLoser bool      `gorethink:"player1_from_loser" json:"player1FromLoser"`
+	Player2Prereq    string    `gorethink:"player2_prereq" json:"player2Prereq"`
+	Player2FromLoser bool      `gorethink:"player2_from_loser" json:"player2FromLoser"`
+	Player1Score     int       `gorethink:"player1_score" json:"player1Score"`
+	Player2Score     int       `gorethink:"player2_score" json:"player2Score"`
+	State            string    `gorethink:"state" json:"state"`
+	CompletedAt      time.Time `gorethink:"completed_at" json:"completedAt"`
+}
+
+// NativeBracket is a single or double elimination bracket, or a round robin
+// or Swiss pool, run in velvetdb instead of on a bracket site.
+type NativeBracket struct {
+	// ID is the ID of the tournament the bracket is for.
+	ID       string          `gorethink:"id" json:"id"`
+	Format   string          `gorethink:"format" json:"format"`
+	Entrants []NativeEntrant `gorethink:"entrants" json:"entrants"`
+	Matches  []NativeMatch   `gorethink:"matches" json:"matches"`
+	// SwissRounds is how many rounds a Swiss pool runs for.
+	SwissRounds int  `gorethink:"swiss_rounds" json:"swissRounds"`
+	Finalized   bool `gorethink:"finalized" json:"finalized"`
+}
+
+// Decided reports whether the match has been played or was a bye.
+func (m *NativeMatch) Decided() bool {
+	return m.State == nativeMatchComplete || m.State == nativeMatchBye
+}
+
+// Winner returns the player who won the match or went through on a bye.
+func (m *NativeMatch) Winner() string {
+	switch {
+	case m.State == nativeMatchBye && m.Player1 != "":
+		return m.Player1
+	case m.State == nativeMatchBye:
+		return m.Player2
+	case m.State != nativeMatchComplete:
+		return ""
+	case m.Player1Score > m.Player2Score:
+		return m.Player1
+	}
+	return m.Player2
+}
+
+// Loser returns the player who lost the match, or "" for a bye.
+func (m *NativeMatch) Loser() string {
+	if m.State != nativeMatchComplete {
+		return ""
+	}
+	if m.Player1Score > m.Player2Score {
+		return m.Player2
+	}
+	return m.Player1
+}
+
+// nativeSlot is where a player in a new match comes from: an entrant, or the
+// winner or loser of another match. An empty slot is a bye.
+type nativeSlot struct {
+	player    string
+	prereq    string
+	fromLoser bool
+}
+
+// seedOrder returns the seeds of a bracket's first round from top to bottom,
+// so that 1 plays the last seed, 2 meets 1 no sooner than the final and so on.
+func seedOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		next := []int{}
+		for _, s := range order {
+			next = append(next, s, 2*len(order)+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+// winnersRounds is how many winners rounds a bracket for n entrants has,
+// not counting the grand final.
+func winnersRounds(n int) int {
+	rounds := 0
+	for size := bracketSize(n); size > 1; size /= 2 {
+		rounds++
+	}
+	return rounds
+}
+
+// newNativeBracket generates a bracket for entrants, who are in seed order.
+// Swiss pools only have their first round paired to begin with.
+func newNativeBracket(id string, format string, entrants []NativeEntrant, swissRounds int) *NativeBracket {
+	nb := &NativeBracket{ID: id, Format: format, Entrants: entrants, Matches: []NativeMatch{}}
+	switch format {
+	case roundRobin:
+		nb.roundRobin()
+	case swiss:
+		nb.SwissRounds = swissRounds
+		nb.pairSwissRound()
+	default:
+		nb.elimination()
+	}
+	nb.resolve()
+	return nb
+}
+
+// elimination generates a single or double elimination bracket. Seeds past
+// the number of entrants are byes. In double elimination, losers of each
+// winners round drop into the losers bracket opposite the half of the bracket
+// they came from, to put off rematches.
+func (nb *NativeBracket) elimination() {
+	entrants := nb.Entrants
+	add := func(round int, p1 nativeSlot, p2 nativeSlot) string {
+		m := NativeMatch{
+			ID:               strconv.Itoa(len(nb.Matches) + 1),
+			Round:            round,
+			Player1:          p1.player,
+			Player1Prereq:    p1.prereq,
+			Player1FromLoser: p1.fromLoser,
+			Player2:          p2.player,
+			Player2Prereq:    p2.prereq,
+			Player2FromLoser: p2.fromLoser,
+			State:            nativeMatchPending,
+		}
+		nb.Matches = append(nb.Matches, m)
+		return m.ID
+	}
+	winner := func(id string) nativeSlot { return nativeSlot{prereq: id} }
+	loser := func(id string) nativeSlot { return nativeSlot{prereq: id, fromLoser: true} }
+
+	rounds := winnersRounds(len(entrants))
+	winners := [][]string{{}}
+	order := seedOrder(bracketSize(len(entrants)))
+	for i := 0; i < len(order); i += 2 {
+		slots := [2]nativeSlot{}
+		for j, seed := range order[i : i+2] {
+			if seed <= len(entrants) {
+				slots[j].player = entrants[seed-1].Player
+			}
+		}
+		winners[0] = append(winners[0], add(1, slots[0], slots[1]))
+	}
+	for r := 1; r < rounds; r++ {
+		prev := winners[r-1]
+		next := []string{}
+		for i := 0; i < len(prev); i += 2 {
+			next = append(next, add(r+1, winner(prev[i]), winner(prev[i+1])))
+		}
+		winners = append(winners, next)
+	}
+
+	if nb.Format == doubleElimination {
+		final := winners[rounds-1][0]
+		finalist := loser(final)
+		if rounds > 1 {
+			round := -1
+			losers := []string{}
+			for i := 0; i < len(winners[0]); i += 2 {
+				losers = append(losers, add(round, loser(winners[0][i]), loser(winners[0][i+1])))
+			}
+			for r := 1; r < rounds; r++ {
+				round--
+				drops := winners[r]
+				next := []string{}
+				for i, id := range losers {
+					drop := drops[i]
+					if r%2 == 1 {
+						drop = drops[len(drops)-1-i]
+					}
+					next = append(next, add(round, winner(id), loser(drop)))
+				}
+				losers = next
+				if r < rounds-1 {
+					round--
+					next = []string{}
+					for i := 0; i < len(losers); i += 2 {
+						next = append(next, add(round, winner(losers[i]), winner(losers[i+1])))
+					}
+					losers = next
+				}
+			}
+			finalist = winner(losers[0])
+		}
+		add(rounds+1, winner(final), finalist)
+	}
+}
+
+func (nb *NativeBracket) match(id string) *NativeMatch {
+	for i := range nb.Matches {
+		if nb.Matches[i].ID == id {
+			return &nb.Matches[i]
+		}
+	}
+	return nil
+}
+
+// resolve fills in the players of matches whose earlier matches have been
+// decided, and puts players with byes through.
+func (nb *NativeBracket) resolve() {
+	for i := range nb.Matches {
+		if nb.Matches[i].State == nativeMatchBye {
+			nb.Matches[i].State = nativeMatchPending
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range nb.Matches {
+			m := &nb.Matches[i]
+			if m.Decided() {
+				continue
+			}
+			ready := true
+			slots := []struct {
+				player    *string
+				prereq    string
+				fromLoser bool
+			}{
+				{&m.Player1, m.Player1Prereq, m.Player1FromLoser},
+				{&m.Player2, m.Player2Prereq, m.Player2FromLoser},
+			}
+			for _, s := range slots {
+				if s.prereq == "" {
+					continue
+				}
+				prereq := nb.match(s.prereq)
+				if !prereq.Decided() {
+					*s.player = ""
+					ready = false
+				} else if s.fromLoser {
+					*s.player = prereq.Loser()
+				} else {
+					*s.player = prereq.Winner()
+				}
+			}
+			m.State = nativeMatchPending
+			if ready && (m.Player1 == "" || m.Player2 == "") {
+				m.State = nativeMatchBye
+				changed = true
+			} else if ready {
+				m.State = nativeMatchOpen
+			}
+		}
+	}
+}
+
+// dependents returns the matches that a match's players go on to, following
+// byes through to the matches after them.
+func (nb *NativeBracket) dependents(id string) []*NativeMatch {
+	found := []*NativeMatch{}
+	for i := range nb.Matches {
+		m := &nb.Matches[i]
+		if m.Player1Prereq != id && m.Player2Prereq != id {
+			continue
+		}
+		found = append(found, m)
+		if m.State == nativeMatchBye {
+			found = append(found, nb.dependents(m.ID)...)
+		}
+	}
+	return found
+}
+
+// grandFinalReset adds the second grand final when the player from the losers
+// bracket wins the first, and takes it away again if the first is corrected.
+func (nb *NativeBracket) grandFinalReset() {
+	if nb.Format != doubleElimination {
+		return
+	}
+	rounds := winnersRounds(len(nb.Entrants))
+	var final, reset *NativeMatch
+	for i := range nb.Matches {
+		switch nb.Matches[i].Round {
+		case rounds + 1:
+			final = &nb.Matches[i]
+		case rounds + 2:
+			reset = &nb.Matches[i]
+		}
+	}
+	needed := final.State == nativeMatchComplete && final.Winner() == final.Player2
+	if needed && reset == nil {
+		nb.Matches = append(nb.Matches, NativeMatch{
+			ID:               strconv.Itoa(len(nb.Matches) + 1),
+			Round:            rounds + 2,
+			Player1Prereq:    final.ID,
+			Player1FromLoser: true,
+			Player2Prereq:    final.ID,
+			State:            nativeMatchPending,
+		})
+	} else if !needed && reset != nil {
+		nb.Matches = nb.Matches[:len(nb.Matches)-1]
+	}
+}
+
+// report records a match's score and moves its players on. A reported match
+// can be corrected until a match after it has been played.
+func (nb *NativeBracket) report(id string, score1 int, score2 int) error {
+	if nb.Finalized {
+		return errors.New("the bracket has been finalized")
+	}
+	m := nb.match(id)
+	if m == nil {
+		return errNotFound
+	}
+	if m.State != nativeMatchOpen && m.State != nativeMatchComplete {
+		return errors.New("that match isn't ready to be played")
+	}
+	if score1 < 0 || score2 < 0 || score1 == score2 {
+		return errors.New("a match needs a winner")
+	}
+	if err := nb.checkPoolReport(m); err != nil {
+		return err
+	}
+	for _, d := range nb.dependents(id) {
+		if d.State == nativeMatchComplete {
+			return errors.New("a match after that one has already been played")
+		}
+	}
+	m.Player1Score, m.Player2Score = score1, score2
+	m.State = nativeMatchComplete
+	m.CompletedAt = time.Now()
+	nb.grandFinalReset()
+	nb.resolve()
+	nb.advancePool()
+	return nil
+}
+
+// Complete reports whether every match has been decided, including every
+// round of a Swiss pool.
+func (nb *NativeBracket) Complete() bool {
+	if nb.Format == swiss && nb.swissRound() < nb.SwissRounds {
+		return false
+	}
+	for i := range nb.Matches {
+		if !nb.Matches[i].Decided() {
+			return false
+		}
+	}
+	return len(nb.Matches) > 0
+}
+
+// eliminationStage orders the rounds players can be knocked out in, so
+// players knocked out later place higher.
+func (nb *NativeBracket) eliminationStage(round int) int {
+	if round < 0 {
+		return -round
+	}
+	rounds := winnersRounds(len(nb.Entrants))
+	if nb.Format == doubleElimination && round > rounds {
+		// The grand finals come after every losers round
+		return 2*(rounds-1) + round - rounds
+	}
+	return round
+}
+
+// placements ranks the entrants of a complete bracket. Players knocked out in
+// the same round share a placing, so a double elimination bracket places
+// 1, 2, 3, 4, 5, 5, 7, 7 and so on. Pools are ranked by their standings.
+func (nb *NativeBracket) placements() {
+	if nb.IsPool() {
+		ranks := map[string]int{}
+		for _, s := range nb.Standings() {
+			ranks[s.Player] = s.Rank
+		}
+		for i := range nb.Entrants {
+			nb.Entrants[i].Rank = ranks[nb.Entrants[i].Player]
+		}
+		return
+	}
+	playsOn := map[string]bool{}
+	for _, m := range nb.Matches {
+		if m.Player1FromLoser {
+			playsOn[m.Player1Prereq] = true
+		}
+		if m.Player2FromLoser {
+			playsOn[m.Player2Prereq] = true
+		}
+	}
+	eliminated := map[string]int{}
+	for i := range nb.Matches {
+		m := &nb.Matches[i]
+		if m.State == nativeMatchComplete && !playsOn[m.ID] {
+			eliminated[m.Loser()] = nb.eliminationStage(m.Round)
+		}
+	}
+	for i := range nb.Entrants {
+		e := &nb.Entrants[i]
+		e.Rank = 1
+		stage, ok := eliminated[e.Player]
+		if !ok {
+			continue
+		}
+		for _, other := range nb.Entrants {
+			if s, ok := eliminated[other.Player]; !ok || s > stage {
+				e.Rank++
+			}
+		}
+	}
+}
+
+// bracket converts the bracket to go-bracket's types, which is what importing
+// works with. Byes aren't matches on bracket sites, so they're left out.
+func (nb *NativeBracket) bracket(started time.Time) *bracket.Bracket {
+	updated := started
+	b := &bracket.Bracket{
+		State:     "underway",
+		StartedAt: &started,
+		UpdatedAt: &updated,
+		Players:   []*bracket.Player{},
+		Matches:   []*bracket.Match{},
+	}
+	if nb.Finalized {
+		b.State = "complete"
+	}
+	for _, e := range nb.Entrants {
+		b.Players = append(b.Players, &bracket.Player{ID: e.Player, Name: e.Name, Seed: e.Seed, Rank: e.Rank})
+	}
+	prereq := func(id string) *string {
+		if id == "" || nb.match(id).State == nativeMatchBye {
+			return nil
+		}
+		return &id
+	}
+	for _, m := range nb.Matches {
+		if m.State == nativeMatchBye {
+			continue
+		}
+		// Matches reported more than a day after the tournament started were
+		// entered after the fact, so they keep the tournament's date.
+		date := started
+		if m.State == nativeMatchComplete && m.CompletedAt.Sub(started) < 24*time.Hour {
+			date = m.CompletedAt
+		}
+		if date.After(updated) {
+			updated = date
+		}
+		b.Matches = append(b.Matches, &bracket.Match{
+			ID:                   m.ID,
+			State:                m.State,
+			Round:                m.Round,
+			Player1ID:            m.Player1,
+			Player2ID:            m.Player2,
+			Player1PrereqMatchID: prereq(m.Player1Prereq),
+			Player2PrereqMatchID: prereq(m.Player2Prereq),
+			Player1Score:         m.Player1Score,
+			Player2Score:         m.Player2Score,
+			UpdatedAt:            &date,
+		})
+	}
+	return b
+}
+
+// Name returns an entrant's name.
+func (nb *NativeBracket) Name(player string) string {
+	for _, e := range nb.Entrants {
+		if e.Player == player {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// NativeRound is a round of a native bracket's matches, for display.
+type NativeRound struct {
+	Name    string
+	Matches []*NativeMatch
+}
+
+// ByBracketRound sorts winners rounds first, then losers rounds from the
+// first.
+type ByBracketRound []int
+
+func (a ByBracketRound) Len() int      { return len(a) }
+func (a ByBracketRound) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByBracketRound) Less(i, j int) bool {
+	if (a[i] > 0) != (a[j] > 0) {
+		return a[i] > 0
+	}
+	if a[i] > 0 {
+		return a[i] < a[j]
+	}
+	return a[i] > a[j]
+}
+
+// Rounds returns the bracket's rounds, winners then losers, leaving out byes.
+func (nb *NativeBracket) Rounds() []NativeRound {
+	byRound := map[int][]*NativeMatch{}
+	for i := range nb.Matches {
+		m := &nb.Matches[i]
+		if m.State != nativeMatchBye {
+			byRound[m.Round] = append(byRound[m.Round], m)
+		}
+	}
+	keys := []int{}
+	for round := range byRound {
+		keys = append(keys, round)
+	}
+	sort.Sort(ByBracketRound(keys))
+
+	rounds := winnersRounds(len(nb.Entrants))
+	lowest := 0
+	for _, round := range keys {
+		if round < lowest {
+			lowest = round
+		}
+	}
+	found := []NativeRound{}
+	for _, round := range keys {
+		name := "Round " + strconv.Itoa(round)
+		switch {
+		case nb.IsPool():
+		case round == rounds+2:
+			name = "Grand Final Reset"
+		case round == rounds+1:
+			name = "Grand Final"
+		case round == rounds && nb.Format == doubleElimination:
+			name = "Winners Final"
+		case round == rounds:
+			name = "Final"
+		case round == lowest:
+			name = "Losers Final"
+		case round < 0:
+			name = "Losers Round " + strconv.Itoa(-round)
+		}
+		found = append(found, NativeRound{name, byRound[round]})
+	}
+	return found
+}
+
+func addNativeBracketHandler(w http.ResponseWriter, r *http.Request) {
+	var poolOf *Tournament
+	if id := r.FormValue("poolOf"); id != "" {
+		var err error
+		poolOf, err = dataStore.FetchTournament(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	data := struct {
+		GameTypes []GameType
+		PoolOf    *Tournament
+	}{
+		dataStore.FetchGameTypes(),
+		poolOf,
+	}
+	renderTemplate(w, r, "addNativeBracket", data)
+}
+
+func saveNativeBracketHandler(w http.ResponseWriter, r *http.Request) {
+	// Pools take their game type, date and location from their tournament
+	parent := &Tournament{GameType: r.FormValue("gametype"), City: r.FormValue("city"), State: r.FormValue("state")}
+	if id := r.FormValue("poolOf"); id != "" {
+		var err error
+		parent, err = dataStore.FetchTournament(id)
+		if err != nil {
+			http.Error(w, "No tournament with ID "+id+" found", http.StatusBadRequest)
+			return
+		}
+	}
+	gt, err := dataStore.FetchGameType(parent.GameType)
+	if err != nil {
+		http.Error(w, "No game type with ID "+parent.GameType+" found", http.StatusBadRequest)
+		return
+	}
+	format := r.FormValue("format")
+	switch format {
+	case singleElimination, roundRobin, swiss:
+	default:
+		format = doubleElimination
+	}
+	date := time.Now()
+	if parent.ID != "" {
+		date = parent.DateStart
+	} else if d := r.FormValue("date"); d != "" {
+		date, err = time.Parse("2006-01-02", d)
+		if err != nil {
+			http.Error(w, "Dates should look like 2016-05-01", http.StatusBadRequest)
+			return
+		}
+	}
+
+	names := readEntrants(r.FormValue("entrants"))
+	ids := make([]string, len(names))
+	if r.FormValue("seed") == "rating" {
+		seeds := generateSeeding(gt, names)
+		names, ids = make([]string, len(seeds)), make([]string, len(seeds))
+		for i, s := range seeds {
+			names[i], ids[i] = s.Name, s.PlayerID
+		}
+	} else {
+		for i, p := range findEntrants(names) {
+			if p != nil {
+				names[i], ids[i] = p.Nickname, p.ID
+			}
+		}
+	}
+	// An entrant listed twice is only entered once
+	entrants := []NativeEntrant{}
+	seen := map[string]bool{}
+	seenNames := map[string]bool{}
+	for i, name := range names {
+		if id := ids[i]; id != "" {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		} else {
+			if seenNames[normalizeHandle(name)] {
+				continue
+			}
+			seenNames[normalizeHandle(name)] = true
+		}
+		entrants = append(entrants, NativeEntrant{Player: ids[i], Name: name, Seed: len(entrants) + 1})
+	}
+	if len(entrants) < 2 {
+		http.Error(w, "A bracket needs at least two entrants", http.StatusBadRequest)
+		return
+	}
+	// Entrants who aren't players yet are added as new players, now that the
+	// bracket is known to be valid
+	for i := range entrants {
+		if entrants[i].Player == "" {
+			entrants[i].Player = addPlayer(Player{Nickname: entrants[i].Name})
+		}
+	}
+	// Swiss pools run long enough to leave one unbeaten player by default
+	swissRounds := winnersRounds(len(entrants))
+	if n, err := strconv.Atoi(r.FormValue("rounds")); err == nil && n > 0 {
+		swissRounds = n
+	}
+
+	city := parent.City
+	state := parent.State
+	point := parent.Location
+	if parent.ID == "" && city != "" && state != "" {
+		geocoder.SetAPIKey(siteConfiguration.MapquestApiKey)
+		lat, lng, err := geocoder.Geocode(city + "," + state)
+		if err == nil {
+			point.Lat = lat
+			point.Lon = lng
+		}
+	}
+
+	id := dataStore.AddTournament(Tournament{
+		Name:        r.FormValue("name"),
+		PoolOf:      parent.ID,
+		Tier:        parent.Tier,
+		GameType:    gt.ID,
+		DateStart:   date,
+		DateEnd:     date,
+		PlayerCount: len(entrants),
+		City:        city,
+		State:       state,
+		Location:    point,
+		Editing:     true,
+	})
+	if err := dataStore.SaveNativeBracket(newNativeBracket(id, format, entrants, swissRounds)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/bracket/"+id, http.StatusFound)
+}
+
+func viewNativeBracketHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, err := dataStore.FetchTournament(vars["tournament"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	nb, err := dataStore.FetchNativeBracket(t.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	_, logged := isLoggedIn(r)
+
+	data := struct {
+		Tournament *Tournament
+		Bracket    *NativeBracket
+		IsLoggedIn bool
+	}{
+		t,
+		nb,
+		logged,
+	}
+	renderTemplate(w, r, "nativeBracket", data)
+}
+
+func saveNativeBracketMatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nb, err := dataStore.FetchNativeBracket(vars["tournament"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	score1, err1 := strconv.Atoi(strings.TrimSpace(r.FormValue("score1")))
+	score2, err2 := strconv.Atoi(strings.TrimSpace(r.FormValue("score2")))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Scores should be numbers", http.StatusBadRequest)
+		return
+	}
+	if err := nb.report(vars["match"], score1, score2); err == errNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := dataStore.SaveNativeBracket(nb); err != nil {
+		fmt.Println(err)
+	}
+	http.Redirect(w, r, "/bracket/"+nb.ID, http.StatusFound)
+}
+
+// saveFinalizeNativeBracketHandler places the entrants of a finished bracket
+// and imports it like any other, which saves its matches and results and
+// rates them.
+func saveFinalizeNativeBracketHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, err := dataStore.FetchTournament(vars["tournament"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	nb, err := dataStore.FetchNativeBracket(t.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if nb.Finalized {
+		http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
+		return
+	}
+	if !nb.Complete() {
+		http.Error(w, "Every match has to be played before the bracket can be finalized", http.StatusBadRequest)
+		return
+	}
+
+	// The bracket is only saved as finalized once it's been imported, so a
+	// failed import can be retried
+	nb.placements()
+	nb.Finalized = true
+	b := nb.bracket(t.DateStart)
+	t.DateEnd = *b.UpdatedAt
+	if err := dataStore.UpdateTournament(t); err != nil {
+		fmt.Println(err)
+	}
+	playerMap := map[string]string{}
+	for _, e := range nb.Entrants {
+		playerMap[e.Player] = e.Player
+	}
+	if err := importBracket(t, b, playerMap); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := dataStore.SaveNativeBracket(nb); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
+}
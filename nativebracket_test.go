@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func nativeEntrants(n int) []NativeEntrant {
+	entrants := []NativeEntrant{}
+	for i := 1; i <= n; i++ {
+		entrants = append(entrants, NativeEntrant{Player: "p" + strconv.Itoa(i), Name: "Player " + strconv.Itoa(i), Seed: i})
+	}
+	return entrants
+}
+
+// playBySeed reports every open match as a 2-0 win for the better seed, until
+// there are none left.
+func playBySeed(t *testing.T, nb *NativeBracket) {
+	seeds := map[string]int{}
+	for _, e := range nb.Entrants {
+		seeds[e.Player] = e.Seed
+	}
+	for played := true; played; {
+		played = false
+		for i := range nb.Matches {
+			m := nb.Matches[i]
+			if m.State != nativeMatchOpen {
+				continue
+			}
+			score1, score2 := 2, 0
+			if seeds[m.Player2] < seeds[m.Player1] {
+				score1, score2 = 0, 2
+			}
+			if err := nb.report(m.ID, score1, score2); err != nil {
+				t.Fatalf("reporting match %s: %v", m.ID, err)
+			}
+			played = true
+			break
+		}
+	}
+}
+
+func TestNativeElimination(t *testing.T) {
+	tests := []struct {
+		format  string
+		n       int
+		matches int
+		byes    int
+		ranks   []int
+	}{
+		{singleElimination, 2, 1, 0, []int{1, 2}},
+		{singleElimination, 3, 3, 1, []int{1, 2, 3}},
+		{singleElimination, 5, 7, 3, []int{1, 2, 3, 3, 5}},
+		{singleElimination, 8, 7, 0, []int{1, 2, 3, 3, 5, 5, 5, 5}},
+		{doubleElimination, 2, 2, 0, []int{1, 2}},
+		{doubleElimination, 3, 6, 2, []int{1, 2, 3}},
+		{doubleElimination, 5, 14, 6, []int{1, 2, 3, 4, 5}},
+		{doubleElimination, 8, 14, 0, []int{1, 2, 3, 4, 5, 5, 7, 7}},
+	}
+	for _, test := range tests {
+		name := test.format + " " + strconv.Itoa(test.n)
+		nb := newNativeBracket("t", test.format, nativeEntrants(test.n), 0)
+		if len(nb.Matches) != test.matches {
+			t.Errorf("%s: got %d matches, want %d", name, len(nb.Matches), test.matches)
+		}
+		playBySeed(t, nb)
+		if !nb.Complete() {
+			t.Fatalf("%s: every match should have been played: %+v", name, nb.Matches)
+		}
+
+		byes := 0
+		losses := map[string]int{}
+		for _, m := range nb.Matches {
+			if m.State == nativeMatchBye {
+				byes++
+				continue
+			}
+			if m.Player1 == "" || m.Player2 == "" || m.Player1 == m.Player2 {
+				t.Errorf("%s: match %s was played by %q and %q", name, m.ID, m.Player1, m.Player2)
+			}
+			losses[m.Loser()]++
+		}
+		if byes != test.byes {
+			t.Errorf("%s: got %d byes, want %d", name, byes, test.byes)
+		}
+		// Byes never knock anyone out, so every player but the winner loses
+		// their way out
+		lives := 1
+		if test.format == doubleElimination {
+			lives = 2
+		}
+		for _, e := range nb.Entrants[1:] {
+			if losses[e.Player] != lives {
+				t.Errorf("%s: %s lost %d matches, want %d", name, e.Name, losses[e.Player], lives)
+			}
+		}
+		if losses["p1"] != 0 {
+			t.Errorf("%s: the top seed should be unbeaten", name)
+		}
+
+		nb.placements()
+		ranks := []int{}
+		for _, e := range nb.Entrants {
+			ranks = append(ranks, e.Rank)
+		}
+		if !reflect.DeepEqual(ranks, test.ranks) {
+			t.Errorf("%s: got placements %v, want %v", name, ranks, test.ranks)
+		}
+	}
+}
+
+func TestNativeLosersDropsAreCrossed(t *testing.T) {
+	nb := newNativeBracket("t", doubleElimination, nativeEntrants(8), 0)
+	playBySeed(t, nb)
+	played := map[[2]string]bool{}
+	for _, m := range nb.Matches {
+		if m.Round == 1 {
+			played[[2]string{m.Player1, m.Player2}] = true
+			played[[2]string{m.Player2, m.Player1}] = true
+		}
+	}
+	// Uncrossed, the losers of 4-5 and 3-6 would meet again straight away
+	for _, m := range nb.Matches {
+		if m.Round == -2 && played[[2]string{m.Player1, m.Player2}] {
+			t.Errorf("losers round 2 rematches %s and %s from the first round", m.Player1, m.Player2)
+		}
+	}
+}
+
+func TestNativeGrandFinalReset(t *testing.T) {
+	for _, n := range []int{2, 3, 5, 8} {
+		nb := newNativeBracket("t", doubleElimination, nativeEntrants(n), 0)
+		playBySeed(t, nb)
+		final := nb.Matches[len(nb.Matches)-1]
+		if final.Round != winnersRounds(n)+1 {
+			t.Fatalf("%d: the last match should be the grand final, got round %d", n, final.Round)
+		}
+
+		// The player from the losers bracket takes the first set
+		if err := nb.report(final.ID, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+		reset := nb.Matches[len(nb.Matches)-1]
+		if reset.Round != final.Round+1 || reset.State != nativeMatchOpen {
+			t.Fatalf("%d: a reset should be open, got %+v", n, reset)
+		}
+		if reset.Player1 != final.Player1 || reset.Player2 != final.Player2 {
+			t.Errorf("%d: the reset should be between the finalists", n)
+		}
+		if nb.Complete() {
+			t.Errorf("%d: the bracket isn't complete until the reset is played", n)
+		}
+
+		// Correcting the first set takes the reset away again
+		if err := nb.report(final.ID, 2, 1); err != nil {
+			t.Fatal(err)
+		}
+		if last := nb.Matches[len(nb.Matches)-1]; last.ID != final.ID {
+			t.Errorf("%d: the reset should be removed, got %+v", n, last)
+		}
+		if !nb.Complete() {
+			t.Errorf("%d: the bracket should be complete without a reset", n)
+		}
+
+		// Once the reset's been played, the first set can't be corrected
+		nb.report(final.ID, 0, 2)
+		if err := nb.report(reset.ID, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := nb.report(final.ID, 2, 0); err == nil {
+			t.Errorf("%d: the grand final shouldn't be correctable after the reset", n)
+		}
+		nb.placements()
+		if nb.Entrants[0].Rank != 2 || nb.Entrants[1].Rank != 1 {
+			t.Errorf("%d: the winner of the reset should place first, got %+v", n, nb.Entrants[:2])
+		}
+	}
+}
+
+func TestNativeReportCorrections(t *testing.T) {
+	nb := newNativeBracket("t", singleElimination, nativeEntrants(5), 0)
+	var first, second *NativeMatch
+	for i := range nb.Matches {
+		m := &nb.Matches[i]
+		switch {
+		case m.Round == 1 && m.State == nativeMatchOpen:
+			first = m
+		case m.Round == 2 && m.Player2Prereq == "2":
+			second = m
+		}
+	}
+	if first == nil || first.Player1 != "p4" || first.Player2 != "p5" {
+		t.Fatalf("4 and 5 should play the only first round match, got %+v", first)
+	}
+	if second == nil || second.Player1 != "p1" || second.State != nativeMatchPending {
+		t.Fatalf("1 should wait on the winner of 4-5 after a bye, got %+v", second)
+	}
+
+	if err := nb.report(first.ID, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if second.Player2 != "p4" || second.State != nativeMatchOpen {
+		t.Errorf("4 should go on to play 1, got %+v", second)
+	}
+	if err := nb.report(first.ID, 1, 2); err != nil {
+		t.Fatalf("a match should be correctable until the next one is played: %v", err)
+	}
+	if second.Player2 != "p5" {
+		t.Errorf("the correction should put 5 through instead, got %q", second.Player2)
+	}
+
+	if err := nb.report(second.ID, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := nb.report(first.ID, 2, 1); err == nil {
+		t.Errorf("a match shouldn't be correctable once the next one is played")
+	}
+	for _, test := range []struct{ score1, score2 int }{{1, 1}, {-1, 2}} {
+		if err := nb.report(second.ID, test.score1, test.score2); err == nil {
+			t.Errorf("a score of %d-%d should be rejected", test.score1, test.score2)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jasonwinn/geocoder"
-	r "gopkg.in/dancannon/gorethink.v2"
 	"gopkg.in/dancannon/gorethink.v2/types"
 )
 
@@ -32,9 +31,11 @@ type Player struct {
 
 var alphanumeric = regexp.MustCompile("[^A-Za-z0-9]+")
 
-func getPlayerTable() r.Term {
-	return r.Table("players")
-}
+type ByNickname []Player
+
+func (a ByNickname) Len() int           { return len(a) }
+func (a ByNickname) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByNickname) Less(i, j int) bool { return a[i].Nickname < a[j].Nickname }
 
 func addPlayer(player Player) string {
 	if player.URLPath == "" {
@@ -43,7 +44,7 @@ func addPlayer(player Player) string {
 			player.ID = dataStore.GetID()
 			player.URLPath = player.ID
 		} else {
-			_, err := fetchPlayerByURLPath(player.URLPath)
+			_, err := dataStore.FetchPlayerByURLPath(player.URLPath)
 			// if we find a player, set the URLPath to the id
 			if err == nil {
 				player.ID = dataStore.GetID()
@@ -51,97 +52,7 @@ func addPlayer(player Player) string {
 			}
 		}
 	}
-	wr, err := getPlayerTable().Insert(player).RunWrite(dataStore.GetSession())
-	if err != nil {
-		fmt.Println(err)
-	}
-	if len(wr.GeneratedKeys) != 0 {
-		return wr.GeneratedKeys[0]
-	}
-	return player.ID
-}
-
-func fetchPlayer(id string) (*Player, error) {
-	c, err := getPlayerTable().Get(id).Run(dataStore.GetSession())
-	defer c.Close()
-
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	var player *Player
-	err = c.One(&player)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return player, nil
-}
-
-func fetchPlayerByNickname(nickname string) (*Player, error) {
-	c, err := getPlayerTable().Filter(map[string]interface{}{
-		"nickname": nickname,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	var player *Player
-	err = c.One(&player)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return player, nil
-}
-
-func fetchPlayerByURLPath(urlpath string) (*Player, error) {
-	c, err := getPlayerTable().Filter(map[string]interface{}{
-		"urlpath": urlpath,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	var player *Player
-	err = c.One(&player)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return player, nil
-}
-
-func fetchPlayersSearch(nickname string) ([]*Player, error) {
-	c, err := getPlayerTable().
-		Filter(r.Row.Field("nickname").Match("(?i)" + nickname)).
-		OrderBy("nickname").Limit(10).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
-	players := []*Player{}
-	err = c.All(&players)
-	if err != nil {
-		return nil, err
-	}
-	return players, nil
-}
-
-func fetchPlayers() []Player {
-	c, err := getPlayerTable().OrderBy("nickname").Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-	players := []Player{}
-	err = c.All(&players)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return players
+	return dataStore.AddPlayer(player)
 }
 
 func addPlayerHandler(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +69,7 @@ func saveEditPlayerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playerNick := vars["playerNick"]
 
-	player, err := fetchPlayerByURLPath(playerNick)
+	player, err := dataStore.FetchPlayerByURLPath(playerNick)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -167,7 +78,7 @@ func saveEditPlayerHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	urlpath := r.FormValue("urlpath")
 	if urlpath != player.URLPath {
-		player, _ := fetchPlayerByURLPath(urlpath)
+		player, _ := dataStore.FetchPlayerByURLPath(urlpath)
 		if player != nil {
 			http.Redirect(w, r, "/editplayer/"+playerNick, http.StatusBadRequest)
 			return
@@ -209,26 +120,22 @@ func saveEditPlayerHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	update := map[string]interface{}{
-		"nickname":   r.FormValue("nickname"),
-		"urlpath":    urlpath,
-		"tag":        r.FormValue("tag"),
-		"image":      r.FormValue("image"),
-		"first_name": r.FormValue("firstname"),
-		"last_name":  r.FormValue("lastname"),
-		"city":       city,
-		"state":      state,
-		"twitter":    r.FormValue("twitter"),
-		"twitch":     r.FormValue("twitch"),
-		"facts":      facts,
-		"aliases":    aliases,
-		"characters": characters,
-		"location":   point,
-	}
-
-	_, err = getPlayerTable().Filter(map[string]interface{}{
-		"urlpath": playerNick,
-	}).Update(update).RunWrite(dataStore.GetSession())
+	player.Nickname = r.FormValue("nickname")
+	player.URLPath = urlpath
+	player.Tag = r.FormValue("tag")
+	player.Image = r.FormValue("image")
+	player.FirstName = r.FormValue("firstname")
+	player.LastName = r.FormValue("lastname")
+	player.City = city
+	player.State = state
+	player.Twitter = r.FormValue("twitter")
+	player.Twitch = r.FormValue("twitch")
+	player.Facts = facts
+	player.Aliases = aliases
+	player.Characters = characters
+	player.Location = point
+
+	err = dataStore.UpdatePlayer(player)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -239,7 +146,7 @@ func editPlayerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playerNick := vars["playerNick"]
 
-	player, err := fetchPlayerByURLPath(playerNick)
+	player, err := dataStore.FetchPlayerByURLPath(playerNick)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -255,7 +162,7 @@ func editPlayerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func playersHandler(w http.ResponseWriter, r *http.Request) {
-	players := fetchPlayers()
+	players := dataStore.FetchPlayers()
 	_, loggedIn := isLoggedIn(r)
 
 	data := struct {
@@ -272,13 +179,13 @@ func playerViewHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playerNick := vars["playerNick"]
 
-	player, err := fetchPlayerByURLPath(playerNick)
+	player, err := dataStore.FetchPlayerByURLPath(playerNick)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	players := fetchPlayers()
+	players := dataStore.FetchPlayers()
 	playerMap := make(map[string]Player)
 	for _, p := range players {
 		playerMap[p.ID] = p
@@ -291,13 +198,13 @@ func playerViewHandler(w http.ResponseWriter, r *http.Request) {
 		Matches  []Match
 	}
 
-	matches := fetchMatchesForPlayer(player.ID, canEdit)
+	matches := dataStore.FetchMatchesForPlayer(player.ID, canEdit)
 
 	gameIndex := map[string]int{}
 	gameMatches := []GameTypeMatches{}
 	for _, m := range matches {
 		if _, ok := gameIndex[m.GameType]; !ok {
-			gt, _ := fetchGameType(m.GameType)
+			gt, _ := dataStore.FetchGameType(m.GameType)
 			gameMatches = append(gameMatches, GameTypeMatches{
 				GameType: gt,
 				Matches:  []Match{},
@@ -307,29 +214,60 @@ func playerViewHandler(w http.ResponseWriter, r *http.Request) {
 		gameMatches[gameIndex[m.GameType]].Matches = append(gameMatches[gameIndex[m.GameType]].Matches, m)
 	}
 
-	type GameTypeResults struct {
+	type GameTypeRating struct {
 		GameType *GameType
-		Results  []*TournamentResult
+		History  *RatingHistory
+		Upsets   *UpsetCount
 	}
 
-	results, _ := fetchResultsForPlayer(player.ID)
+	upsetCounts := countUpsets(player.ID, matches)
+
+	gameRatings := []GameTypeRating{}
+	for _, gm := range gameMatches {
+		if gm.GameType == nil {
+			continue
+		}
+		h, err := fetchRatingHistory(gm.GameType.ID, player.ID)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if len(h.Timeline) == 0 {
+			continue
+		}
+		gameRatings = append(gameRatings, GameTypeRating{
+			GameType: gm.GameType,
+			History:  h,
+			Upsets:   upsetCounts[gm.GameType.ID],
+		})
+	}
+
+	type GameTypeResults struct {
+		GameType        *GameType
+		Results         []*TournamentResult
+		SeedPerformance *SeedPerformanceSummary
+	}
+
+	results, _ := dataStore.FetchResultsForPlayer(player.ID)
 
 	resultIndex := map[string]int{}
 
 	gameResults := []GameTypeResults{}
 	for _, result := range results {
 		if _, ok := resultIndex[result.Tournament.GameType]; !ok {
-			gt, _ := fetchGameType(result.Tournament.GameType)
+			gt, _ := dataStore.FetchGameType(result.Tournament.GameType)
 			gameResults = append(gameResults, GameTypeResults{
-				GameType: gt,
-				Results:  []*TournamentResult{},
+				GameType:        gt,
+				Results:         []*TournamentResult{},
+				SeedPerformance: &SeedPerformanceSummary{},
 			})
 			resultIndex[result.Tournament.GameType] = len(gameResults) - 1
 		}
 		gameResults[resultIndex[result.Tournament.GameType]].Results = append(gameResults[resultIndex[result.Tournament.GameType]].Results, result)
+		gameResults[resultIndex[result.Tournament.GameType]].SeedPerformance.Add(result)
 	}
 
-	ts, _ := fetchTournamentsForPlayer(player.ID)
+	ts, _ := dataStore.FetchTournamentsForPlayer(player.ID)
 	tournamentMap := map[string]*Tournament{}
 	for _, t := range ts {
 		tournamentMap[t.ID] = t
@@ -339,6 +277,7 @@ func playerViewHandler(w http.ResponseWriter, r *http.Request) {
 		Player        *Player
 		Matches       []GameTypeMatches
 		Results       []GameTypeResults
+		Ratings       []GameTypeRating
 		PlayerMap     map[string]Player
 		TournamentMap map[string]*Tournament
 		CanEdit       bool
@@ -346,6 +285,7 @@ func playerViewHandler(w http.ResponseWriter, r *http.Request) {
 		player,
 		gameMatches,
 		gameResults,
+		gameRatings,
 		playerMap,
 		tournamentMap,
 		canEdit,
@@ -366,28 +306,11 @@ func saveMergePlayersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// merge matches into the player to keep
-	getMatchTable().Filter(map[string]interface{}{
-		"player1": mergePlayerID,
-	}).Update(map[string]interface{}{
-		"player1": keepPlayerID,
-	}).RunWrite(dataStore.GetSession())
-
-	getMatchTable().Filter(map[string]interface{}{
-		"player2": mergePlayerID,
-	}).Update(map[string]interface{}{
-		"player2": keepPlayerID,
-	}).RunWrite(dataStore.GetSession())
-
-	// merge tournament results into the player to keep
-	getTournamentResultTable().Filter(map[string]interface{}{
-		"player": mergePlayerID,
-	}).Update(map[string]interface{}{
-		"player": keepPlayerID,
-	}).RunWrite(dataStore.GetSession())
-
-	// delete the player
-	getPlayerTable().Get(mergePlayerID).Delete().RunWrite(dataStore.GetSession())
+	err := dataStore.MergePlayers(keepPlayerID, mergePlayerID)
+	if err != nil {
+		fmt.Println(err)
+	}
+	rebuildAllRatings()
 
 	http.Redirect(w, r, "/players", http.StatusFound)
 }
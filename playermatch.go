@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+)
+
+const (
+	// participantMatchConfidence is how confident a suggestion has to be for
+	// the importer to pick it without asking.
+	participantMatchConfidence = 0.9
+	// participantMatchMargin is how close another player has to score to the
+	// best suggestion for the match to be ambiguous.
+	participantMatchMargin = 0.05
+	// fuzzyMatchMinSimilarity is how similar a participant's name has to be to
+	// a player's to be suggested at all.
+	fuzzyMatchMinSimilarity = 0.75
+	// fuzzyMatchWeight scales fuzzy similarity down so a fuzzy match is never
+	// confident enough to be picked on its own.
+	fuzzyMatchWeight = 0.8
+)
+
+// ParticipantHandle records that a bracket participant name was imported as a
+// player, so the same name can be matched to them next time.
+type ParticipantHandle struct {
+	ID       string    `gorethink:"id"`
+	Handle   string    `gorethink:"handle"`
+	Player   string    `gorethink:"player"`
+	LastSeen time.Time `gorethink:"last_seen"`
+}
+
+func participantHandleID(handle string, player string) string {
+	return handle + "_" + player
+}
+
+// mergedHandle re-points a merged player's handle at the player they were
+// merged into. kept is that player's own handle for the same name, if they had
+// one, which the merged handle replaces.
+func mergedHandle(h ParticipantHandle, keepID string, kept *ParticipantHandle) ParticipantHandle {
+	h.ID = participantHandleID(h.Handle, keepID)
+	h.Player = keepID
+	if kept != nil && kept.LastSeen.After(h.LastSeen) {
+		h.LastSeen = kept.LastSeen
+	}
+	return h
+}
+
+// normalizeHandle is how participant names are compared and stored.
+func normalizeHandle(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// sponsorTag matches a sponsor or team tag in front of a name, like
+// "[TSM] Leffen" or "C9 | Mango".
+var sponsorTag = regexp.MustCompile(`^\s*(\[[^\]]*\]|[^|]*\|)\s*`)
+
+// ParticipantMatch is the player suggested for a bracket participant.
+type ParticipantMatch struct {
+	*bracket.Player
+	Suggestion *Player
+	// Confidence is how sure the suggestion is, from 0 to 1.
+	Confidence float64
+	Reason     string
+	// Ambiguous is set when another player matched nearly as well.
+	Ambiguous bool
+}
+
+// Confident reports whether the suggestion is good enough to use without
+// asking.
+func (pm *ParticipantMatch) Confident() bool {
+	return pm.Suggestion != nil && !pm.Ambiguous && pm.Confidence >= participantMatchConfidence
+}
+
+// Percent formats the confidence for display.
+func (pm *ParticipantMatch) Percent() string {
+	return fmt.Sprintf("%.0f%%", pm.Confidence*100)
+}
+
+type participantCandidate struct {
+	player *Player
+	score  float64
+	reason string
+}
+
+type ByCandidateScore []*participantCandidate
+
+func (a ByCandidateScore) Len() int      { return len(a) }
+func (a ByCandidateScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByCandidateScore) Less(i, j int) bool {
+	if a[i].score != a[j].score {
+		return a[i].score > a[j].score
+	}
+	return a[i].player.Nickname < a[j].player.Nickname
+}
+
+// participantMatcher suggests players for bracket participants.
+type participantMatcher struct {
+	players []Player
+	byID    map[string]*Player
+}
+
+func newParticipantMatcher() *participantMatcher {
+	pm := &participantMatcher{
+		players: dataStore.FetchPlayers(),
+		byID:    map[string]*Player{},
+	}
+	for i := range pm.players {
+		pm.byID[pm.players[i].ID] = &pm.players[i]
+	}
+	return pm
+}
+
+// match scores every player against a participant's name and suggests the
+// best. Previous imports of the same name and exact nicknames count fully,
+// then aliases, then names with a sponsor tag stripped, then names that are
+// only similar.
+func (pm *participantMatcher) match(p *bracket.Player) *ParticipantMatch {
+	candidates := map[string]*participantCandidate{}
+	add := func(player *Player, score float64, reason string) {
+		if c, ok := candidates[player.ID]; ok && c.score >= score {
+			return
+		}
+		candidates[player.ID] = &participantCandidate{player, score, reason}
+	}
+
+	handle := normalizeHandle(p.Name)
+	stripped := normalizeHandle(sponsorTag.ReplaceAllString(p.Name, ""))
+	handles, err := dataStore.FetchParticipantHandles(handle)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, h := range handles {
+		// Players can have been merged or deleted since
+		if player, ok := pm.byID[h.Player]; ok {
+			add(player, 1, "imported before")
+		}
+	}
+
+	for i := range pm.players {
+		player := &pm.players[i]
+		nickname := normalizeHandle(player.Nickname)
+		if nickname == handle {
+			add(player, 1, "nickname")
+			continue
+		}
+		names := []string{nickname}
+		for _, a := range player.Aliases {
+			alias := normalizeHandle(a)
+			if alias == handle {
+				add(player, 0.95, "alias")
+			}
+			names = append(names, alias)
+		}
+
+		tagged := handle
+		if tag := normalizeHandle(player.Tag); tag != "" && strings.HasPrefix(handle, tag) {
+			tagged = strings.TrimLeft(strings.TrimPrefix(handle, tag), " |.-_")
+		}
+		for _, name := range names {
+			if name != "" && (name == stripped || name == tagged) && handle != name {
+				add(player, participantMatchConfidence, "without sponsor tag")
+			}
+		}
+
+		squashed := alphanumeric.ReplaceAllString(stripped, "")
+		for _, name := range names {
+			if s := similarity(squashed, alphanumeric.ReplaceAllString(name, "")); s >= fuzzyMatchMinSimilarity {
+				add(player, s*fuzzyMatchWeight, "similar to "+name)
+			}
+		}
+	}
+
+	match := &ParticipantMatch{Player: p}
+	sorted := []*participantCandidate{}
+	for _, c := range candidates {
+		sorted = append(sorted, c)
+	}
+	if len(sorted) == 0 {
+		return match
+	}
+	sort.Sort(ByCandidateScore(sorted))
+	best := sorted[0]
+	match.Suggestion = best.player
+	match.Confidence = best.score
+	match.Reason = best.reason
+	if len(sorted) > 1 && best.score-sorted[1].score < participantMatchMargin {
+		match.Ambiguous = true
+		match.Reason += ", but so does " + sorted[1].player.Nickname
+	}
+	return match
+}
+
+// matchParticipants suggests a player for each of a bracket's participants.
+func matchParticipants(participants []*bracket.Player) []*ParticipantMatch {
+	pm := newParticipantMatcher()
+	matches := make([]*ParticipantMatch, len(participants))
+	for i, p := range participants {
+		matches[i] = pm.match(p)
+	}
+	return matches
+}
+
+// recordParticipantHandles remembers which player each participant was
+// imported as.
+func recordParticipantHandles(participants []*bracket.Player, playerMap map[string]string) {
+	now := time.Now()
+	hs := []*ParticipantHandle{}
+	for _, p := range participants {
+		player, ok := playerMap[p.ID]
+		if !ok || player == "" {
+			continue
+		}
+		handle := normalizeHandle(p.Name)
+		hs = append(hs, &ParticipantHandle{
+			ID:       participantHandleID(handle, player),
+			Handle:   handle,
+			Player:   player,
+			LastSeen: now,
+		})
+	}
+	if len(hs) == 0 {
+		return
+	}
+	if err := dataStore.SaveParticipantHandles(hs); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// similarity is how alike two strings are, from 0 to 1, by edit distance.
+func similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein is the number of single character edits between two strings.
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
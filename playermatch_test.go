@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dguenther/go-bracket"
+)
+
+func TestParticipantMatchScoring(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	for _, p := range []Player{
+		{Nickname: "Mango", URLPath: "mango"},
+		{Nickname: "Hungrybox", URLPath: "hungrybox", Aliases: []string{"Hbox"}},
+		{Nickname: "Leffen", URLPath: "leffen", Tag: "TSM"},
+		{Nickname: "Plup", URLPath: "plup"},
+		{Nickname: "Scott", URLPath: "scott", Aliases: []string{"Plup"}},
+		{Nickname: "Ice", URLPath: "ice"},
+		{Nickname: "Ice", URLPath: "ice-2"},
+	} {
+		dataStore.AddPlayer(p)
+	}
+
+	tests := []struct {
+		name       string
+		suggestion string
+		confidence float64
+		reason     string
+		ambiguous  bool
+		confident  bool
+	}{
+		{"Mango", "Mango", 1, "nickname", false, true},
+		{" MANGO ", "Mango", 1, "nickname", false, true},
+		{"hbox", "Hungrybox", 0.95, "alias", false, true},
+		{"[C9] Mango", "Mango", 0.9, "without sponsor tag", false, true},
+		{"C9 | Mango", "Mango", 0.9, "without sponsor tag", false, true},
+		{"TSM Leffen", "Leffen", 0.9, "without sponsor tag", false, true},
+		// Similar names are suggested, but never confidently
+		{"Mang0", "Mango", 0.8 * 0.8, "similar to mango", false, false},
+		{"Mew2King", "", 0, "", false, false},
+		// A nickname beats an alias by the margin, so it isn't ambiguous.
+		// 1-0.95 is a hair over 0.05 in floating point, which this pins down
+		{"Plup", "Plup", 1, "nickname", false, true},
+		{"Ice", "Ice", 1, "nickname, but so does Ice", true, false},
+	}
+	pm := newParticipantMatcher()
+	for _, test := range tests {
+		m := pm.match(&bracket.Player{Name: test.name})
+		suggestion := ""
+		if m.Suggestion != nil {
+			suggestion = m.Suggestion.Nickname
+		}
+		if suggestion != test.suggestion || math.Abs(m.Confidence-test.confidence) > 1e-9 || m.Reason != test.reason {
+			t.Errorf("%q: got %q at %v (%s), want %q at %v (%s)",
+				test.name, suggestion, m.Confidence, m.Reason, test.suggestion, test.confidence, test.reason)
+		}
+		if m.Ambiguous != test.ambiguous || m.Confident() != test.confident {
+			t.Errorf("%q: got ambiguous %v and confident %v, want %v and %v",
+				test.name, m.Ambiguous, m.Confident(), test.ambiguous, test.confident)
+		}
+	}
+}
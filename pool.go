@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	roundRobin = "roundrobin"
+	swiss      = "swiss"
+)
+
+// Standing is an entrant's record in a round robin or Swiss pool. Byes count
+// as wins.
+type Standing struct {
+	Player    string
+	Name      string
+	Seed      int
+	Rank      int
+	Wins      int
+	Losses    int
+	Byes      int
+	GamesWon  int
+	GamesLost int
+
+	// headToHead is how many matches the player won against the players
+	// they're tied with on wins.
+	headToHead int
+}
+
+// GameDifferential is how many more games the player won than lost.
+func (s *Standing) GameDifferential() int {
+	return s.GamesWon - s.GamesLost
+}
+
+// ByStanding sorts standings by wins, then head-to-head, game differential
+// and games won, then seed.
+type ByStanding []*Standing
+
+func (a ByStanding) Len() int      { return len(a) }
+func (a ByStanding) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByStanding) Less(i, j int) bool {
+	if a[i].Wins != a[j].Wins {
+		return a[i].Wins > a[j].Wins
+	}
+	if !a[i].tied(a[j]) {
+		if a[i].headToHead != a[j].headToHead {
+			return a[i].headToHead > a[j].headToHead
+		}
+		if a[i].GameDifferential() != a[j].GameDifferential() {
+			return a[i].GameDifferential() > a[j].GameDifferential()
+		}
+		return a[i].GamesWon > a[j].GamesWon
+	}
+	return a[i].Seed < a[j].Seed
+}
+
+// tied reports whether two standings are level on every tiebreaker, so they
+// share a placing.
+func (s *Standing) tied(o *Standing) bool {
+	return s.Wins == o.Wins && s.headToHead == o.headToHead &&
+		s.GameDifferential() == o.GameDifferential() && s.GamesWon == o.GamesWon
+}
+
+// IsPool reports whether the bracket is a round robin or Swiss pool rather
+// than an elimination bracket.
+func (nb *NativeBracket) IsPool() bool {
+	return nb.Format == roundRobin || nb.Format == swiss
+}
+
+// Standings ranks a pool's entrants by their results so far.
+func (nb *NativeBracket) Standings() []*Standing {
+	byPlayer := map[string]*Standing{}
+	standings := []*Standing{}
+	for _, e := range nb.Entrants {
+		s := &Standing{Player: e.Player, Name: e.Name, Seed: e.Seed}
+		byPlayer[e.Player] = s
+		standings = append(standings, s)
+	}
+	beat := map[string]map[string]int{}
+	for i := range nb.Matches {
+		m := &nb.Matches[i]
+		if m.State == nativeMatchBye {
+			if s, ok := byPlayer[m.Winner()]; ok {
+				s.Wins++
+				s.Byes++
+			}
+			continue
+		}
+		if m.State != nativeMatchComplete {
+			continue
+		}
+		p1, p2 := byPlayer[m.Player1], byPlayer[m.Player2]
+		p1.GamesWon += m.Player1Score
+		p1.GamesLost += m.Player2Score
+		p2.GamesWon += m.Player2Score
+		p2.GamesLost += m.Player1Score
+		winner, loser := byPlayer[m.Winner()], byPlayer[m.Loser()]
+		winner.Wins++
+		loser.Losses++
+		if beat[winner.Player] == nil {
+			beat[winner.Player] = map[string]int{}
+		}
+		beat[winner.Player][loser.Player]++
+	}
+
+	for _, s := range standings {
+		s.headToHead = 0
+		for _, o := range standings {
+			if o.Wins == s.Wins {
+				s.headToHead += beat[s.Player][o.Player]
+			}
+		}
+	}
+	sort.Sort(ByStanding(standings))
+	for i, s := range standings {
+		s.Rank = i + 1
+		if i > 0 && s.tied(standings[i-1]) {
+			s.Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// roundRobin pairs every entrant with every other by the circle method: one
+// entrant stays put while the rest rotate around them each round.
+func (nb *NativeBracket) roundRobin() {
+	players := []string{}
+	for _, e := range nb.Entrants {
+		players = append(players, e.Player)
+	}
+	if len(players)%2 == 1 {
+		players = append(players, "")
+	}
+	n := len(players)
+	for round := 1; round < n; round++ {
+		for i := 0; i < n/2; i++ {
+			p1, p2 := players[i], players[n-1-i]
+			if p1 != "" && p2 != "" {
+				nb.addPoolMatch(round, p1, p2)
+			}
+		}
+		players = append([]string{players[0], players[n-1]}, players[1:n-1]...)
+	}
+}
+
+func (nb *NativeBracket) addPoolMatch(round int, p1 string, p2 string) {
+	nb.Matches = append(nb.Matches, NativeMatch{
+		ID:      strconv.Itoa(len(nb.Matches) + 1),
+		Round:   round,
+		Player1: p1,
+		Player2: p2,
+		State:   nativeMatchPending,
+	})
+}
+
+// swissRound is the round a Swiss pool is on.
+func (nb *NativeBracket) swissRound() int {
+	round := 0
+	for _, m := range nb.Matches {
+		if m.Round > round {
+			round = m.Round
+		}
+	}
+	return round
+}
+
+// pairSwissRound pairs the next round of a Swiss pool. The first round pairs
+// the top half of the seeds against the bottom half, and later rounds pair
+// players with those next to them in the standings, avoiding rematches where
+// possible. With an odd number of entrants, the lowest placed player who
+// hasn't had a bye gets one.
+func (nb *NativeBracket) pairSwissRound() {
+	round := nb.swissRound() + 1
+	standings := nb.Standings()
+	if len(standings)%2 == 1 {
+		for i := len(standings) - 1; i >= 0; i-- {
+			if standings[i].Byes == 0 || i == 0 {
+				nb.addPoolMatch(round, standings[i].Player, "")
+				standings = append(standings[:i], standings[i+1:]...)
+				break
+			}
+		}
+	}
+	players := make([]string, len(standings))
+	for i, s := range standings {
+		players[i] = s.Player
+	}
+
+	if round == 1 {
+		half := len(players) / 2
+		for i := 0; i < half; i++ {
+			nb.addPoolMatch(round, players[i], players[i+half])
+		}
+		return
+	}
+
+	played := map[string]bool{}
+	for _, m := range nb.Matches {
+		played[m.Player1+m.Player2] = true
+		played[m.Player2+m.Player1] = true
+	}
+	pairs, ok := swissPairs(players, played)
+	if !ok {
+		pairs, _ = swissPairs(players, map[string]bool{})
+	}
+	for _, p := range pairs {
+		nb.addPoolMatch(round, p[0], p[1])
+	}
+}
+
+// swissPairs pairs players from the top down, each with the highest placed
+// player they haven't played, backtracking when that leaves someone without an
+// opponent.
+func swissPairs(players []string, played map[string]bool) ([][2]string, bool) {
+	if len(players) == 0 {
+		return [][2]string{}, true
+	}
+	p := players[0]
+	for i := 1; i < len(players); i++ {
+		q := players[i]
+		if played[p+q] {
+			continue
+		}
+		rest := append(append([]string{}, players[1:i]...), players[i+1:]...)
+		if pairs, ok := swissPairs(rest, played); ok {
+			return append([][2]string{{p, q}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// checkPoolReport checks that a pool match can be reported. Swiss matches
+// can't be corrected once the next round has been paired, since the pairings
+// depend on them.
+func (nb *NativeBracket) checkPoolReport(m *NativeMatch) error {
+	if nb.Format == swiss && m.Round < nb.swissRound() {
+		return errors.New("the next round has already been paired")
+	}
+	return nil
+}
+
+// advancePool pairs the next Swiss round once every match of the current one
+// has been played.
+func (nb *NativeBracket) advancePool() {
+	if nb.Format != swiss || nb.swissRound() >= nb.SwissRounds {
+		return
+	}
+	round := nb.swissRound()
+	for _, m := range nb.Matches {
+		if m.Round == round && !m.Decided() {
+			return
+		}
+	}
+	nb.pairSwissRound()
+	nb.resolve()
+}
+
+// placePoolPlayers places players who were knocked out in a tournament's
+// native pools, below everyone in its final bracket. Players who finished in
+// the same position in their pools share a placing. It waits for the final
+// bracket to be imported, since that's what decides who was knocked out.
+func placePoolPlayers(rootID string) {
+	root, err := dataStore.FetchTournament(rootID)
+	if err != nil || root.Editing {
+		return
+	}
+	pools, err := dataStore.FetchTournamentPools(root.ID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	poolRanks := map[string]int{}
+	for _, pool := range pools {
+		nb, err := dataStore.FetchNativeBracket(pool.ID)
+		if err != nil || !nb.IsPool() || !nb.Finalized {
+			continue
+		}
+		for _, e := range nb.Entrants {
+			if r, ok := poolRanks[e.Player]; !ok || e.Rank < r {
+				poolRanks[e.Player] = e.Rank
+			}
+		}
+	}
+	if len(poolRanks) == 0 {
+		return
+	}
+
+	inBracket := map[string]bool{}
+	for _, m := range dataStore.FetchMatchesForTournament(root.ID, true) {
+		inBracket[m.Player1] = true
+		inBracket[m.Player2] = true
+	}
+	results, err := dataStore.FetchResultsForTournament(root.ID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, tr := range results {
+		rank, ok := poolRanks[tr.Player]
+		if !ok || inBracket[tr.Player] {
+			continue
+		}
+		place := len(inBracket) + 1
+		for p, r := range poolRanks {
+			if !inBracket[p] && r < rank {
+				place++
+			}
+		}
+		if tr.Place != place {
+			tr.Place = place
+			if err := dataStore.UpdateTournamentResult(tr); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
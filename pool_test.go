@@ -0,0 +1,286 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinPairings(t *testing.T) {
+	for _, n := range []int{2, 4, 5, 6} {
+		nb := newNativeBracket("t", roundRobin, nativeEntrants(n), 0)
+		if want := n * (n - 1) / 2; len(nb.Matches) != want {
+			t.Errorf("%d: got %d matches, want %d", n, len(nb.Matches), want)
+		}
+		rounds := n - 1
+		if n%2 == 1 {
+			rounds = n
+		}
+		pairs := map[[2]string]int{}
+		perRound := map[int]map[string]bool{}
+		for _, m := range nb.Matches {
+			if m.State != nativeMatchOpen {
+				t.Errorf("%d: every round robin match should be open from the start, got %+v", n, m)
+			}
+			if m.Round < 1 || m.Round > rounds {
+				t.Errorf("%d: match %s is in round %d of %d", n, m.ID, m.Round, rounds)
+			}
+			if perRound[m.Round] == nil {
+				perRound[m.Round] = map[string]bool{}
+			}
+			for _, p := range []string{m.Player1, m.Player2} {
+				if perRound[m.Round][p] {
+					t.Errorf("%d: %s plays twice in round %d", n, p, m.Round)
+				}
+				perRound[m.Round][p] = true
+			}
+			if m.Player1 > m.Player2 {
+				m.Player1, m.Player2 = m.Player2, m.Player1
+			}
+			pairs[[2]string{m.Player1, m.Player2}]++
+		}
+		for pair, count := range pairs {
+			if count != 1 {
+				t.Errorf("%d: %s and %s play %d times", n, pair[0], pair[1], count)
+			}
+		}
+		// With an odd number of entrants, everyone sits out exactly one round
+		if n%2 == 1 {
+			for _, e := range nb.Entrants {
+				satOut := 0
+				for round := 1; round <= rounds; round++ {
+					if !perRound[round][e.Player] {
+						satOut++
+					}
+				}
+				if satOut != 1 {
+					t.Errorf("%d: %s sits out %d rounds", n, e.Player, satOut)
+				}
+			}
+		}
+	}
+}
+
+func TestSwissByes(t *testing.T) {
+	nb := newNativeBracket("t", swiss, nativeEntrants(5), 5)
+	first := [][2]string{}
+	for _, m := range nb.Matches {
+		first = append(first, [2]string{m.Player1, m.Player2})
+	}
+	want := [][2]string{{"p5", ""}, {"p1", "p3"}, {"p2", "p4"}}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("the last seed should get the first bye and the top half play the bottom half, got %v", first)
+	}
+
+	playBySeed(t, nb)
+	if !nb.Complete() || nb.swissRound() != 5 {
+		t.Fatalf("all 5 rounds should have been played, got %d", nb.swissRound())
+	}
+	byes := map[string]int{}
+	for round := 1; round <= 5; round++ {
+		count := 0
+		for _, m := range nb.Matches {
+			if m.Round == round && m.State == nativeMatchBye {
+				count++
+				byes[m.Winner()]++
+			}
+		}
+		if count != 1 {
+			t.Errorf("round %d has %d byes, want 1", round, count)
+		}
+	}
+	for _, e := range nb.Entrants {
+		if byes[e.Player] != 1 {
+			t.Errorf("%s got %d byes over 5 rounds, want 1", e.Player, byes[e.Player])
+		}
+	}
+}
+
+func TestSwissPairs(t *testing.T) {
+	played := func(pairs ...string) map[string]bool {
+		m := map[string]bool{}
+		for i := 0; i < len(pairs); i += 2 {
+			m[pairs[i]+pairs[i+1]] = true
+			m[pairs[i+1]+pairs[i]] = true
+		}
+		return m
+	}
+	tests := []struct {
+		played map[string]bool
+		want   [][2]string
+		ok     bool
+	}{
+		{played(), [][2]string{{"a", "b"}, {"c", "d"}}, true},
+		{played("a", "b"), [][2]string{{"a", "c"}, {"b", "d"}}, true},
+		// Pairing a with c would leave b and d, who have played
+		{played("a", "b", "b", "d"), [][2]string{{"a", "d"}, {"b", "c"}}, true},
+		{played("a", "b", "a", "c", "a", "d"), nil, false},
+	}
+	for _, test := range tests {
+		got, ok := swissPairs([]string{"a", "b", "c", "d"}, test.played)
+		if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("swissPairs(%v) = %v, %v, want %v, %v", test.played, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestSwissAvoidsRematches(t *testing.T) {
+	nb := newNativeBracket("t", swiss, nativeEntrants(4), 4)
+	playBySeed(t, nb)
+	if nb.swissRound() != 4 {
+		t.Fatalf("all 4 rounds should have been paired, got %d", nb.swissRound())
+	}
+	pairs := map[[2]string]int{}
+	for _, m := range nb.Matches {
+		if m.Round > 3 {
+			continue
+		}
+		if m.Player1 > m.Player2 {
+			m.Player1, m.Player2 = m.Player2, m.Player1
+		}
+		pairs[[2]string{m.Player1, m.Player2}]++
+	}
+	if len(pairs) != 6 {
+		t.Errorf("4 players should play everyone once over 3 rounds, got %v", pairs)
+	}
+
+	// Everyone has played everyone by round 4, so it falls back to pairing
+	// by the standings
+	last := [][2]string{}
+	for _, m := range nb.Matches {
+		if m.Round == 4 {
+			last = append(last, [2]string{m.Player1, m.Player2})
+		}
+	}
+	if want := [][2]string{{"p1", "p2"}, {"p3", "p4"}}; !reflect.DeepEqual(last, want) {
+		t.Errorf("round 4 should pair by the standings, got %v", last)
+	}
+}
+
+func TestPoolStandingsTiebreakers(t *testing.T) {
+	result := func(round int, p1 string, p2 string, score1 int, score2 int) NativeMatch {
+		return NativeMatch{Round: round, Player1: p1, Player2: p2, Player1Score: score1, Player2Score: score2, State: nativeMatchComplete}
+	}
+	tests := []struct {
+		name    string
+		n       int
+		matches []NativeMatch
+		players []string
+		ranks   []int
+	}{
+		{
+			// p1 and p2 are level on wins, and p1 won their match despite a
+			// worse game differential; p4 beat p3 for third the same way
+			"head-to-head", 4,
+			[]NativeMatch{
+				result(1, "p1", "p2", 2, 1), result(1, "p3", "p4", 0, 2),
+				result(2, "p1", "p3", 0, 2), result(2, "p2", "p4", 2, 0),
+				result(3, "p1", "p4", 2, 1), result(3, "p2", "p3", 2, 0),
+			},
+			[]string{"p1", "p2", "p4", "p3"},
+			[]int{1, 2, 3, 4},
+		},
+		{
+			// Everyone beat somebody, so the game differential decides
+			"game differential", 3,
+			[]NativeMatch{
+				result(1, "p1", "p2", 2, 0), result(2, "p2", "p3", 2, 1), result(3, "p3", "p1", 2, 1),
+			},
+			[]string{"p1", "p3", "p2"},
+			[]int{1, 2, 3},
+		},
+		{
+			"tied", 3,
+			[]NativeMatch{
+				result(1, "p1", "p2", 2, 1), result(2, "p2", "p3", 2, 1), result(3, "p3", "p1", 2, 1),
+			},
+			[]string{"p1", "p2", "p3"},
+			[]int{1, 1, 1},
+		},
+	}
+	for _, test := range tests {
+		nb := &NativeBracket{Format: roundRobin, Entrants: nativeEntrants(test.n), Matches: test.matches}
+		players, ranks := []string{}, []int{}
+		for _, s := range nb.Standings() {
+			players = append(players, s.Player)
+			ranks = append(ranks, s.Rank)
+		}
+		if !reflect.DeepEqual(players, test.players) || !reflect.DeepEqual(ranks, test.ranks) {
+			t.Errorf("%s: got %v ranked %v, want %v ranked %v", test.name, players, ranks, test.players, test.ranks)
+		}
+	}
+}
+
+func TestPoolPlacementsFeedResults(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1}
+	gt.ID = dataStore.AddGameType(gt)
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	rootID := dataStore.AddTournament(Tournament{Name: "Major", GameType: gt.ID, DateStart: date, Editing: true})
+	players := []string{}
+	for _, name := range []string{"One", "Two", "Three", "Four", "Five", "Six"} {
+		players = append(players, dataStore.AddPlayer(Player{Nickname: name}))
+	}
+
+	// finalize plays a native bracket out by seed and imports it, like the
+	// finalize handler
+	finalize := func(t *testing.T, tournament *Tournament, format string, seeded ...int) {
+		entrants := []NativeEntrant{}
+		for i, p := range seeded {
+			entrants = append(entrants, NativeEntrant{Player: players[p-1], Name: players[p-1], Seed: i + 1})
+		}
+		nb := newNativeBracket(tournament.ID, format, entrants, 0)
+		playBySeed(t, nb)
+		nb.placements()
+		nb.Finalized = true
+		if err := dataStore.SaveNativeBracket(nb); err != nil {
+			t.Fatal(err)
+		}
+		playerMap := map[string]string{}
+		for _, e := range nb.Entrants {
+			playerMap[e.Player] = e.Player
+		}
+		if err := importBracket(tournament, nb.bracket(date), playerMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, seeded := range [][]int{{1, 3, 5}, {2, 4, 6}} {
+		pool := &Tournament{GameType: gt.ID, PoolOf: rootID, DateStart: date, Editing: true}
+		pool.ID = dataStore.AddTournament(*pool)
+		finalize(t, pool, roundRobin, seeded...)
+	}
+
+	// Nobody is placed until the final bracket has been imported
+	results, err := dataStore.FetchResultsForTournament(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("every pool player should have a result, got %d", len(results))
+	}
+	for _, tr := range results {
+		if tr.Place != 0 {
+			t.Errorf("pool results shouldn't be placed before the final bracket, got %+v", tr)
+		}
+	}
+
+	// The top two of each pool go through, and the rest share fifth
+	root, err := dataStore.FetchTournament(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finalize(t, root, singleElimination, 1, 2, 3, 4)
+	results, err = dataStore.FetchResultsForTournament(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	places := map[string]int{}
+	for _, tr := range results {
+		places[tr.Player] = tr.Place
+	}
+	for i, want := range []int{1, 2, 3, 3, 5, 5} {
+		if places[players[i]] != want {
+			t.Errorf("player %d placed %d, want %d", i+1, places[players[i]], want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// predictBestOf is the set length predictions assume when none is given.
+const predictBestOf = 3
+
+// Prediction is how a set between two players is expected to go, next to
+// how their sets have actually gone. Probabilities are player 1's.
+type Prediction struct {
+	Player1       string     `json:"player1"`
+	Player2       string     `json:"player2"`
+	GameType      string     `json:"gametype"`
+	Rating1       int        `json:"rating1"`
+	Rating2       int        `json:"rating2"`
+	BestOf        int        `json:"bestOf"`
+	GameWin       float64    `json:"gameWin"`
+	SetWin        float64    `json:"setWin"`
+	ExpectedGames [2]float64 `json:"expectedGames"`
+
+	Sets       [2]int   `json:"sets"`
+	Games      [2]int   `json:"games"`
+	ActualSet  *float64 `json:"actualSetWin,omitempty"`
+	ActualGame *float64 `json:"actualGameWin,omitempty"`
+}
+
+// Percent formats one of the prediction's probabilities for display.
+func (pred *Prediction) Percent(p float64) string {
+	return fmt.Sprintf("%.0f%%", p*100)
+}
+
+// binomial returns n choose k.
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// setScores returns the chance of each way a best-of set can finish, given
+// player 1's chance of winning a game. scores[i][j] is the chance of it
+// ending i games to j.
+func setScores(p float64, bestOf int) [][]float64 {
+	need := bestOf/2 + 1
+	scores := make([][]float64, need+1)
+	for i := range scores {
+		scores[i] = make([]float64, need+1)
+	}
+	q := 1 - p
+	for k := 0; k < need; k++ {
+		ways := binomial(need-1+k, k)
+		scores[need][k] = ways * math.Pow(p, float64(need)) * math.Pow(q, float64(k))
+		scores[k][need] = ways * math.Pow(q, float64(need)) * math.Pow(p, float64(k))
+	}
+	return scores
+}
+
+// setWinProbability is player 1's chance of winning a best-of set.
+func setWinProbability(p float64, bestOf int) float64 {
+	need := bestOf/2 + 1
+	var win float64
+	for _, chance := range setScores(p, bestOf)[need] {
+		win += chance
+	}
+	return win
+}
+
+// gameWinProbability finds the chance of winning a game that gives the chance
+// of winning a set.
+func gameWinProbability(setWin float64, bestOf int) float64 {
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 50; i++ {
+		mid := (lo + hi) / 2
+		if setWinProbability(mid, bestOf) < setWin {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// predictMatch predicts a best-of set between two players in a game type.
+// Players who aren't rated yet are predicted from their start rating.
+func predictMatch(gt *GameType, player1 string, player2 string, bestOf int) *Prediction {
+	if bestOf < 1 {
+		bestOf = predictBestOf
+	}
+	engine := ratingEngineFor(gt)
+	ratings := map[string]*Rating{}
+	for _, p := range []string{player1, player2} {
+		if r, err := dataStore.FetchRating(gt.ID, p); err == nil {
+			ratings[p] = r
+		}
+	}
+	rs := ratingsFor(engine, gt.ID, ratings, player1, player2)
+
+	pred := &Prediction{
+		Player1:  player1,
+		Player2:  player2,
+		GameType: gt.ID,
+		Rating1:  rs[0].Rating,
+		Rating2:  rs[1].Rating,
+		BestOf:   bestOf,
+	}
+	p, perGame := engine.Predict(rs[0], rs[1])
+	if perGame {
+		pred.GameWin = p
+		pred.SetWin = setWinProbability(p, bestOf)
+	} else {
+		pred.SetWin = p
+		pred.GameWin = gameWinProbability(p, bestOf)
+	}
+	for i, row := range setScores(pred.GameWin, bestOf) {
+		for j, chance := range row {
+			pred.ExpectedGames[0] += float64(i) * chance
+			pred.ExpectedGames[1] += float64(j) * chance
+		}
+	}
+
+	for _, m := range *dataStore.FetchMatchesForPlayers(player1, player2, false) {
+		if m.GameType != gt.ID {
+			continue
+		}
+		s1, s2 := m.Player1score, m.Player2score
+		if m.Player1 != player1 {
+			s1, s2 = s2, s1
+		}
+		pred.Games[0] += s1
+		pred.Games[1] += s2
+		if s1 > s2 {
+			pred.Sets[0]++
+		} else if s2 > s1 {
+			pred.Sets[1]++
+		}
+	}
+	if sets := pred.Sets[0] + pred.Sets[1]; sets > 0 {
+		actual := float64(pred.Sets[0]) / float64(sets)
+		pred.ActualSet = &actual
+	}
+	if games := pred.Games[0] + pred.Games[1]; games > 0 {
+		actual := float64(pred.Games[0]) / float64(games)
+		pred.ActualGame = &actual
+	}
+	return pred
+}
+
+// readBestOf reads a set length from a form, falling back to the default
+// for anything that isn't a positive odd number.
+func readBestOf(s string) int {
+	bestOf, err := strconv.Atoi(s)
+	if err != nil || bestOf < 1 || bestOf%2 == 0 {
+		return predictBestOf
+	}
+	return bestOf
+}
@@ -1,50 +1,184 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func rankPlayers(gameType string) map[string]*EloDict {
-	players := fetchPlayers()
-	playerDict := make(map[string]Player)
-	rankDict := make(map[string]*EloDict)
+// rankPlayers replays every visible match of a game type from the start and
+// returns the resulting ratings keyed by player ID, along with every change
+// made along the way.
+func rankPlayers(gameType string) (map[string]*Rating, []*RatingChange) {
+	matches := dataStore.FetchMatchesForGameType(gameType, false)
 
-	for _, p := range players {
-		playerDict[p.ID] = p
+	ratings := make(map[string]*Rating)
+	changes := fetchMatchRatingEngine(gameType).Rate(gameType, ratings, matches)
+	return ratings, changes
+}
+
+// RankingFilter decides who is eligible to be ranked and how much inactive
+// players decay. It starts out as the game type's settings, and each one can
+// be overridden from the rankings page.
+type RankingFilter struct {
+	MinMatches     int
+	MinOpponents   int
+	MinTournaments int
+	ActiveMonths   int
+	DecayPerMonth  int
+}
+
+func rankingFilterFor(gt *GameType) RankingFilter {
+	return RankingFilter{
+		MinMatches:     gt.MinMatches,
+		MinOpponents:   gt.MinOpponents,
+		MinTournaments: gt.MinTournaments,
+		ActiveMonths:   gt.ActiveMonths,
+		DecayPerMonth:  gt.DecayPerMonth,
 	}
+}
 
-	c, err := getMatchTable().Filter(map[string]interface{}{
-		"gametype": gameType,
-		"hidden":   false,
-	}).OrderBy("date").Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
+// readRankingFilter overrides the filter with any values in the query string.
+func readRankingFilter(r *http.Request, f *RankingFilter) {
+	fields := map[string]*int{
+		"minmatches":     &f.MinMatches,
+		"minopponents":   &f.MinOpponents,
+		"mintournaments": &f.MinTournaments,
+		"activemonths":   &f.ActiveMonths,
+		"decaypermonth":  &f.DecayPerMonth,
+	}
+	for name, field := range fields {
+		if n, err := strconv.Atoi(r.FormValue(name)); err == nil && n >= 0 {
+			*field = n
+		}
+	}
+}
+
+// needsMatches reports whether the filter has to look at players' matches
+// rather than just their ratings.
+func (f RankingFilter) needsMatches() bool {
+	return f.MinOpponents > 0 || f.MinTournaments > 0
+}
+
+// playerActivity is who a player has played and where, for checking whether
+// they're eligible to be ranked.
+type playerActivity struct {
+	opponents   map[string]bool
+	tournaments map[string]bool
+}
+
+// activityFor collects each player's opponents, and the tournaments they've
+// played in since the given time.
+func activityFor(matches []Match, since time.Time) map[string]*playerActivity {
+	activity := make(map[string]*playerActivity)
+	get := func(id string) *playerActivity {
+		a, ok := activity[id]
+		if !ok {
+			a = &playerActivity{opponents: map[string]bool{}, tournaments: map[string]bool{}}
+			activity[id] = a
+		}
+		return a
+	}
+	for _, m := range matches {
+		p1, p2 := get(m.Player1), get(m.Player2)
+		p1.opponents[m.Player2] = true
+		p2.opponents[m.Player1] = true
+		if m.Tournament != "" && !m.Date.Before(since) {
+			p1.tournaments[m.Tournament] = true
+			p2.tournaments[m.Tournament] = true
+		}
+	}
+	return activity
+}
+
+// monthsBetween returns the number of whole months from a to b.
+func monthsBetween(a time.Time, b time.Time) int {
+	months := (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+	if b.Day() < a.Day() {
+		months--
 	}
+	return months
+}
 
-	e := &Elo{k: 32}
+// apply returns the ratings of the players who are eligible as of asOf, with
+// decay taken off anyone who's been inactive. Tournaments only count if they
+// were in the last ActiveMonths months, and decay needs ActiveMonths to be
+// set. The ratings passed in aren't changed.
+func (f RankingFilter) apply(engine RatingEngine, ratings []*Rating, matches []Match, asOf time.Time) []*Rating {
+	var since time.Time
+	if f.ActiveMonths > 0 {
+		since = asOf.AddDate(0, -f.ActiveMonths, 0)
+	}
+	var activity map[string]*playerActivity
+	if f.needsMatches() {
+		activity = activityFor(matches, since)
+	}
 
-	var m Match
-	for c.Next(&m) {
-		if _, ok := rankDict[m.Player1]; !ok {
-			rankDict[m.Player1] = NewEloDict(playerDict[m.Player1])
+	eligible := []*Rating{}
+	for _, r := range ratings {
+		if r.Matches < f.MinMatches {
+			continue
+		}
+		if activity != nil {
+			a, ok := activity[r.Player]
+			if !ok || len(a.opponents) < f.MinOpponents || len(a.tournaments) < f.MinTournaments {
+				continue
+			}
 		}
-		if _, ok := rankDict[m.Player2]; !ok {
-			rankDict[m.Player2] = NewEloDict(playerDict[m.Player2])
+		if f.ActiveMonths > 0 && f.DecayPerMonth > 0 && r.LastMatch.Before(since) {
+			decayed := *r
+			decayed.Rating -= (monthsBetween(r.LastMatch, asOf) - f.ActiveMonths) * f.DecayPerMonth
+			// Decay never takes a player below the start rating.
+			if start := engine.NewRating(r.GameType, r.Player).Rating; decayed.Rating < start {
+				decayed.Rating = start
+				if r.Rating < start {
+					decayed.Rating = r.Rating
+				}
+			}
+			r = &decayed
 		}
-		expectedScore1 := e.getExpected(rankDict[m.Player1].Rank, rankDict[m.Player2].Rank)
-		expectedScore2 := e.getExpected(rankDict[m.Player2].Rank, rankDict[m.Player1].Rank)
+		eligible = append(eligible, r)
+	}
+	return eligible
+}
+
+// fetchRankings ranks a game type by its stored ratings, with the filter
+// applied. Players who are too uncertain for the game type's MaxDeviation are
+// left out.
+func fetchRankings(gt *GameType, f RankingFilter) ([]*EloDict, error) {
+	ratings, err := dataStore.FetchRatings(gt.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	if f.needsMatches() {
+		matches = dataStore.FetchMatchesForGameType(gt.ID, false)
+	}
+	ratings = f.apply(ratingEngineFor(gt), ratings, matches, time.Now())
+	return ranksFromRatings(gt, ratings), nil
+}
 
-		player1results := float64(m.Player1score) / float64(m.Player1score+m.Player2score)
+// ranksFromRatings sorts ratings into rankings, leaving out anyone too
+// uncertain for the game type's MaxDeviation.
+func ranksFromRatings(gt *GameType, ratings []*Rating) []*EloDict {
+	playerDict := make(map[string]Player)
+	for _, p := range dataStore.FetchPlayers() {
+		playerDict[p.ID] = p
+	}
 
-		rankDict[m.Player1].Rank = e.updateRating(expectedScore1, player1results, rankDict[m.Player1].Rank)
-		rankDict[m.Player2].Rank = e.updateRating(expectedScore2, 1-player1results, rankDict[m.Player2].Rank)
+	ranks := []*EloDict{}
+	for _, r := range ratings {
+		if gt.MaxDeviation > 0 && r.Deviation > gt.MaxDeviation {
+			continue
+		}
+		ranks = append(ranks, &EloDict{Player: playerDict[r.Player], Rank: r.Rating, Deviation: r.Deviation})
 	}
-	return rankDict
+	sort.Sort(ByRank(ranks))
+	return ranks
 }
 
 func rankingsHandler(w http.ResponseWriter, r *http.Request) {
@@ -53,36 +187,69 @@ func rankingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var gameTypes []GameType
 	var selectedType *GameType
+	var seasons []*Season
+	var selectedSeason *Season
+	var regions []Region
+	var selectedRegion *Region
 	var ranks []*EloDict
+	var filter RankingFilter
+	_, canEdit := isLoggedIn(r)
 	if gameType == "" {
-		gameTypes = fetchGameTypes()
+		gameTypes = dataStore.FetchGameTypes()
 	} else {
 		var err error
-		selectedType, err = fetchGameTypeByURLPath(gameType)
+		selectedType, err = dataStore.FetchGameTypeByURLPath(gameType)
 		if err != nil {
 			http.Redirect(w, r, "/rankings", http.StatusTemporaryRedirect)
 			return
 		}
-		rankDict := rankPlayers(selectedType.ID)
+		seasons = sortedSeasons(selectedType.ID)
+		regions = dataStore.FetchRegions()
+		filter = rankingFilterFor(selectedType)
+		readRankingFilter(r, &filter)
 
-		ranks = make([]*EloDict, len(rankDict))
-		idx := 0
-		for _, v := range rankDict {
-			ranks[idx] = v
-			idx++
+		if seasonID := vars["season"]; seasonID != "" {
+			selectedSeason, err = dataStore.FetchSeason(seasonID)
+			if err != nil || selectedSeason.GameType != selectedType.ID {
+				http.Redirect(w, r, "/rankings/"+selectedType.URLPath, http.StatusTemporaryRedirect)
+				return
+			}
+			ranks, err = fetchSeasonRankings(selectedType, selectedSeason, filter)
+		} else if regionPath := vars["region"]; regionPath != "" {
+			selectedRegion, err = dataStore.FetchRegionByURLPath(regionPath)
+			if err != nil {
+				http.Redirect(w, r, "/rankings/"+selectedType.URLPath, http.StatusTemporaryRedirect)
+				return
+			}
+			ranks, err = fetchRegionRankings(selectedType, selectedRegion, filter)
+		} else {
+			ranks, err = fetchRankings(selectedType, filter)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-
-		sort.Sort(ByRank(ranks))
-
 	}
 	data := struct {
 		Ranks            []*EloDict
 		GameTypes        []GameType
 		SelectedGameType *GameType
+		Seasons          []*Season
+		SelectedSeason   *Season
+		Regions          []Region
+		SelectedRegion   *Region
+		Filter           RankingFilter
+		CanEdit          bool
 	}{
 		ranks,
 		gameTypes,
 		selectedType,
+		seasons,
+		selectedSeason,
+		regions,
+		selectedRegion,
+		filter,
+		canEdit,
 	}
 	renderTemplate(w, r, "rankings", data)
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rating is a player's current rating for one game type. Ratings are updated
+// as matches are added, so rankings don't have to replay every match.
+// Mean is the unrounded rating for engines that need the precision,
+// Deviation is used by Glicko-2 and TrueSkill, and Volatility by Glicko-2.
+type Rating struct {
+	ID         string    `gorethink:"id"`
+	Player     string    `gorethink:"player"`
+	GameType   string    `gorethink:"gametype"`
+	Rating     int       `gorethink:"rating"`
+	Mean       float64   `gorethink:"mean"`
+	Deviation  float64   `gorethink:"deviation"`
+	Volatility float64   `gorethink:"volatility"`
+	Matches    int       `gorethink:"matches"`
+	LastMatch  time.Time `gorethink:"last_match"`
+}
+
+// RatingChange records how one match moved a player's rating. Matches is the
+// player's rated match count after the change, which orders the history even
+// when matches share a date.
+type RatingChange struct {
+	ID       string    `gorethink:"id,omitempty"`
+	Player   string    `gorethink:"player"`
+	GameType string    `gorethink:"gametype"`
+	Match    string    `gorethink:"match"`
+	Opponent string    `gorethink:"opponent"`
+	Before   int       `gorethink:"before"`
+	After    int       `gorethink:"after"`
+	Matches  int       `gorethink:"matches"`
+	Date     time.Time `gorethink:"date"`
+}
+
+type ByRatingMatches []*RatingChange
+
+func (a ByRatingMatches) Len() int           { return len(a) }
+func (a ByRatingMatches) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByRatingMatches) Less(i, j int) bool { return a[i].Matches < a[j].Matches }
+
+func ratingID(gameType string, player string) string {
+	return gameType + "_" + player
+}
+
+// RatingEngine is a rating system that game types can be ranked with.
+type RatingEngine interface {
+	// NewRating returns the rating a player starts with.
+	NewRating(gameType string, player string) *Rating
+
+	// Rate applies matches, oldest first, to ratings (keyed by player ID),
+	// adding anyone who isn't rated yet, and returns every change it made.
+	Rate(gameType string, ratings map[string]*Rating, matches []Match) []*RatingChange
+
+	// Incremental reports whether new matches can be rated on top of the
+	// stored ratings, or whether the whole game type has to be replayed.
+	Incremental() bool
+
+	// Predict returns how likely r1 is to beat r2. perGame is true when
+	// that's the chance of winning a single game, and false when it's the
+	// chance of winning a whole set.
+	Predict(r1 *Rating, r2 *Rating) (p float64, perGame bool)
+}
+
+// matchRules are the game type settings that decide what a match's result
+// is and how much it counts.
+type matchRules struct {
+	resultMode       string
+	tournamentWeight float64
+	casualWeight     float64
+	tierWeights      map[string]float64
+}
+
+var defaultMatchRules = matchRules{
+	resultMode:       resultModeGames,
+	tournamentWeight: 1,
+	casualWeight:     1,
+}
+
+// score returns player 1's result between 0 (a loss) and 1 (a win).
+func (mr matchRules) score(m Match) float64 {
+	total := m.Player1score + m.Player2score
+	switch {
+	case total == 0 || m.Player1score == m.Player2score:
+		return 0.5
+	case mr.resultMode == resultModeSets && m.Player1score > m.Player2score:
+		return 1
+	case mr.resultMode == resultModeSets:
+		return 0
+	}
+	return float64(m.Player1score) / float64(total)
+}
+
+// weight is how much a match counts. Tournament matches are also weighted by
+// their tournament's tier when the game type weights by tier.
+func (mr matchRules) weight(m Match) float64 {
+	if m.Tournament != "" {
+		if w, ok := mr.tierWeights[m.Tournament]; ok {
+			return mr.tournamentWeight * w
+		}
+		return mr.tournamentWeight
+	}
+	return mr.casualWeight
+}
+
+// ratingEngineFor returns the engine a game type is ranked with, set up with
+// its ranking settings. Elo is used unless another system has been picked.
+// It doesn't know the tier weights, so it's for creating and comparing
+// ratings; use matchRatingEngineFor to rate matches.
+func ratingEngineFor(gt *GameType) RatingEngine {
+	return newRatingEngine(gt, nil)
+}
+
+// matchRatingEngineFor is ratingEngineFor with the weights of the game type's
+// tournament tiers, when it weights by tier.
+func matchRatingEngineFor(gt *GameType) RatingEngine {
+	if gt == nil || !gt.WeightByTier {
+		return newRatingEngine(gt, nil)
+	}
+	return newRatingEngine(gt, tierWeights(gt))
+}
+
+func newRatingEngine(gt *GameType, tierWeights map[string]float64) RatingEngine {
+	if gt == nil {
+		return newElo(eloKFactor, eloStartRating, defaultMatchRules)
+	}
+	rules := matchRules{
+		resultMode:       gt.ResultMode,
+		tournamentWeight: gt.TournamentWeight,
+		casualWeight:     gt.CasualWeight,
+		tierWeights:      tierWeights,
+	}
+	switch gt.RatingSystem {
+	case ratingSystemGlicko2:
+		return newGlicko2(gt.StartRating, rules)
+	case ratingSystemTrueSkill:
+		return newTrueSkill(gt.StartRating, rules)
+	}
+	return newElo(gt.KFactor, gt.StartRating, rules)
+}
+
+func fetchRatingEngine(gameType string) RatingEngine {
+	gt, _ := dataStore.FetchGameType(gameType)
+	return ratingEngineFor(gt)
+}
+
+func fetchMatchRatingEngine(gameType string) RatingEngine {
+	gt, _ := dataStore.FetchGameType(gameType)
+	return matchRatingEngineFor(gt)
+}
+
+func newRatingChange(r *Rating, m Match, opponent string, before int) *RatingChange {
+	return &RatingChange{
+		Player:   r.Player,
+		GameType: r.GameType,
+		Match:    m.ID,
+		Opponent: opponent,
+		Before:   before,
+		After:    r.Rating,
+		Matches:  r.Matches,
+		Date:     m.Date,
+	}
+}
+
+// ratingsFor returns the ratings of each player, creating them with the
+// engine if they're not in ratings yet.
+func ratingsFor(engine RatingEngine, gameType string, ratings map[string]*Rating, players ...string) []*Rating {
+	rs := make([]*Rating, len(players))
+	for i, p := range players {
+		if _, ok := ratings[p]; !ok {
+			ratings[p] = engine.NewRating(gameType, p)
+		}
+		rs[i] = ratings[p]
+	}
+	return rs
+}
+
+// updateRatings applies newly added matches to the stored ratings. A match
+// that's older than either player's last rated match changes history, so its
+// game type is rebuilt from scratch instead, as are game types whose engine
+// can't rate incrementally.
+func updateRatings(matches []Match) {
+	sort.Sort(ByDate(matches))
+	engines := map[string]RatingEngine{}
+	rebuild := map[string]bool{}
+	for _, m := range matches {
+		if m.Hidden || rebuild[m.GameType] {
+			continue
+		}
+		engine, ok := engines[m.GameType]
+		if !ok {
+			engine = fetchMatchRatingEngine(m.GameType)
+			engines[m.GameType] = engine
+		}
+		if !engine.Incremental() {
+			rebuild[m.GameType] = true
+			continue
+		}
+
+		ratings := map[string]*Rating{}
+		for _, p := range []string{m.Player1, m.Player2} {
+			if r, err := dataStore.FetchRating(m.GameType, p); err == nil {
+				ratings[p] = r
+				if m.Date.Before(r.LastMatch) {
+					rebuild[m.GameType] = true
+				}
+			}
+		}
+		if rebuild[m.GameType] {
+			continue
+		}
+
+		changes := engine.Rate(m.GameType, ratings, []Match{m})
+		rs := []*Rating{}
+		for _, r := range ratings {
+			rs = append(rs, r)
+		}
+		if err := dataStore.SaveRatings(rs); err != nil {
+			fmt.Println(err)
+		}
+		if err := dataStore.AddRatingChanges(changes); err != nil {
+			fmt.Println(err)
+		}
+	}
+	for gameType := range rebuild {
+		rebuildRatings(gameType)
+	}
+}
+
+// rebuildRatings replaces the stored ratings for a game type with a full
+// replay of its matches. It's needed whenever a past match is edited, hidden,
+// merged or deleted.
+func rebuildRatings(gameType string) {
+	rankDict, changes := rankPlayers(gameType)
+	ratings := make([]*Rating, 0, len(rankDict))
+	for _, r := range rankDict {
+		ratings = append(ratings, r)
+	}
+	if err := dataStore.ReplaceRatings(gameType, ratings); err != nil {
+		fmt.Println(err)
+	}
+	if err := dataStore.ReplaceRatingChanges(gameType, changes); err != nil {
+		fmt.Println(err)
+	}
+	backfillUpsets(gameType, changes)
+}
+
+func rebuildAllRatings() {
+	for _, gt := range dataStore.FetchGameTypes() {
+		rebuildRatings(gt.ID)
+	}
+}
+
+// ratingMigrations are the schema migrations that add somewhere to store
+// ratings or their history. Matches played before them haven't been rated
+// into it, and pages only read the stored ratings, so every game type is
+// rebuilt once they've been applied. They're keyed by version, which never
+// changes once a migration is released.
+var ratingMigrations = map[int]bool{
+	4: true, // add ratings table
+	5: true, // add rating history
+}
+
+// backfillRatings rebuilds every game type's ratings if applied includes a
+// rating migration. The migrations are recorded before the rebuild starts, so
+// if it's interrupted it isn't retried on the next start; rebuilding from the
+// game type's edit page recovers.
+func backfillRatings(applied []Migration) {
+	for _, m := range applied {
+		if ratingMigrations[m.Version] {
+			fmt.Println("Rebuilding ratings")
+			rebuildAllRatings()
+			return
+		}
+	}
+}
+
+// RatingHistory is a player's rating timeline in one game type.
+type RatingHistory struct {
+	Player   string          `json:"player"`
+	GameType string          `json:"gametype"`
+	Start    int             `json:"start"`
+	Current  int             `json:"current"`
+	Peak     int             `json:"peak"`
+	PeakDate *time.Time      `json:"peakDate"`
+	Timeline []*RatingChange `json:"timeline"`
+}
+
+// fetchRatingHistory returns the player's rating history for a game type.
+// Ratings stored before history was recorded have no changes until the game
+// type is rebuilt, which the rating history migration does.
+func fetchRatingHistory(gameType string, player string) (*RatingHistory, error) {
+	changes, err := dataStore.FetchRatingChanges(gameType, player)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(ByRatingMatches(changes))
+
+	start := eloStartRating
+	if len(changes) > 0 {
+		start = changes[0].Before
+	}
+	h := &RatingHistory{
+		Player:   player,
+		GameType: gameType,
+		Start:    start,
+		Current:  start,
+		Peak:     start,
+		Timeline: changes,
+	}
+	for _, c := range changes {
+		h.Current = c.After
+		if c.After > h.Peak {
+			h.Peak = c.After
+			date := c.Date
+			h.PeakDate = &date
+		}
+	}
+	return h, nil
+}
+
+// RatingAt returns the player's rating as of t.
+func (h *RatingHistory) RatingAt(t time.Time) int {
+	rating := h.Start
+	for _, c := range h.Timeline {
+		if c.Date.After(t) {
+			break
+		}
+		rating = c.After
+	}
+	return rating
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRatingsBackfilledByMigration(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := &GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1, CasualWeight: 1}
+	gt.ID = dataStore.AddGameType(*gt)
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob"})
+
+	// Matches added before ratings were stored
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := dataStore.AddMatches([]*Match{
+		{GameType: gt.ID, Date: date, Player1: alice, Player2: bob, Player1score: 3, Player2score: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ranks, err := fetchRankings(gt, RankingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranks) != 0 {
+		t.Errorf("nothing's been rated yet, got %d ranks", len(ranks))
+	}
+	if ratings, _ := dataStore.FetchRatings(gt.ID); len(ratings) != 0 {
+		t.Errorf("reading the rankings shouldn't rate anyone, got %d ratings", len(ratings))
+	}
+
+	backfillRatings([]Migration{{3, "add compound indexes for head-to-heads, rankings and logins"}})
+	if ratings, _ := dataStore.FetchRatings(gt.ID); len(ratings) != 0 {
+		t.Errorf("only rating migrations should rebuild the ratings, got %d ratings", len(ratings))
+	}
+	// It's the version that counts, so rewording the migration doesn't matter
+	backfillRatings([]Migration{{4, "store ratings"}})
+	ranks, err = fetchRankings(gt, RankingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranks) != 2 || ranks[0].Player.ID != alice {
+		t.Errorf("the ratings migration should rate both players, Alice first, got %+v", ranks)
+	}
+}
+
+func TestRatingHistoryIsReadOnly(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := &GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1, CasualWeight: 1}
+	gt.ID = dataStore.AddGameType(*gt)
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob"})
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := dataStore.AddMatches([]*Match{
+		{GameType: gt.ID, Date: date, Player1: alice, Player2: bob, Player1score: 3, Player2score: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice was rated before history was recorded
+	stale := &Rating{ID: ratingID(gt.ID, alice), Player: alice, GameType: gt.ID, Rating: 1234, Matches: 1, LastMatch: date}
+	if err := dataStore.SaveRatings([]*Rating{stale}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := fetchRatingHistory(gt.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Timeline) != 0 {
+		t.Errorf("Alice has no recorded history yet, got %d changes", len(h.Timeline))
+	}
+	if r, _ := dataStore.FetchRating(gt.ID, alice); r.Rating != stale.Rating {
+		t.Errorf("reading the history shouldn't rebuild the ratings, got %d", r.Rating)
+	}
+
+	backfillRatings([]Migration{{5, "add rating history"}})
+	h, err = fetchRatingHistory(gt.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Timeline) != 1 || h.Current <= h.Start {
+		t.Errorf("the history migration should record Alice's win, got %+v", h)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/dancannon/gorethink.v2/types"
+)
+
+const (
+	regionScopePlayers     = "players"
+	regionScopeTournaments = "tournaments"
+	regionScopeBoth        = "both"
+
+	earthRadiusKm = 6371.0
+)
+
+// Region is an area that game types can be ranked in. It's either a polygon
+// or, if it has fewer than three points, a circle Radius kilometres around
+// Center.
+//
+// Scope decides what the region's rankings count: "players" ranks players
+// based in the region by their usual ratings, "tournaments" rates only the
+// matches played at tournaments in the region, and "both" does the latter but
+// only ranks players based in the region.
+type Region struct {
+	ID      string        `gorethink:"id,omitempty"`
+	Name    string        `gorethink:"name"`
+	URLPath string        `gorethink:"urlpath"`
+	Scope   string        `gorethink:"scope"`
+	Center  types.Point   `gorethink:"center"`
+	Radius  float64       `gorethink:"radius"`
+	Polygon []types.Point `gorethink:"polygon"`
+}
+
+type ByRegionName []Region
+
+func (a ByRegionName) Len() int           { return len(a) }
+func (a ByRegionName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByRegionName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// Contains reports whether a point is in the region. Players and tournaments
+// that were never geocoded are at 0,0 and aren't in any region.
+func (rg *Region) Contains(p types.Point) bool {
+	if p.Lat == 0 && p.Lon == 0 {
+		return false
+	}
+	if len(rg.Polygon) >= 3 {
+		return polygonContains(rg.Polygon, p)
+	}
+	return distanceKm(rg.Center, p) <= rg.Radius
+}
+
+// distanceKm is the great-circle distance between two points.
+func distanceKm(a types.Point, b types.Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// polygonContains casts a ray from the point and counts how many edges of
+// the polygon it crosses. Regions are small enough to treat as flat.
+func polygonContains(polygon []types.Point, p types.Point) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := range polygon {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// regionMatches returns the matches played at tournaments in the region.
+func regionMatches(rg *Region, matches []Match) []Match {
+	inRegion := map[string]bool{}
+	filtered := []Match{}
+	for _, m := range matches {
+		if m.Tournament == "" {
+			continue
+		}
+		in, ok := inRegion[m.Tournament]
+		if !ok {
+			t, err := dataStore.FetchTournament(m.Tournament)
+			in = err == nil && rg.Contains(t.Location)
+			inRegion[m.Tournament] = in
+		}
+		if in {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// fetchRegionRankings ranks a game type within a region, with the filter
+// applied.
+func fetchRegionRankings(gt *GameType, rg *Region, f RankingFilter) ([]*EloDict, error) {
+	var ratings []*Rating
+	var matches []Match
+	if rg.Scope == regionScopePlayers {
+		var err error
+		ratings, err = dataStore.FetchRatings(gt.ID)
+		if err != nil {
+			return nil, err
+		}
+		if f.needsMatches() {
+			matches = dataStore.FetchMatchesForGameType(gt.ID, false)
+		}
+	} else {
+		matches = regionMatches(rg, dataStore.FetchMatchesForGameType(gt.ID, false))
+		rated := make(map[string]*Rating)
+		matchRatingEngineFor(gt).Rate(gt.ID, rated, matches)
+		for _, r := range rated {
+			if r.Matches > 0 {
+				ratings = append(ratings, r)
+			}
+		}
+	}
+
+	if rg.Scope != regionScopeTournaments {
+		based := map[string]bool{}
+		for _, p := range dataStore.FetchPlayers() {
+			based[p.ID] = rg.Contains(p.Location)
+		}
+		local := []*Rating{}
+		for _, r := range ratings {
+			if based[r.Player] {
+				local = append(local, r)
+			}
+		}
+		ratings = local
+	}
+
+	ratings = f.apply(ratingEngineFor(gt), ratings, matches, time.Now())
+	return ranksFromRatings(gt, ratings), nil
+}
+
+// readRegionForm fills in a region from the add and edit forms. The polygon
+// is one "lat,lng" point per line.
+func readRegionForm(r *http.Request, rg *Region) error {
+	rg.Name = r.FormValue("name")
+	rg.URLPath = r.FormValue("urlpath")
+	if rg.Name == "" || rg.URLPath == "" {
+		return errors.New("a region needs a name and a URL path")
+	}
+
+	rg.Scope = r.FormValue("scope")
+	switch rg.Scope {
+	case regionScopePlayers, regionScopeTournaments, regionScopeBoth:
+	default:
+		rg.Scope = regionScopePlayers
+	}
+
+	rg.Center.Lat, _ = strconv.ParseFloat(r.FormValue("lat"), 64)
+	rg.Center.Lon, _ = strconv.ParseFloat(r.FormValue("lng"), 64)
+	rg.Radius, _ = strconv.ParseFloat(r.FormValue("radius"), 64)
+
+	rg.Polygon = []types.Point{}
+	for _, line := range strings.Split(r.FormValue("polygon"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		coords := strings.Split(line, ",")
+		if len(coords) != 2 {
+			return errors.New("polygon points must be lat,lng")
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(coords[0]), 64)
+		lng, err2 := strconv.ParseFloat(strings.TrimSpace(coords[1]), 64)
+		if err1 != nil || err2 != nil {
+			return errors.New("polygon points must be lat,lng")
+		}
+		rg.Polygon = append(rg.Polygon, types.Point{Lat: lat, Lon: lng})
+	}
+	if len(rg.Polygon) < 3 && rg.Radius <= 0 {
+		return errors.New("a region needs a radius or at least three polygon points")
+	}
+	return nil
+}
+
+// PolygonText is the polygon as it's entered in the edit form.
+func (rg *Region) PolygonText() string {
+	lines := make([]string, len(rg.Polygon))
+	for i, p := range rg.Polygon {
+		lines[i] = fmt.Sprintf("%g,%g", p.Lat, p.Lon)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func regionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, canEdit := isLoggedIn(r)
+	data := struct {
+		Regions []Region
+		CanEdit bool
+	}{
+		dataStore.FetchRegions(),
+		canEdit,
+	}
+	renderTemplate(w, r, "regions", data)
+}
+
+func addRegionHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Region *Region
+	}{
+		nil,
+	}
+	renderTemplate(w, r, "editRegion", data)
+}
+
+func saveRegionHandler(w http.ResponseWriter, r *http.Request) {
+	var rg Region
+	if err := readRegionForm(r, &rg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataStore.AddRegion(rg)
+	http.Redirect(w, r, "/regions", http.StatusFound)
+}
+
+func editRegionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rg, err := dataStore.FetchRegionByURLPath(vars["region"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Region *Region
+	}{
+		rg,
+	}
+	renderTemplate(w, r, "editRegion", data)
+}
+
+func saveEditRegionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rg, err := dataStore.FetchRegionByURLPath(vars["region"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := readRegionForm(r, rg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dataStore.UpdateRegion(rg)
+	if err != nil {
+		fmt.Println(err)
+	}
+	http.Redirect(w, r, "/regions", http.StatusFound)
+}
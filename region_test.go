@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gopkg.in/dancannon/gorethink.v2/types"
+)
+
+var (
+	saltLakeCity = types.Point{Lat: 40.76, Lon: -111.89}
+	sandy        = types.Point{Lat: 40.57, Lon: -111.86}
+	provo        = types.Point{Lat: 40.23, Lon: -111.66}
+	denver       = types.Point{Lat: 39.74, Lon: -104.99}
+)
+
+func TestDistanceKm(t *testing.T) {
+	tests := []struct {
+		a    types.Point
+		b    types.Point
+		want float64
+	}{
+		{saltLakeCity, saltLakeCity, 0},
+		{saltLakeCity, sandy, 21.3},
+		{saltLakeCity, denver, 596.3},
+		{denver, saltLakeCity, 596.3},
+	}
+	for _, test := range tests {
+		if got := distanceKm(test.a, test.b); math.Abs(got-test.want) > 0.1 {
+			t.Errorf("distanceKm(%v, %v) = %.1f, want %.1f", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestRegionContains(t *testing.T) {
+	circle := &Region{Center: saltLakeCity, Radius: 50}
+	// A square degree south of Salt Lake City, listed anticlockwise from the
+	// south west corner
+	polygon := &Region{Polygon: []types.Point{
+		{Lat: 40, Lon: -112}, {Lat: 40, Lon: -111}, {Lat: 41, Lon: -111}, {Lat: 41, Lon: -112},
+	}}
+	tests := []struct {
+		name   string
+		region *Region
+		p      types.Point
+		want   bool
+	}{
+		{"circle centre", circle, saltLakeCity, true},
+		{"circle inside", circle, sandy, true},
+		{"circle outside", circle, provo, false},
+		{"polygon inside", polygon, provo, true},
+		{"polygon outside", polygon, denver, false},
+		// Points on the south and west edges are inside and on the north and
+		// east edges outside, so neighbouring regions don't share them
+		{"polygon south edge", polygon, types.Point{Lat: 40, Lon: -111.5}, true},
+		{"polygon west edge", polygon, types.Point{Lat: 40.5, Lon: -112}, true},
+		{"polygon north edge", polygon, types.Point{Lat: 41, Lon: -111.5}, false},
+		{"polygon east edge", polygon, types.Point{Lat: 40.5, Lon: -111}, false},
+		// Nothing ungeocoded is in a region, even one around 0,0
+		{"ungeocoded", circle, types.Point{}, false},
+		{"ungeocoded at the centre", &Region{Radius: 1000}, types.Point{}, false},
+		{"ungeocoded in the polygon", &Region{Polygon: []types.Point{
+			{Lat: -1, Lon: -1}, {Lat: -1, Lon: 1}, {Lat: 1, Lon: 1}, {Lat: 1, Lon: -1},
+		}}, types.Point{}, false},
+	}
+	for _, test := range tests {
+		if got := test.region.Contains(test.p); got != test.want {
+			t.Errorf("%s: Contains(%v) = %v, want %v", test.name, test.p, got, test.want)
+		}
+	}
+}
+
+func TestRegionRankingScopes(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := &GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1, CasualWeight: 1}
+	gt.ID = dataStore.AddGameType(*gt)
+	// Carol was never geocoded
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice", Location: saltLakeCity})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob", Location: denver})
+	carol := dataStore.AddPlayer(Player{Nickname: "Carol"})
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	local := dataStore.AddTournament(Tournament{Name: "Local", GameType: gt.ID, DateStart: date, Location: sandy})
+	away := dataStore.AddTournament(Tournament{Name: "Away", GameType: gt.ID, DateStart: date, Location: denver})
+
+	// Bob loses both his matches in the region, but wins everything else
+	match := func(tournament string, winner string, loser string) *Match {
+		date = date.Add(time.Hour)
+		return &Match{GameType: gt.ID, Tournament: tournament, Date: date, Player1: winner, Player2: loser, Player1score: 2}
+	}
+	if err := dataStore.AddMatches([]*Match{
+		match(local, alice, bob),
+		match(local, carol, bob),
+		match(away, bob, alice),
+		match(away, bob, carol),
+		match(away, bob, carol),
+		match("", bob, alice),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rebuildRatings(gt.ID)
+
+	ranks := map[string]map[string]int{}
+	for _, scope := range []string{regionScopePlayers, regionScopeTournaments, regionScopeBoth} {
+		rg := &Region{Scope: scope, Center: saltLakeCity, Radius: 50}
+		ranked, err := fetchRegionRankings(gt, rg, RankingFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranks[scope] = map[string]int{}
+		for _, r := range ranked {
+			ranks[scope][r.Player.Nickname] = r.Rank
+		}
+	}
+
+	// Only Alice is based in the region, and she keeps her usual rating
+	stored, err := dataStore.FetchRating(gt.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranks[regionScopePlayers]) != 1 || ranks[regionScopePlayers]["Alice"] != stored.Rating {
+		t.Errorf("players: got %v, want only Alice at %d", ranks[regionScopePlayers], stored.Rating)
+	}
+
+	// Everyone who played in the region is ranked, on those matches alone
+	tournaments := ranks[regionScopeTournaments]
+	if len(tournaments) != 3 {
+		t.Errorf("tournaments: got %v, want everyone who played locally", tournaments)
+	}
+	if tournaments["Bob"] >= eloStartRating || tournaments["Carol"] <= eloStartRating {
+		t.Errorf("tournaments: only local matches should be rated, got %v", tournaments)
+	}
+	if tournaments["Alice"] == stored.Rating {
+		t.Errorf("tournaments: Alice's loss away shouldn't count, got %d", tournaments["Alice"])
+	}
+
+	// "both" ranks only Alice, on the local matches
+	if both := ranks[regionScopeBoth]; len(both) != 1 || both["Alice"] != tournaments["Alice"] {
+		t.Errorf("both: got %v, want only Alice at %d", both, tournaments["Alice"])
+	}
+}
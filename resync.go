@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dguenther/go-bracket"
+	"github.com/gorilla/mux"
+)
+
+// MatchChange is an imported match whose bracket match has changed since.
+type MatchChange struct {
+	Old Match
+	New *Match
+}
+
+// ResultChange is a result whose seed or placing has changed on the bracket.
+// Old is nil for players who didn't have a result yet.
+type ResultChange struct {
+	Old *TournamentResult
+	New *TournamentResult
+}
+
+// TournamentResync is what re-syncing a tournament with its bracket would
+// change.
+type TournamentResync struct {
+	Tournament *Tournament
+	Root       *Tournament
+	Added      []*Match
+	Changed    []MatchChange
+	Removed    []Match
+	Results    []ResultChange
+	// Unknown are bracket participants who couldn't be confidently matched to a
+	// player.
+	// Their matches are left alone.
+	Unknown []string
+
+	playerCount int
+	seeds       map[string]int
+}
+
+// Empty reports whether the tournament is already in sync.
+func (rs *TournamentResync) Empty() bool {
+	return len(rs.Added) == 0 && len(rs.Changed) == 0 && len(rs.Removed) == 0 && len(rs.Results) == 0
+}
+
+// Fingerprint identifies what the resync would change, so applying it can
+// check that the bracket hasn't changed since it was previewed.
+func (rs *TournamentResync) Fingerprint() string {
+	lines := []string{}
+	match := func(kind string, m *Match) {
+		lines = append(lines, fmt.Sprintf("%s %s %s %s %s %d %d %d", kind, m.ID, m.TournamentMatchID,
+			m.Player1, m.Player2, m.Player1score, m.Player2score, m.Round))
+	}
+	for _, m := range rs.Added {
+		match("added", m)
+	}
+	for _, c := range rs.Changed {
+		match("changed", c.New)
+	}
+	for i := range rs.Removed {
+		match("removed", &rs.Removed[i])
+	}
+	for _, c := range rs.Results {
+		lines = append(lines, fmt.Sprintf("result %s %d %d", c.New.Player, c.New.Place, c.New.Seed))
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// rootTournament follows a pool up to the tournament it's part of.
+func rootTournament(t *Tournament) (*Tournament, error) {
+	root := t
+	for root.PoolOf != "" {
+		var err error
+		root, err = dataStore.FetchTournament(root.PoolOf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// resyncTournament re-fetches a tournament's bracket and works out what's
+// changed since it was imported. Imported matches are matched up with the
+// bracket's by TournamentMatchID, and participants are matched up with players
+// through the matches they've already played, or by the importer's confident
+// suggestions. A participant whose imported matches name different players,
+// or whose name was imported as somebody else, is left unknown rather than
+// guessed at, since the bracket may have been corrected since.
+func resyncTournament(t *Tournament) (*TournamentResync, error) {
+	b := tournamentBracket(t)
+	if b == nil {
+		return nil, errors.New("couldn't fetch the bracket for " + t.Name)
+	}
+	root, err := rootTournament(t)
+	if err != nil {
+		return nil, err
+	}
+	rs := &TournamentResync{
+		Tournament:  t,
+		Root:        root,
+		Added:       []*Match{},
+		Changed:     []MatchChange{},
+		Removed:     []Match{},
+		Results:     []ResultChange{},
+		Unknown:     []string{},
+		playerCount: len(b.Players),
+		seeds:       map[string]int{},
+	}
+
+	imported := map[string]Match{}
+	for _, m := range dataStore.FetchMatchesForTournament(t.ID, true) {
+		if m.TournamentMatchID != "" {
+			imported[m.TournamentMatchID] = m
+		}
+	}
+
+	playedAs := map[string]map[string]bool{}
+	for _, bm := range b.Matches {
+		m, ok := imported[bm.ID]
+		if !ok {
+			continue
+		}
+		for participant, player := range map[string]string{bm.Player1ID: m.Player1, bm.Player2ID: m.Player2} {
+			if playedAs[participant] == nil {
+				playedAs[participant] = map[string]bool{}
+			}
+			playedAs[participant][player] = true
+		}
+	}
+	playerMap := map[string]string{}
+	matcher := newParticipantMatcher()
+	for _, p := range b.Players {
+		if players, ok := playedAs[p.ID]; ok {
+			if id, ok := importedAs(p, players); ok {
+				playerMap[p.ID] = id
+			} else {
+				rs.Unknown = append(rs.Unknown, p.Name)
+			}
+			continue
+		}
+		if pm := matcher.match(p); pm.Confident() {
+			playerMap[p.ID] = pm.Suggestion.ID
+		} else {
+			rs.Unknown = append(rs.Unknown, p.Name)
+		}
+	}
+	for _, p := range b.Players {
+		if id, ok := playerMap[p.ID]; ok {
+			rs.seeds[id] = p.Seed
+		}
+	}
+
+	synced := map[string]bool{}
+	for _, bm := range b.Matches {
+		if bm.State != "complete" {
+			continue
+		}
+		// Matches with an unknown player are left as they are
+		synced[bm.ID] = true
+		p1, ok1 := playerMap[bm.Player1ID]
+		p2, ok2 := playerMap[bm.Player2ID]
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		m := &Match{
+			Date:                       *bm.UpdatedAt,
+			GameType:                   t.GameType,
+			Tournament:                 t.ID,
+			TournamentMatchID:          bm.ID,
+			Player1:                    p1,
+			Player2:                    p2,
+			Player1PrevTournamentMatch: bm.Player1PrereqMatchID,
+			Player2PrevTournamentMatch: bm.Player2PrereqMatchID,
+			Player1score:               bm.Player1Score,
+			Player2score:               bm.Player2Score,
+			Round:                      bm.Round,
+		}
+		old, ok := imported[bm.ID]
+		if !ok {
+			rs.Added = append(rs.Added, m)
+			continue
+		}
+		if old.Player1 != m.Player1 || old.Player2 != m.Player2 || old.Player1score != m.Player1score ||
+			old.Player2score != m.Player2score || old.Round != m.Round {
+			// The bracket's update time is when the match was corrected,
+			// not when it was played
+			m.ID = old.ID
+			m.Date = old.Date
+			m.Hidden = old.Hidden
+			rs.Changed = append(rs.Changed, MatchChange{old, m})
+		}
+	}
+	for id, m := range imported {
+		if !synced[id] {
+			rs.Removed = append(rs.Removed, m)
+		}
+	}
+	sort.Sort(ByDate(rs.Removed))
+
+	results, _ := dataStore.FetchResultsForTournament(root.ID)
+	resultDict := map[string]*TournamentResult{}
+	for _, r := range results {
+		resultDict[r.Player] = r
+	}
+	for _, p := range b.Players {
+		id, ok := playerMap[p.ID]
+		if !ok {
+			continue
+		}
+		old, ok := resultDict[id]
+		if !ok {
+			tr := &TournamentResult{TournamentID: root.ID, Player: id}
+			// Only the final bracket has places and seeds
+			if t.PoolOf == "" {
+				tr.Place = p.Rank
+				tr.Seed = p.Seed
+			}
+			rs.Results = append(rs.Results, ResultChange{nil, tr})
+			continue
+		}
+		if t.PoolOf == "" && (old.Place != p.Rank || old.Seed != p.Seed) {
+			tr := *old
+			tr.Place = p.Rank
+			tr.Seed = p.Seed
+			rs.Results = append(rs.Results, ResultChange{old, &tr})
+		}
+	}
+	return rs, nil
+}
+
+// importedAs returns the player a participant was imported as, given the
+// players their imported matches name. It's only sure when the matches agree
+// with each other and with the players their name was recorded as.
+func importedAs(p *bracket.Player, players map[string]bool) (string, bool) {
+	if len(players) != 1 {
+		return "", false
+	}
+	var id string
+	for player := range players {
+		id = player
+	}
+	handles, err := dataStore.FetchParticipantHandles(normalizeHandle(p.Name))
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, h := range handles {
+		if h.Player == id {
+			return id, true
+		}
+	}
+	// Brackets imported before handles were recorded, or participants who've
+	// been renamed since, only have their matches to go on
+	return id, len(handles) == 0
+}
+
+// apply saves a resync and rebuilds the game type's ratings, since changed
+// matches can be anywhere in its history. The writes aren't transactional, so
+// the ratings are rebuilt even if one fails, to match what was written.
+func (rs *TournamentResync) apply() error {
+	err := rs.save()
+	updateTournamentStrength(rs.Root)
+	rebuildRatings(rs.Tournament.GameType)
+	return err
+}
+
+// save writes a resync's matches, results and player count.
+func (rs *TournamentResync) save() error {
+	updated := append([]*Match{}, rs.Added...)
+	for _, c := range rs.Changed {
+		updated = append(updated, c.New)
+	}
+	annotateUpsets(rs.Tournament, updated, rs.seeds)
+
+	if len(rs.Added) > 0 {
+		if err := dataStore.AddMatches(rs.Added); err != nil {
+			return err
+		}
+	}
+	for _, c := range rs.Changed {
+		if err := dataStore.UpdateMatch(c.New); err != nil {
+			return err
+		}
+	}
+	for _, m := range rs.Removed {
+		if err := dataStore.DeleteMatch(m.ID); err != nil {
+			return err
+		}
+	}
+
+	added := []*TournamentResult{}
+	for _, c := range rs.Results {
+		if c.Old == nil {
+			added = append(added, c.New)
+		} else if err := dataStore.UpdateTournamentResult(c.New); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		if err := dataStore.AddTournamentResults(added); err != nil {
+			return err
+		}
+	}
+
+	if rs.Tournament.PlayerCount != rs.playerCount {
+		rs.Tournament.PlayerCount = rs.playerCount
+		if err := dataStore.UpdateTournament(rs.Tournament); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resyncTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, err := dataStore.FetchTournament(vars["tournament"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if t.Editing {
+		http.Redirect(w, r, "/tournament/addmatches/"+t.ID, http.StatusFound)
+		return
+	}
+
+	rs, err := resyncTournament(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	playerMap := make(map[string]Player)
+	for _, p := range dataStore.FetchPlayers() {
+		playerMap[p.ID] = p
+	}
+
+	data := struct {
+		Resync    *TournamentResync
+		PlayerMap map[string]Player
+	}{
+		rs,
+		playerMap,
+	}
+	renderTemplate(w, r, "resyncTournament", data)
+}
+
+func saveResyncTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, err := dataStore.FetchTournament(vars["tournament"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rs, err := resyncTournament(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Only apply what was previewed
+	if r.FormValue("fingerprint") != rs.Fingerprint() {
+		http.Error(w, "The bracket has changed since the resync was previewed, so check it again before applying it",
+			http.StatusConflict)
+		return
+	}
+	if err := rs.apply(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
+}
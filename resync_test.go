@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+)
+
+func TestResyncKeepsDatesAndLeavesDisagreementsUnknown(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob"})
+	carol := dataStore.AddPlayer(Player{Nickname: "Carol"})
+	played := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	tournament := &Tournament{Name: "Weekly", GameType: "melee", DateStart: played}
+	tournament.ID = dataStore.AddTournament(*tournament)
+
+	// Carol's second match was imported with Alice in her place
+	imported := []*Match{
+		{Tournament: tournament.ID, TournamentMatchID: "1", Date: played, Player1: alice, Player2: bob, Player1score: 2},
+		{Tournament: tournament.ID, TournamentMatchID: "2", Date: played, Player1: alice, Player2: carol, Player1score: 2, Player2score: 1},
+		{Tournament: tournament.ID, TournamentMatchID: "3", Date: played, Player1: bob, Player2: alice, Player1score: 2},
+	}
+	if err := dataStore.AddMatches(imported); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first match's score has been corrected since
+	corrected := played.AddDate(0, 0, 2)
+	b := bracket.Bracket{
+		Players: []*bracket.Player{
+			{ID: "p1", Name: "Alice", Seed: 1},
+			{ID: "p2", Name: "Bob", Seed: 2},
+			{ID: "p3", Name: "Carol", Seed: 3},
+		},
+		Matches: []*bracket.Match{
+			{ID: "1", State: "complete", Player1ID: "p1", Player2ID: "p2", Player2Score: 2, UpdatedAt: &corrected},
+			{ID: "2", State: "complete", Player1ID: "p1", Player2ID: "p3", Player1Score: 2, Player2Score: 1, UpdatedAt: &played},
+			{ID: "3", State: "complete", Player1ID: "p2", Player2ID: "p3", Player1Score: 2, UpdatedAt: &played},
+		},
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.SaveUploadedBracket(&UploadedBracket{ID: tournament.ID, Data: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := resyncTournament(tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.Unknown) != 1 || rs.Unknown[0] != "Carol" {
+		t.Errorf("Carol's matches disagree, so she should be unknown, got %v", rs.Unknown)
+	}
+	if len(rs.Removed) != 0 {
+		t.Errorf("the matches of unknown participants should be left alone, got %d removed", len(rs.Removed))
+	}
+	if len(rs.Changed) != 1 {
+		t.Fatalf("got %d changed matches, want 1", len(rs.Changed))
+	}
+	if m := rs.Changed[0].New; m.Player2score != 2 || !m.Date.Equal(played) {
+		t.Errorf("the corrected match should keep the date it was played, got %v", m.Date)
+	}
+}
+
+func TestImportedAsChecksHandles(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	dataStore.SaveParticipantHandles([]*ParticipantHandle{
+		{ID: participantHandleID("mango", "mango-id"), Handle: "mango", Player: "mango-id"},
+	})
+	tests := []struct {
+		name    string
+		players []string
+		want    bool
+	}{
+		{"Mango", []string{"mango-id"}, true},
+		{"Mango", []string{"armada-id"}, false},
+		{"Mango", []string{"mango-id", "armada-id"}, false},
+		{"Hbox", []string{"hbox-id"}, true},
+	}
+	for _, test := range tests {
+		players := map[string]bool{}
+		for _, p := range test.players {
+			players[p] = true
+		}
+		if _, got := importedAs(&bracket.Player{Name: test.name}, players); got != test.want {
+			t.Errorf("importedAs(%s, %v) = %v, want %v", test.name, test.players, got, test.want)
+		}
+	}
+}
+
+func TestResyncAfterMergingPlayers(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob"})
+	duplicate := dataStore.AddPlayer(Player{Nickname: "Alice (2)"})
+	played := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	tournament := &Tournament{Name: "Weekly", GameType: "melee", DateStart: played}
+	tournament.ID = dataStore.AddTournament(*tournament)
+
+	// "Ally" was imported as a duplicate of Alice, and as Alice herself before
+	b := bracket.Bracket{
+		Players: []*bracket.Player{
+			{ID: "p1", Name: "Ally", Seed: 1},
+			{ID: "p2", Name: "Bob", Seed: 2},
+		},
+		Matches: []*bracket.Match{
+			{ID: "1", State: "complete", Player1ID: "p1", Player2ID: "p2", Player1Score: 2, UpdatedAt: &played},
+		},
+	}
+	earlier := played.AddDate(0, -1, 0)
+	dataStore.SaveParticipantHandles([]*ParticipantHandle{
+		{ID: participantHandleID("ally", alice), Handle: "ally", Player: alice, LastSeen: earlier},
+	})
+	recordParticipantHandles(b.Players, map[string]string{"p1": duplicate, "p2": bob})
+	if err := dataStore.AddMatches([]*Match{
+		{Tournament: tournament.ID, TournamentMatchID: "1", Date: played, Player1: duplicate, Player2: bob, Player1score: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.SaveUploadedBracket(&UploadedBracket{ID: tournament.ID, Data: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataStore.MergePlayers(alice, duplicate); err != nil {
+		t.Fatal(err)
+	}
+	handles, err := dataStore.FetchParticipantHandles("ally")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handles) != 1 || handles[0].Player != alice || handles[0].ID != participantHandleID("ally", alice) {
+		t.Fatalf("Ally's handles should collapse into one for Alice, got %+v", handles)
+	}
+	if handles[0].LastSeen.Before(played) {
+		t.Errorf("the collapsed handle should keep the latest sighting, got %v", handles[0].LastSeen)
+	}
+	if pm := newParticipantMatcher().match(b.Players[0]); pm.Suggestion == nil || pm.Suggestion.ID != alice || pm.Reason != "imported before" {
+		t.Errorf("Ally should still be suggested as Alice from the import, got %+v", pm)
+	}
+
+	rs, err := resyncTournament(tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.Unknown) != 0 {
+		t.Errorf("Ally's matches and handle both name Alice, got %v unknown", rs.Unknown)
+	}
+	if len(rs.Added) != 0 || len(rs.Changed) != 0 || len(rs.Removed) != 0 {
+		t.Errorf("Alice's match should already be in sync, got %+v", rs)
+	}
+}
+
+func TestResyncOnlyAppliesWhatWasPreviewed(t *testing.T) {
+	srv, client := testSite(t)
+	defer srv.Close()
+	gt := GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1}
+	gt.ID = dataStore.AddGameType(gt)
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice", URLPath: "alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob", URLPath: "bob"})
+	played := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	tournament := &Tournament{Name: "Weekly", GameType: gt.ID, DateStart: played}
+	tournament.ID = dataStore.AddTournament(*tournament)
+	if err := dataStore.AddMatches([]*Match{
+		{Tournament: tournament.ID, TournamentMatchID: "1", Date: played, Player1: alice, Player2: bob, Player1score: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(score1 int, score2 int) {
+		b := bracket.Bracket{
+			Players: []*bracket.Player{{ID: "p1", Name: "Alice", Seed: 1}, {ID: "p2", Name: "Bob", Seed: 2}},
+			Matches: []*bracket.Match{
+				{ID: "1", State: "complete", Player1ID: "p1", Player2ID: "p2", Player1Score: score1, Player2Score: score2, UpdatedAt: &played},
+			},
+		}
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dataStore.SaveUploadedBracket(&UploadedBracket{ID: tournament.ID, Data: string(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The preview shows Alice's win corrected to 2-1, then the bracket
+	// changes again before it's applied
+	upload(2, 1)
+	previewed, err := resyncTournament(tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(get(t, client, srv.URL+"/tournament/resync/"+tournament.ID), previewed.Fingerprint()) {
+		t.Fatalf("the preview should carry its fingerprint")
+	}
+	upload(1, 2)
+	resp, err := client.PostForm(srv.URL+"/save/tournament/resync/"+tournament.ID,
+		url.Values{"fingerprint": {previewed.Fingerprint()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("applying a stale preview should conflict, got %s", resp.Status)
+	}
+	if m := dataStore.FetchMatchesForTournament(tournament.ID, true)[0]; m.Player2score != 0 {
+		t.Errorf("a stale preview shouldn't be applied, got %d-%d", m.Player1score, m.Player2score)
+	}
+
+	current, err := resyncTournament(tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postForm(t, client, srv.URL+"/save/tournament/resync/"+tournament.ID,
+		url.Values{"fingerprint": {current.Fingerprint()}})
+	if m := dataStore.FetchMatchesForTournament(tournament.ID, true)[0]; m.Player1score != 1 || m.Player2score != 2 {
+		t.Errorf("the previewed resync should be applied, got %d-%d", m.Player1score, m.Player2score)
+	}
+}
+
+// failingDeletes is a datastore that can't delete matches.
+type failingDeletes struct {
+	*MemoryDataStore
+}
+
+func (ds failingDeletes) DeleteMatch(id string) error {
+	return errors.New("can't delete match " + id)
+}
+
+func TestResyncReportsFailedWrites(t *testing.T) {
+	srv, client := testSite(t)
+	defer srv.Close()
+	gt := GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1}
+	gt.ID = dataStore.AddGameType(gt)
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice", URLPath: "alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob", URLPath: "bob"})
+	played := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	tournament := &Tournament{Name: "Weekly", GameType: gt.ID, DateStart: played}
+	tournament.ID = dataStore.AddTournament(*tournament)
+
+	// The second match has been removed from the bracket and a third added
+	if err := dataStore.AddMatches([]*Match{
+		{Tournament: tournament.ID, GameType: gt.ID, TournamentMatchID: "1", Date: played, Player1: alice, Player2: bob, Player1score: 2},
+		{Tournament: tournament.ID, GameType: gt.ID, TournamentMatchID: "2", Date: played, Player1: bob, Player2: alice, Player1score: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b := bracket.Bracket{
+		Players: []*bracket.Player{{ID: "p1", Name: "Alice", Seed: 1}, {ID: "p2", Name: "Bob", Seed: 2}},
+		Matches: []*bracket.Match{
+			{ID: "1", State: "complete", Player1ID: "p1", Player2ID: "p2", Player1Score: 2, UpdatedAt: &played},
+			{ID: "3", State: "complete", Player1ID: "p1", Player2ID: "p2", Player1Score: 2, UpdatedAt: &played},
+		},
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.SaveUploadedBracket(&UploadedBracket{ID: tournament.ID, Data: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := resyncTournament(tournament)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.Added) != 1 || len(rs.Removed) != 1 {
+		t.Fatalf("got %d added and %d removed, want 1 of each", len(rs.Added), len(rs.Removed))
+	}
+	dataStore = failingDeletes{dataStore.(*MemoryDataStore)}
+	resp, err := client.PostForm(srv.URL+"/save/tournament/resync/"+tournament.ID,
+		url.Values{"fingerprint": {rs.Fingerprint()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("a failed resync should be reported, got %s", resp.Status)
+	}
+
+	// The added match was written before the delete failed, so it's rated
+	// along with the match that's left
+	r, err := dataStore.FetchRating(gt.ID, alice)
+	if err != nil {
+		t.Fatalf("the ratings should be rebuilt after a failed resync: %v", err)
+	}
+	if r.Matches != 3 {
+		t.Errorf("Alice's rating should count the 3 matches written, got %d", r.Matches)
+	}
+}
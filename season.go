@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Season is a date window that a game type is ranked over on its own. Both
+// dates are inclusive. CarryOver is how much of each player's rating from the
+// previous season is kept: 0 starts everyone fresh, 1 keeps it all, and
+// anything between regresses ratings toward the start rating.
+type Season struct {
+	ID        string    `gorethink:"id,omitempty"`
+	GameType  string    `gorethink:"gametype"`
+	Name      string    `gorethink:"name"`
+	DateStart time.Time `gorethink:"date_start"`
+	DateEnd   time.Time `gorethink:"date_end"`
+	CarryOver float64   `gorethink:"carry_over"`
+}
+
+type BySeasonStart []*Season
+
+func (a BySeasonStart) Len() int           { return len(a) }
+func (a BySeasonStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a BySeasonStart) Less(i, j int) bool { return a[i].DateStart.Before(a[j].DateStart) }
+
+const seasonDateFormat = "2006-01-02"
+
+// end returns the first moment after the season.
+func (s *Season) end() time.Time {
+	return s.DateEnd.AddDate(0, 0, 1)
+}
+
+// previousSeason returns the latest season of the same game type that ended
+// before s started, or nil if there isn't one.
+func previousSeason(s *Season, seasons []*Season) *Season {
+	var prev *Season
+	for _, other := range seasons {
+		if other.ID == s.ID || other.end().After(s.DateStart) {
+			continue
+		}
+		if prev == nil || other.DateEnd.After(prev.DateEnd) {
+			prev = other
+		}
+	}
+	return prev
+}
+
+// regressRating starts a player's season from their previous season's
+// rating, moved toward the engine's start rating by the season's carry-over.
+func regressRating(engine RatingEngine, prev *Rating, carryOver float64) *Rating {
+	r := engine.NewRating(prev.GameType, prev.Player)
+	if r.Mean != 0 {
+		r.Mean += carryOver * (prev.Mean - r.Mean)
+		r.Rating = round(r.Mean)
+	} else {
+		r.Rating = round(float64(r.Rating) + carryOver*float64(prev.Rating-r.Rating))
+	}
+	r.Deviation += carryOver * (prev.Deviation - r.Deviation)
+	return r
+}
+
+// rankSeason rates the matches played during a season. Previous seasons are
+// ranked first when the season carries ratings over.
+func rankSeason(gt *GameType, s *Season, seasons []*Season) map[string]*Rating {
+	engine := matchRatingEngineFor(gt)
+	ratings := make(map[string]*Rating)
+
+	if prev := previousSeason(s, seasons); prev != nil && s.CarryOver > 0 {
+		for id, r := range rankSeason(gt, prev, seasons) {
+			ratings[id] = regressRating(engine, r, s.CarryOver)
+		}
+	}
+
+	matches := dataStore.FetchMatchesForGameTypeBetween(gt.ID, s.DateStart, s.end(), false)
+	engine.Rate(gt.ID, ratings, matches)
+
+	for id, r := range ratings {
+		if r.Matches == 0 {
+			delete(ratings, id)
+		}
+	}
+	return ratings
+}
+
+// fetchSeasonRankings ranks a season with the filter applied as of the end of
+// the season, or now if it's still going.
+func fetchSeasonRankings(gt *GameType, s *Season, f RankingFilter) ([]*EloDict, error) {
+	seasons, err := dataStore.FetchSeasons(gt.ID)
+	if err != nil {
+		return nil, err
+	}
+	ratings := []*Rating{}
+	for _, r := range rankSeason(gt, s, seasons) {
+		ratings = append(ratings, r)
+	}
+
+	asOf := time.Now()
+	if s.end().Before(asOf) {
+		asOf = s.end()
+	}
+	var matches []Match
+	if f.needsMatches() {
+		matches = dataStore.FetchMatchesForGameTypeBetween(gt.ID, s.DateStart, s.end(), false)
+	}
+	ratings = f.apply(ratingEngineFor(gt), ratings, matches, asOf)
+	return ranksFromRatings(gt, ratings), nil
+}
+
+// readSeasonForm fills in a season from the add and edit forms.
+func readSeasonForm(r *http.Request, s *Season) error {
+	var err error
+	s.Name = r.FormValue("name")
+	s.DateStart, err = time.Parse(seasonDateFormat, r.FormValue("datestart"))
+	if err != nil {
+		return errors.New("start date must be YYYY-MM-DD")
+	}
+	s.DateEnd, err = time.Parse(seasonDateFormat, r.FormValue("dateend"))
+	if err != nil {
+		return errors.New("end date must be YYYY-MM-DD")
+	}
+	if s.DateEnd.Before(s.DateStart) {
+		return errors.New("the season has to end after it starts")
+	}
+	carryOver, _ := strconv.ParseFloat(r.FormValue("carryover"), 64)
+	s.CarryOver = clampCarryOver(carryOver)
+	return nil
+}
+
+func clampCarryOver(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+func addSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		GameType *GameType
+		Season   *Season
+	}{
+		gt,
+		nil,
+	}
+	renderTemplate(w, r, "editSeason", data)
+}
+
+func saveSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s := Season{GameType: gt.ID}
+	if err := readSeasonForm(r, &s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := dataStore.AddSeason(s)
+	http.Redirect(w, r, "/rankings/"+gt.URLPath+"/season/"+id, http.StatusFound)
+}
+
+func editSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s, err := dataStore.FetchSeason(vars["season"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	gt, err := dataStore.FetchGameType(s.GameType)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		GameType *GameType
+		Season   *Season
+	}{
+		gt,
+		s,
+	}
+	renderTemplate(w, r, "editSeason", data)
+}
+
+func saveEditSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s, err := dataStore.FetchSeason(vars["season"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	gt, err := dataStore.FetchGameType(s.GameType)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := readSeasonForm(r, s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dataStore.UpdateSeason(s)
+	if err != nil {
+		fmt.Println(err)
+	}
+	http.Redirect(w, r, "/rankings/"+gt.URLPath+"/season/"+s.ID, http.StatusFound)
+}
+
+// sortedSeasons returns a game type's seasons, newest first, for listing.
+func sortedSeasons(gameType string) []*Season {
+	seasons, err := dataStore.FetchSeasons(gameType)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	sort.Sort(sort.Reverse(BySeasonStart(seasons)))
+	return seasons
+}
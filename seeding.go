@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// seedingRecentMonths is how far back results count towards a player's
+	// form.
+	seedingRecentMonths = 6
+	// seedingRematchMonths is how recently two players must have met for a
+	// first round between them to be a rematch.
+	seedingRematchMonths = 3
+	// seedingFormWeight is how many rating points each placement round a
+	// player has recently finished above their seed is worth.
+	seedingFormWeight = 25
+)
+
+// Seeding is one entrant's place in a generated seeding.
+type Seeding struct {
+	Seed     int     `json:"seed"`
+	Entrant  string  `json:"entrant"`
+	Name     string  `json:"name"`
+	PlayerID string  `json:"player,omitempty"`
+	Rating   int     `json:"rating"`
+	Score    float64 `json:"score"`
+	// Opponent is the seed met in the first round, or 0 for a bye.
+	Opponent int `json:"opponent"`
+	// MovedFrom is the seed the entrant would have had if they weren't moved
+	// to avoid a clash.
+	MovedFrom int    `json:"movedFrom,omitempty"`
+	Clash     string `json:"clash,omitempty"`
+
+	regions map[string]bool
+}
+
+type ByScore []*Seeding
+
+func (a ByScore) Len() int      { return len(a) }
+func (a ByScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByScore) Less(i, j int) bool {
+	if a[i].Score != a[j].Score {
+		return a[i].Score > a[j].Score
+	}
+	return a[i].Name < a[j].Name
+}
+
+// readEntrants splits a list of entrants, one per line, dropping blanks.
+func readEntrants(s string) []string {
+	entrants := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			entrants = append(entrants, line)
+		}
+	}
+	return entrants
+}
+
+// findEntrants looks entrants up by ID, URL path, nickname or alias. Entrants
+// who aren't players yet map to nil.
+func findEntrants(entrants []string) []*Player {
+	lookup := map[string]*Player{}
+	players := dataStore.FetchPlayers()
+	for i := range players {
+		p := &players[i]
+		names := append([]string{p.URLPath, p.Nickname}, p.Aliases...)
+		for _, name := range names {
+			if name != "" {
+				lookup[strings.ToLower(name)] = p
+			}
+		}
+	}
+	for i := range players {
+		lookup[players[i].ID] = &players[i]
+	}
+
+	found := make([]*Player, len(entrants))
+	for i, e := range entrants {
+		if p, ok := lookup[e]; ok {
+			found[i] = p
+		} else {
+			found[i] = lookup[strings.ToLower(e)]
+		}
+	}
+	return found
+}
+
+// bracketSize is the smallest power of two that fits n entrants.
+func bracketSize(n int) int {
+	size := 1
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// generateSeeding seeds entrants for a bracket of a game type. Entrants are
+// ranked by rating plus recent form, then lower seeds are swapped with others
+// in the same placement round to keep recent opponents and players from the
+// same region apart in the first round where possible.
+func generateSeeding(gt *GameType, entrants []string) []*Seeding {
+	engine := ratingEngineFor(gt)
+	players := findEntrants(entrants)
+	regions := dataStore.FetchRegions()
+	now := time.Now()
+	recent := now.AddDate(0, -seedingRecentMonths, 0)
+
+	seeds := []*Seeding{}
+	seen := map[string]bool{}
+	seenNames := map[string]bool{}
+	for i, e := range entrants {
+		s := &Seeding{Entrant: e, Name: e, regions: map[string]bool{}}
+		p := players[i]
+		if p == nil {
+			// Entrants who aren't players yet can only be told apart by name
+			if seenNames[normalizeHandle(e)] {
+				continue
+			}
+			seenNames[normalizeHandle(e)] = true
+			s.Rating = engine.NewRating(gt.ID, "").Rating
+			s.Score = float64(s.Rating)
+			seeds = append(seeds, s)
+			continue
+		}
+		if seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+
+		s.Name = p.Nickname
+		s.PlayerID = p.ID
+		s.Rating = engine.NewRating(gt.ID, p.ID).Rating
+		if r, err := dataStore.FetchRating(gt.ID, p.ID); err == nil {
+			s.Rating = r.Rating
+		}
+		form := &SeedPerformanceSummary{}
+		results, _ := dataStore.FetchResultsForPlayer(p.ID)
+		for _, tr := range results {
+			if tr.Tournament.GameType == gt.ID && tr.Tournament.DateStart.After(recent) {
+				form.Add(tr)
+			}
+		}
+		s.Score = float64(s.Rating) + seedingFormWeight*form.Average()
+		for _, rg := range regions {
+			if rg.Contains(p.Location) {
+				s.regions[rg.ID] = true
+			}
+		}
+		seeds = append(seeds, s)
+	}
+	sort.Sort(ByScore(seeds))
+
+	played := map[string]bool{}
+	for _, m := range dataStore.FetchMatchesForGameTypeBetween(gt.ID, now.AddDate(0, -seedingRematchMonths, 0), now, false) {
+		played[m.Player1+m.Player2] = true
+		played[m.Player2+m.Player1] = true
+	}
+	clash := func(a *Seeding, b *Seeding) string {
+		if a.PlayerID == "" || b.PlayerID == "" {
+			return ""
+		}
+		if played[a.PlayerID+b.PlayerID] {
+			return "rematch"
+		}
+		for id := range a.regions {
+			if b.regions[id] {
+				return "same region"
+			}
+		}
+		return ""
+	}
+
+	for i, s := range seeds {
+		s.Seed = i + 1
+	}
+	size := bracketSize(len(seeds))
+	for i := range seeds {
+		j := size - 1 - i
+		if j <= i || j >= len(seeds) || clash(seeds[i], seeds[j]) == "" {
+			continue
+		}
+		for k := range seeds {
+			if k == j || placementRound(k+1) != placementRound(j+1) {
+				continue
+			}
+			if clash(seeds[i], seeds[k]) != "" {
+				continue
+			}
+			if opp := size - 1 - k; opp < len(seeds) && clash(seeds[opp], seeds[j]) != "" {
+				continue
+			}
+			seeds[j], seeds[k] = seeds[k], seeds[j]
+			break
+		}
+	}
+
+	for i, s := range seeds {
+		if s.Seed != i+1 {
+			s.MovedFrom = s.Seed
+		}
+		s.Seed = i + 1
+		s.Opponent, s.Clash = 0, ""
+		if j := size - 1 - i; j < len(seeds) {
+			s.Opponent = j + 1
+			s.Clash = clash(s, seeds[j])
+		}
+	}
+	return seeds
+}
+
+// writeSeedingCSV writes a seeding as seed,name rows for pasting into a
+// bracket site.
+func writeSeedingCSV(w http.ResponseWriter, seeds []*Seeding) {
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="seeding.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seed", "name"})
+	for _, s := range seeds {
+		cw.Write([]string{strconv.Itoa(s.Seed), s.Name})
+	}
+	cw.Flush()
+}
+
+func seedingHandler(w http.ResponseWriter, r *http.Request) {
+	var gt *GameType
+	entrants := readEntrants(r.FormValue("entrants"))
+	seeds := []*Seeding{}
+	if id := r.FormValue("gametype"); id != "" {
+		var err error
+		gt, err = dataStore.FetchGameType(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		seeds = generateSeeding(gt, entrants)
+	}
+
+	switch r.FormValue("format") {
+	case "csv":
+		writeSeedingCSV(w, seeds)
+		return
+	case "json":
+		writeAPIResponse(w, r, seeds)
+		return
+	}
+
+	q := r.URL.Query()
+	q.Set("format", "csv")
+	csvURL := template.URL("/seeding?" + q.Encode())
+	q.Set("format", "json")
+	jsonURL := template.URL("/seeding?" + q.Encode())
+
+	data := struct {
+		GameTypes []GameType
+		GameType  *GameType
+		Entrants  string
+		Seeds     []*Seeding
+		CSVURL    template.URL
+		JSONURL   template.URL
+	}{
+		dataStore.FetchGameTypes(),
+		gt,
+		strings.Join(entrants, "\n"),
+		seeds,
+		csvURL,
+		jsonURL,
+	}
+	renderTemplate(w, r, "seeding", data)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/dancannon/gorethink.v2/types"
+)
+
+func TestGenerateSeedingAvoidsClashes(t *testing.T) {
+	home := types.Point{Lat: 40.76, Lon: -111.89}
+	tests := []struct {
+		name string
+		n    int
+		// rematches are pairs of entrants who've played recently, and local
+		// entrants are all from the same region
+		rematches [][2]int
+		local     []int
+		// order is the entrant at each seed, opponents the seed they meet in
+		// the first round and movedFrom the seed they'd have had unmoved
+		order     []int
+		opponents []int
+		movedFrom []int
+		clashes   []string
+	}{
+		{
+			"no clashes", 8, nil, nil,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8},
+			[]int{8, 7, 6, 5, 4, 3, 2, 1},
+			[]int{0, 0, 0, 0, 0, 0, 0, 0},
+			[]string{"", "", "", "", "", "", "", ""},
+		},
+		{
+			// 7 and 8 finish in the same placement round, so they can swap
+			"rematch", 8, [][2]int{{1, 8}}, nil,
+			[]int{1, 2, 3, 4, 5, 6, 8, 7},
+			[]int{8, 7, 6, 5, 4, 3, 2, 1},
+			[]int{0, 0, 0, 0, 0, 0, 8, 7},
+			[]string{"", "", "", "", "", "", "", ""},
+		},
+		{
+			"same region", 8, nil, []int{3, 6},
+			[]int{1, 2, 3, 4, 6, 5, 7, 8},
+			[]int{8, 7, 6, 5, 4, 3, 2, 1},
+			[]int{0, 0, 0, 0, 6, 5, 0, 0},
+			[]string{"", "", "", "", "", "", "", ""},
+		},
+		{
+			// Swapping 7 and 8 would only give 2 a rematch instead
+			"unavoidable rematch", 8, [][2]int{{1, 8}, {2, 8}}, nil,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8},
+			[]int{8, 7, 6, 5, 4, 3, 2, 1},
+			[]int{0, 0, 0, 0, 0, 0, 0, 0},
+			[]string{"rematch", "", "", "", "", "", "", "rematch"},
+		},
+		{
+			// 3 finishes a placement round above 4, so they can't swap
+			"different placement rounds", 4, [][2]int{{1, 4}}, nil,
+			[]int{1, 2, 3, 4},
+			[]int{4, 3, 2, 1},
+			[]int{0, 0, 0, 0},
+			[]string{"rematch", "", "", "rematch"},
+		},
+		{
+			"byes", 5, nil, nil,
+			[]int{1, 2, 3, 4, 5},
+			[]int{0, 0, 0, 5, 4},
+			[]int{0, 0, 0, 0, 0},
+			[]string{"", "", "", "", ""},
+		},
+		{
+			// Swapping 5 and 6 keeps both pairs apart
+			"byes and two clashes", 6, [][2]int{{4, 5}}, []int{3, 6},
+			[]int{1, 2, 3, 4, 6, 5},
+			[]int{0, 0, 6, 5, 4, 3},
+			[]int{0, 0, 0, 0, 6, 5},
+			[]string{"", "", "", "", "", ""},
+		},
+	}
+	for _, test := range tests {
+		dataStore = newMemoryDataStore()
+		gt := GameType{Name: "Melee", URLPath: "melee"}
+		gt.ID = dataStore.AddGameType(gt)
+		dataStore.AddRegion(Region{Name: "Utah", Center: home, Radius: 50})
+
+		local := map[int]bool{}
+		for _, i := range test.local {
+			local[i] = true
+		}
+		entrants, ids := []string{}, map[string]int{}
+		players := make([]string, test.n+1)
+		for i := 1; i <= test.n; i++ {
+			p := Player{Nickname: "Player " + strconv.Itoa(i)}
+			if local[i] {
+				p.Location = home
+			}
+			players[i] = dataStore.AddPlayer(p)
+			ids[players[i]] = i
+			entrants = append(entrants, p.Nickname)
+			dataStore.SaveRatings([]*Rating{{
+				ID:       ratingID(gt.ID, players[i]),
+				Player:   players[i],
+				GameType: gt.ID,
+				Rating:   2000 - 10*i,
+			}})
+		}
+		for _, pair := range test.rematches {
+			dataStore.AddMatch(Match{
+				GameType:     gt.ID,
+				Date:         time.Now().AddDate(0, -1, 0),
+				Player1:      players[pair[0]],
+				Player2:      players[pair[1]],
+				Player1score: 2,
+			})
+		}
+
+		order, opponents, movedFrom, clashes := []int{}, []int{}, []int{}, []string{}
+		for i, s := range generateSeeding(&gt, entrants) {
+			if s.Seed != i+1 {
+				t.Errorf("%s: seed %d is listed at %d", test.name, s.Seed, i+1)
+			}
+			order = append(order, ids[s.PlayerID])
+			opponents = append(opponents, s.Opponent)
+			movedFrom = append(movedFrom, s.MovedFrom)
+			clashes = append(clashes, s.Clash)
+		}
+		if !reflect.DeepEqual(order, test.order) {
+			t.Errorf("%s: got seeding %v, want %v", test.name, order, test.order)
+		}
+		if !reflect.DeepEqual(opponents, test.opponents) {
+			t.Errorf("%s: got opponents %v, want %v", test.name, opponents, test.opponents)
+		}
+		if !reflect.DeepEqual(movedFrom, test.movedFrom) {
+			t.Errorf("%s: got moved from %v, want %v", test.name, movedFrom, test.movedFrom)
+		}
+		if !reflect.DeepEqual(clashes, test.clashes) {
+			t.Errorf("%s: got clashes %q, want %q", test.name, clashes, test.clashes)
+		}
+	}
+}
+
+func TestGenerateSeedingDedupesEntrants(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := GameType{Name: "Melee", URLPath: "melee"}
+	gt.ID = dataStore.AddGameType(gt)
+	dataStore.AddPlayer(Player{Nickname: "Mango", Aliases: []string{"C9 Mango"}})
+
+	entrants := []string{"Mango", "c9 mango", "New Guy", " new guy", "NEW GUY", "Someone Else"}
+	names := []string{}
+	for _, s := range generateSeeding(&gt, entrants) {
+		names = append(names, s.Name)
+	}
+	if want := []string{"Mango", "New Guy", "Someone Else"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// SQLiteSessionStore keeps session values in the sessions table. The cookie
+// only holds the signed session ID.
+type SQLiteSessionStore struct {
+	db      *sql.DB
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+func newSQLiteSessionStore(db *sql.DB, keyPairs ...[]byte) *SQLiteSessionStore {
+	return &SQLiteSessionStore{
+		db:     db,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+}
+
+func (s *SQLiteSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *SQLiteSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	err = s.load(session)
+	if err == nil {
+		session.IsNew = false
+	} else if err == sql.ErrNoRows {
+		err = nil
+	}
+	return session, err
+}
+
+func (s *SQLiteSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if _, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, session.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = newUUID()
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *SQLiteSessionStore) load(session *sessions.Session) error {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM sessions WHERE id = ? AND expires > ?`,
+		session.ID, time.Now().UTC()).Scan(&data)
+	if err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(session.Name(), data, &session.Values, s.Codecs...)
+}
+
+func (s *SQLiteSessionStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second).UTC()
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO sessions (id, data, expires) VALUES (?, ?, ?)`,
+		session.ID, encoded, expires)
+	return err
+}
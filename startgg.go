@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+)
+
+const (
+	startGGDefaultEndpoint = "https://api.start.gg/gql/alpha"
+	// start.gg limits how many objects a query can return, so sets are
+	// fetched a page at a time.
+	startGGSetsPerPage        = 32
+	startGGEntrantsPerPage    = 64
+	startGGPhaseGroupsPerPage = 64
+	// startGGSetCompleted is the state of finished sets and phase groups.
+	startGGSetCompleted = 3
+)
+
+// startGGURL matches event URLs, with an optional phase group on the end.
+// Old smash.gg URLs still work.
+var startGGURL = regexp.MustCompile(`^https?://(?:www\.)?(?:start|smash)\.gg/(tournament/[^/]+/event/[^/?#]+)(?:/brackets/(\d+)/(\d+))?`)
+
+// startGGProvider fetches brackets from start.gg's GraphQL API. An event's
+// bracket is its last phase, and the phase groups of its earlier phases are
+// its pools.
+type startGGProvider struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func newStartGGProvider(endpoint string, token string) *startGGProvider {
+	if endpoint == "" {
+		endpoint = startGGDefaultEndpoint
+	}
+	return &startGGProvider{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// startGGID is an ID from start.gg, which are numbers except for sets in
+// brackets that haven't started, which are strings.
+type startGGID string
+
+func (id *startGGID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*id = startGGID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = startGGID(n.String())
+	return nil
+}
+
+type startGGPageInfo struct {
+	TotalPages int `json:"totalPages"`
+}
+
+type startGGEntrant struct {
+	ID             startGGID `json:"id"`
+	Name           string    `json:"name"`
+	InitialSeedNum int       `json:"initialSeedNum"`
+}
+
+type startGGPhaseGroupNode struct {
+	ID                startGGID `json:"id"`
+	DisplayIdentifier string    `json:"displayIdentifier"`
+}
+
+type startGGPhaseGroups struct {
+	PageInfo startGGPageInfo         `json:"pageInfo"`
+	Nodes    []startGGPhaseGroupNode `json:"nodes"`
+}
+
+type startGGPhase struct {
+	ID          startGGID          `json:"id"`
+	Name        string             `json:"name"`
+	PhaseOrder  int                `json:"phaseOrder"`
+	PhaseGroups startGGPhaseGroups `json:"phaseGroups"`
+}
+
+type ByPhaseOrder []startGGPhase
+
+func (a ByPhaseOrder) Len() int           { return len(a) }
+func (a ByPhaseOrder) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByPhaseOrder) Less(i, j int) bool { return a[i].PhaseOrder < a[j].PhaseOrder }
+
+type startGGStanding struct {
+	Placement int            `json:"placement"`
+	Entrant   startGGEntrant `json:"entrant"`
+}
+
+type startGGEvent struct {
+	ID        startGGID         `json:"id"`
+	Name      string            `json:"name"`
+	State     string            `json:"state"`
+	StartAt   int64             `json:"startAt"`
+	Phases    []startGGPhase    `json:"phases"`
+	Standings []startGGStanding `json:"-"`
+}
+
+type startGGSet struct {
+	ID          startGGID `json:"id"`
+	Round       int       `json:"round"`
+	State       int       `json:"state"`
+	CompletedAt int64     `json:"completedAt"`
+	WinnerID    startGGID `json:"winnerId"`
+	Slots       []struct {
+		PrereqID   startGGID `json:"prereqId"`
+		PrereqType string    `json:"prereqType"`
+		Entrant    *struct {
+			ID startGGID `json:"id"`
+		} `json:"entrant"`
+		Standing *struct {
+			Stats struct {
+				Score struct {
+					Value *float64 `json:"value"`
+				} `json:"score"`
+			} `json:"stats"`
+		} `json:"standing"`
+	} `json:"slots"`
+}
+
+type startGGSeed struct {
+	SeedNum int            `json:"seedNum"`
+	Entrant startGGEntrant `json:"entrant"`
+}
+
+type startGGPhaseGroup struct {
+	State int
+	Seeds []startGGSeed
+	Sets  []startGGSet
+}
+
+const startGGEventQuery = `query Event($slug: String, $page: Int!, $perPage: Int!, $groupsPerPage: Int!) {
+  event(slug: $slug) {
+    id name state startAt
+    phases {
+      id name phaseOrder
+      phaseGroups(query: {page: 1, perPage: $groupsPerPage}) {
+        pageInfo { totalPages }
+        nodes { id displayIdentifier }
+      }
+    }
+    standings(query: {page: $page, perPage: $perPage}) {
+      pageInfo { totalPages }
+      nodes { placement entrant { id name initialSeedNum } }
+    }
+  }
+}`
+
+const startGGPhaseQuery = `query Phase($id: ID!, $page: Int!, $perPage: Int!) {
+  phase(id: $id) {
+    phaseGroups(query: {page: $page, perPage: $perPage}) {
+      pageInfo { totalPages }
+      nodes { id displayIdentifier }
+    }
+  }
+}`
+
+const startGGPhaseGroupQuery = `query PhaseGroup($id: ID!, $page: Int!, $perPage: Int!, $setsPerPage: Int!) {
+  phaseGroup(id: $id) {
+    state
+    seeds(query: {page: $page, perPage: $perPage}) {
+      pageInfo { totalPages }
+      nodes { seedNum entrant { id name } }
+    }
+    sets(page: $page, perPage: $setsPerPage, sortType: STANDARD) {
+      pageInfo { totalPages }
+      nodes {
+        id round state completedAt winnerId
+        slots {
+          prereqId prereqType
+          entrant { id }
+          standing { stats { score { value } } }
+        }
+      }
+    }
+  }
+}`
+
+func (p *startGGProvider) Handles(url string) bool {
+	return startGGURL.MatchString(url)
+}
+
+// query runs a GraphQL query and decodes its data into out.
+func (p *startGGProvider) query(q string, vars map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": q, "variables": vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("start.gg returned %s", resp.Status)
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return errors.New("start.gg: " + result.Errors[0].Message)
+	}
+	return json.Unmarshal(result.Data, out)
+}
+
+// fetchEvent fetches an event by its slug, with all its standings and every
+// phase's phase groups.
+func (p *startGGProvider) fetchEvent(slug string) (*startGGEvent, error) {
+	var event *startGGEvent
+	for page, pages := 1, 1; page <= pages; page++ {
+		var data struct {
+			Event *struct {
+				startGGEvent
+				StandingsPage struct {
+					PageInfo startGGPageInfo   `json:"pageInfo"`
+					Nodes    []startGGStanding `json:"nodes"`
+				} `json:"standings"`
+			} `json:"event"`
+		}
+		vars := map[string]interface{}{
+			"slug":          slug,
+			"page":          page,
+			"perPage":       startGGEntrantsPerPage,
+			"groupsPerPage": startGGPhaseGroupsPerPage,
+		}
+		if err := p.query(startGGEventQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if data.Event == nil {
+			return nil, errors.New("no start.gg event at " + slug)
+		}
+		if event == nil {
+			event = &data.Event.startGGEvent
+		}
+		event.Standings = append(event.Standings, data.Event.StandingsPage.Nodes...)
+		pages = data.Event.StandingsPage.PageInfo.TotalPages
+	}
+
+	// The event only has the first page of each phase's groups
+	for i := range event.Phases {
+		phase := &event.Phases[i]
+		for page := 2; page <= phase.PhaseGroups.PageInfo.TotalPages; page++ {
+			groups, err := p.fetchPhaseGroups(string(phase.ID), page)
+			if err != nil {
+				return nil, err
+			}
+			phase.PhaseGroups.Nodes = append(phase.PhaseGroups.Nodes, groups.Nodes...)
+		}
+	}
+	sort.Sort(ByPhaseOrder(event.Phases))
+	return event, nil
+}
+
+// fetchPhaseGroups fetches a page of a phase's phase groups.
+func (p *startGGProvider) fetchPhaseGroups(id string, page int) (*startGGPhaseGroups, error) {
+	var data struct {
+		Phase *struct {
+			PhaseGroups startGGPhaseGroups `json:"phaseGroups"`
+		} `json:"phase"`
+	}
+	vars := map[string]interface{}{"id": id, "page": page, "perPage": startGGPhaseGroupsPerPage}
+	if err := p.query(startGGPhaseQuery, vars, &data); err != nil {
+		return nil, err
+	}
+	if data.Phase == nil {
+		return nil, errors.New("no start.gg phase with ID " + id)
+	}
+	return &data.Phase.PhaseGroups, nil
+}
+
+// fetchPhaseGroup fetches all of a phase group's seeds and sets.
+func (p *startGGProvider) fetchPhaseGroup(id string) (*startGGPhaseGroup, error) {
+	pg := &startGGPhaseGroup{}
+	for page, pages := 1, 1; page <= pages; page++ {
+		var data struct {
+			PhaseGroup *struct {
+				State int `json:"state"`
+				Seeds struct {
+					PageInfo startGGPageInfo `json:"pageInfo"`
+					Nodes    []startGGSeed   `json:"nodes"`
+				} `json:"seeds"`
+				Sets struct {
+					PageInfo startGGPageInfo `json:"pageInfo"`
+					Nodes    []startGGSet    `json:"nodes"`
+				} `json:"sets"`
+			} `json:"phaseGroup"`
+		}
+		vars := map[string]interface{}{
+			"id":          id,
+			"page":        page,
+			"perPage":     startGGEntrantsPerPage,
+			"setsPerPage": startGGSetsPerPage,
+		}
+		if err := p.query(startGGPhaseGroupQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if data.PhaseGroup == nil {
+			return nil, errors.New("no start.gg phase group with ID " + id)
+		}
+		pg.State = data.PhaseGroup.State
+		pg.Seeds = append(pg.Seeds, data.PhaseGroup.Seeds.Nodes...)
+		pg.Sets = append(pg.Sets, data.PhaseGroup.Sets.Nodes...)
+
+		pages = data.PhaseGroup.Sets.PageInfo.TotalPages
+		if data.PhaseGroup.Seeds.PageInfo.TotalPages > pages {
+			pages = data.PhaseGroup.Seeds.PageInfo.TotalPages
+		}
+	}
+	return pg, nil
+}
+
+// FetchBracket fetches an event's bracket, or one phase group of it if the URL
+// points at one. Only the event's bracket has placings.
+func (p *startGGProvider) FetchBracket(url string) (*bracket.Bracket, error) {
+	parts := startGGURL.FindStringSubmatch(url)
+	if parts == nil {
+		return nil, errors.New("not a start.gg event URL: " + url)
+	}
+	event, err := p.fetchEvent(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(event.Phases) == 0 {
+		return nil, errors.New("start.gg event " + event.Name + " has no brackets")
+	}
+
+	start := time.Unix(event.StartAt, 0).UTC()
+	updated := start
+	b := &bracket.Bracket{
+		State:     "underway",
+		StartedAt: &start,
+		UpdatedAt: &updated,
+		Players:   []*bracket.Player{},
+		Matches:   []*bracket.Match{},
+	}
+
+	groups := []string{parts[3]}
+	if parts[3] == "" {
+		if event.State == "COMPLETED" {
+			b.State = "complete"
+		}
+		final := event.Phases[len(event.Phases)-1]
+		groups = []string{}
+		for _, g := range final.PhaseGroups.Nodes {
+			groups = append(groups, string(g.ID))
+		}
+		for _, s := range event.Standings {
+			b.Players = append(b.Players, &bracket.Player{
+				ID:   string(s.Entrant.ID),
+				Name: s.Entrant.Name,
+				Seed: s.Entrant.InitialSeedNum,
+				Rank: s.Placement,
+			})
+		}
+	}
+
+	for _, id := range groups {
+		pg, err := p.fetchPhaseGroup(id)
+		if err != nil {
+			return nil, err
+		}
+		if parts[3] != "" {
+			if pg.State == startGGSetCompleted {
+				b.State = "complete"
+			}
+			for _, s := range pg.Seeds {
+				b.Players = append(b.Players, &bracket.Player{
+					ID:   string(s.Entrant.ID),
+					Name: s.Entrant.Name,
+					Seed: s.SeedNum,
+				})
+			}
+		}
+		for _, s := range pg.Sets {
+			if m := startGGMatch(s); m != nil {
+				b.Matches = append(b.Matches, m)
+				if m.UpdatedAt.After(updated) {
+					updated = *m.UpdatedAt
+				}
+			}
+		}
+	}
+	return b, nil
+}
+
+// startGGMatch turns a set into a match, or nil if it's a bye.
+func startGGMatch(s startGGSet) *bracket.Match {
+	if len(s.Slots) != 2 || s.Slots[0].Entrant == nil || s.Slots[1].Entrant == nil {
+		return nil
+	}
+
+	m := &bracket.Match{
+		ID:        string(s.ID),
+		State:     "open",
+		Round:     s.Round,
+		Player1ID: string(s.Slots[0].Entrant.ID),
+		Player2ID: string(s.Slots[1].Entrant.ID),
+	}
+	if s.State == startGGSetCompleted {
+		m.State = "complete"
+	}
+	completed := time.Unix(s.CompletedAt, 0).UTC()
+	m.UpdatedAt = &completed
+
+	scores := [2]int{}
+	for i, slot := range s.Slots {
+		if slot.PrereqType == "set" && slot.PrereqID != "" {
+			id := string(slot.PrereqID)
+			if i == 0 {
+				m.Player1PrereqMatchID = &id
+			} else {
+				m.Player2PrereqMatchID = &id
+			}
+		}
+		// DQs are scored -1
+		if slot.Standing != nil && slot.Standing.Stats.Score.Value != nil && *slot.Standing.Stats.Score.Value > 0 {
+			scores[i] = int(*slot.Standing.Stats.Score.Value)
+		}
+	}
+	// Sets reported without a score just have a winner
+	if scores[0] == scores[1] && s.WinnerID != "" {
+		if s.WinnerID == s.Slots[0].Entrant.ID {
+			scores[0]++
+		} else if s.WinnerID == s.Slots[1].Entrant.ID {
+			scores[1]++
+		}
+	}
+	m.Player1Score, m.Player2Score = scores[0], scores[1]
+	return m
+}
+
+// FetchPools returns the phase groups of every phase before the event's last.
+func (p *startGGProvider) FetchPools(url string) ([]ExternalPool, error) {
+	parts := startGGURL.FindStringSubmatch(url)
+	if parts == nil || parts[3] != "" {
+		return nil, nil
+	}
+	event, err := p.fetchEvent(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	pools := []ExternalPool{}
+	for i := 0; i < len(event.Phases)-1; i++ {
+		phase := event.Phases[i]
+		for _, g := range phase.PhaseGroups.Nodes {
+			name := phase.Name
+			if len(phase.PhaseGroups.Nodes) > 1 {
+				name += " " + g.DisplayIdentifier
+			}
+			pools = append(pools, ExternalPool{
+				Name: name,
+				URL:  fmt.Sprintf("https://www.start.gg/%s/brackets/%s/%s", parts[1], phase.ID, g.ID),
+			})
+		}
+	}
+	return pools, nil
+}
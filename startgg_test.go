@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+)
+
+const startGGTestEvent = "https://www.start.gg/tournament/genesis-4/event/melee-singles"
+
+// startGGTestServer answers start.gg queries with the responses recorded in
+// testdata/startgg, by query and page.
+func startGGTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := fmt.Sprintf("event-%v.json", req.Variables["page"])
+		switch req.Query {
+		case startGGPhaseQuery:
+			name = fmt.Sprintf("phase-%v-%v.json", req.Variables["id"], req.Variables["page"])
+		case startGGPhaseGroupQuery:
+			name = fmt.Sprintf("phasegroup-%v-%v.json", req.Variables["id"], req.Variables["page"])
+		}
+		body, err := ioutil.ReadFile(filepath.Join("testdata", "startgg", name))
+		if err != nil {
+			t.Error(err)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+}
+
+func findBracketMatch(b *bracket.Bracket, id string) *bracket.Match {
+	for _, m := range b.Matches {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestStartGGFetchEventBracket(t *testing.T) {
+	srv := startGGTestServer(t)
+	defer srv.Close()
+
+	b, err := newStartGGProvider(srv.URL, "").FetchBracket(startGGTestEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.State != "complete" {
+		t.Errorf("the event is completed, got state %q", b.State)
+	}
+	if want := time.Unix(1483833600, 0).UTC(); !b.StartedAt.Equal(want) {
+		t.Errorf("started at %v, want %v", b.StartedAt, want)
+	}
+	if want := time.Unix(1483858800, 0).UTC(); !b.UpdatedAt.Equal(want) {
+		t.Errorf("updated at %v, want the last set's completion %v", b.UpdatedAt, want)
+	}
+
+	// Both pages of standings are placed, seeded by their initial seeds
+	want := map[string][2]int{"11": {1, 2}, "12": {2, 1}, "13": {3, 3}}
+	if len(b.Players) != len(want) {
+		t.Fatalf("got %d players, want %d", len(b.Players), len(want))
+	}
+	for _, p := range b.Players {
+		if w := want[p.ID]; p.Seed != w[0] || p.Rank != w[1] {
+			t.Errorf("%s is seeded %d and placed %d, want %d and %d", p.Name, p.Seed, p.Rank, w[0], w[1])
+		}
+	}
+
+	// The bye is left out and the second page of sets is read
+	if len(b.Matches) != 2 {
+		t.Fatalf("got %d matches, want 2", len(b.Matches))
+	}
+	dq := findBracketMatch(b, "50001")
+	if dq == nil || dq.Player1Score != 3 || dq.Player2Score != 0 {
+		t.Errorf("a DQ scores 0, got %+v", dq)
+	}
+	final := findBracketMatch(b, "50002")
+	if final == nil {
+		t.Fatal("the second page's set is missing")
+	}
+	if final.Player1Score != 1 || final.Player2Score != 0 {
+		t.Errorf("a set with only a winner scores 1-0, got %d-%d", final.Player1Score, final.Player2Score)
+	}
+	if final.Player1PrereqMatchID != nil {
+		t.Errorf("player 1 came from a seed, not set %s", *final.Player1PrereqMatchID)
+	}
+	if final.Player2PrereqMatchID == nil || *final.Player2PrereqMatchID != "50001" {
+		t.Errorf("player 2 came from set 50001, got %v", final.Player2PrereqMatchID)
+	}
+}
+
+func TestStartGGFetchPhaseGroup(t *testing.T) {
+	srv := startGGTestServer(t)
+	defer srv.Close()
+
+	b, err := newStartGGProvider(srv.URL, "").FetchBracket(startGGTestEvent + "/brackets/100/1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.State != "complete" {
+		t.Errorf("the phase group is completed, got state %q", b.State)
+	}
+
+	// Both pages of seeds are read, and a phase group has no placings
+	want := map[string]int{"11": 1, "13": 2, "12": 3}
+	if len(b.Players) != len(want) {
+		t.Fatalf("got %d players, want %d", len(b.Players), len(want))
+	}
+	for _, p := range b.Players {
+		if p.Seed != want[p.ID] || p.Rank != 0 {
+			t.Errorf("%s is seeded %d and placed %d, want %d and unplaced", p.Name, p.Seed, p.Rank, want[p.ID])
+		}
+	}
+	if len(b.Matches) != 1 || b.Matches[0].ID != "40001" {
+		t.Fatalf("only the phase group's set should be fetched, got %d matches", len(b.Matches))
+	}
+	if m := b.Matches[0]; m.Player1Score != 2 || m.Player2Score != 1 {
+		t.Errorf("got a score of %d-%d, want 2-1", m.Player1Score, m.Player2Score)
+	}
+}
+
+func TestStartGGPoolsBecomePoolTournaments(t *testing.T) {
+	srv := startGGTestServer(t)
+	defer srv.Close()
+	dataStore = newMemoryDataStore()
+	siteConfiguration = &Configuration{StartGGEndpoint: srv.URL}
+
+	pools, err := newStartGGProvider(srv.URL, "").FetchPools(startGGTestEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the phase before the last is pools, and B1 is on the second page
+	// of its phase groups
+	wantPools := []ExternalPool{
+		{"Pools A1", "https://www.start.gg/tournament/genesis-4/event/melee-singles/brackets/100/1001"},
+		{"Pools A2", "https://www.start.gg/tournament/genesis-4/event/melee-singles/brackets/100/1002"},
+		{"Pools B1", "https://www.start.gg/tournament/genesis-4/event/melee-singles/brackets/100/1003"},
+	}
+	if len(pools) != len(wantPools) {
+		t.Fatalf("got pools %+v, want %+v", pools, wantPools)
+	}
+	for i, p := range pools {
+		if p != wantPools[i] {
+			t.Errorf("got pool %+v, want %+v", p, wantPools[i])
+		}
+	}
+
+	root := &Tournament{Name: "Genesis 4", GameType: "melee", BracketURL: startGGTestEvent}
+	root.ID = dataStore.AddTournament(*root)
+	addExternalPools(root)
+	children, err := dataStore.FetchTournamentPools(root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != len(wantPools) {
+		t.Fatalf("got %d pool tournaments, want %d", len(children), len(wantPools))
+	}
+	for _, want := range wantPools {
+		pool, err := dataStore.FetchTournamentByBracketURL(want.URL)
+		if err != nil {
+			t.Errorf("no pool tournament for %s", want.URL)
+			continue
+		}
+		if pool.PoolOf != root.ID || pool.Name != "Genesis 4 - "+want.Name || !pool.Editing {
+			t.Errorf("got pool tournament %+v", pool)
+		}
+	}
+
+	// Adding the pools again doesn't duplicate them
+	addExternalPools(root)
+	if children, _ := dataStore.FetchTournamentPools(root.ID); len(children) != len(wantPools) {
+		t.Errorf("got %d pool tournaments after adding them again", len(children))
+	}
+}
+
+func TestAddTournamentReportsBracketErrors(t *testing.T) {
+	srv, client := testSite(t)
+	defer srv.Close()
+	// start.gg turns requests without an API key away
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	siteConfiguration.StartGGEndpoint = unauthorized.URL
+
+	root := dataStore.AddTournament(Tournament{Name: "Genesis 4", GameType: "melee"})
+	tests := []struct {
+		path string
+		form url.Values
+	}{
+		{"/save/addtournament", url.Values{"name": {"Genesis 4"}, "url": {startGGTestEvent}, "gametype": {"melee"}}},
+		{"/save/addpool", url.Values{"name": {"Pools"}, "url": {startGGTestEvent}, "poolOf": {root}}},
+	}
+	for _, test := range tests {
+		resp, err := client.PostForm(srv.URL+test.path, test.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("POST %s: got %s, want %d", test.path, resp.Status, http.StatusBadGateway)
+		}
+	}
+	if _, err := dataStore.FetchTournamentByBracketURL(startGGTestEvent); err == nil {
+		t.Errorf("no tournament should be added for a bracket that couldn't be fetched")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tournamentStrengthTop is how many of the highest-rated attendees a
+// tournament's strength is averaged over, so a few unrated locals don't drag
+// down a stacked bracket.
+const tournamentStrengthTop = 8
+
+// TournamentTier is a band of tournament strength. A tournament gets the first
+// tier, strongest first, whose MinStrength it reaches. When a game type or
+// circuit weights by tier, results from the tier count Weight times as much.
+type TournamentTier struct {
+	Name        string  `gorethink:"name" json:"name"`
+	MinStrength int     `gorethink:"min_strength" json:"minStrength"`
+	Weight      float64 `gorethink:"weight" json:"weight"`
+}
+
+type ByMinStrength []TournamentTier
+
+func (a ByMinStrength) Len() int           { return len(a) }
+func (a ByMinStrength) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByMinStrength) Less(i, j int) bool { return a[i].MinStrength > a[j].MinStrength }
+
+// tierFor returns the name of the tier a strength falls in, or "" if it's
+// below every tier.
+func tierFor(tiers []TournamentTier, strength float64) string {
+	for _, tier := range tiers {
+		if strength >= float64(tier.MinStrength) {
+			return tier.Name
+		}
+	}
+	return ""
+}
+
+// tierWeight returns how much results from a tier count. Tournaments without
+// a tier count once.
+func tierWeight(tiers []TournamentTier, name string) float64 {
+	for _, tier := range tiers {
+		if tier.Name == name && name != "" {
+			return tier.Weight
+		}
+	}
+	return 1
+}
+
+// ratingBefore is a player's rating as it was when a tournament started.
+func ratingBefore(engine RatingEngine, t *Tournament, player string) int {
+	if h, err := fetchRatingHistory(t.GameType, player); err == nil && len(h.Timeline) > 0 {
+		return h.RatingAt(t.DateStart.Add(-time.Nanosecond))
+	}
+	return engine.NewRating(t.GameType, player).Rating
+}
+
+// tournamentStrength averages the ratings of a tournament's strongest
+// attendees as they were when it started.
+func tournamentStrength(t *Tournament) float64 {
+	results, err := dataStore.FetchResultsForTournament(t.ID)
+	if err != nil || len(results) == 0 {
+		return 0
+	}
+
+	engine := fetchRatingEngine(t.GameType)
+	ratings := []int{}
+	for _, r := range results {
+		ratings = append(ratings, ratingBefore(engine, t, r.Player))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ratings)))
+	if len(ratings) > tournamentStrengthTop {
+		ratings = ratings[:tournamentStrengthTop]
+	}
+
+	var sum int
+	for _, rating := range ratings {
+		sum += rating
+	}
+	return float64(sum) / float64(len(ratings))
+}
+
+// updateTournamentStrength works out a tournament's strength and tier and
+// saves them. Pools take the tier of the tournament they're part of.
+func updateTournamentStrength(t *Tournament) {
+	if t.PoolOf != "" {
+		return
+	}
+	gt, err := dataStore.FetchGameType(t.GameType)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	t.Strength = tournamentStrength(t)
+	t.Tier = tierFor(gt.Tiers, t.Strength)
+	if err := dataStore.UpdateTournament(t); err != nil {
+		fmt.Println(err)
+	}
+	tierPools(t)
+}
+
+// tierPools gives a tournament's pools, and theirs, the tournament's tier.
+func tierPools(t *Tournament) {
+	pools, err := dataStore.FetchTournamentPools(t.ID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, p := range pools {
+		if p.Tier != t.Tier {
+			p.Tier = t.Tier
+			if err := dataStore.UpdateTournament(p); err != nil {
+				fmt.Println(err)
+			}
+		}
+		tierPools(p)
+	}
+}
+
+// updateTournamentStrengths works out the strength and tier of every one of a
+// game type's tournaments, then rebuilds its ratings, for tournaments added
+// before strengths and upsets were tracked.
+func updateTournamentStrengths(gt *GameType) {
+	ts, err := dataStore.FetchTournaments(gt.ID, false)
+	if err != nil {
+		return
+	}
+	// Strengths use the ratings from before each tournament, so they can
+	// all be worked out before the ratings are rebuilt with the new tiers.
+	// Rebuilding also fills in the upsets of matches imported before they
+	// were tracked.
+	for i := range *ts {
+		updateTournamentStrength(&(*ts)[i])
+	}
+	rebuildRatings(gt.ID)
+}
+
+// retierTournaments reassigns the tiers of a game type's tournaments after
+// its tier thresholds change.
+func retierTournaments(gt *GameType) {
+	ts, err := dataStore.FetchTournaments(gt.ID, true)
+	if err != nil {
+		return
+	}
+	for i := range *ts {
+		t := &(*ts)[i]
+		if tier := tierFor(gt.Tiers, t.Strength); tier != t.Tier {
+			t.Tier = tier
+			if err := dataStore.UpdateTournament(t); err != nil {
+				fmt.Println(err)
+			}
+			tierPools(t)
+		}
+	}
+}
+
+// tierWeights maps each of a game type's tournaments, pools included, to the
+// weight of its tier.
+func tierWeights(gt *GameType) map[string]float64 {
+	weights := map[string]float64{}
+	tiers, err := dataStore.FetchTournamentTiers(gt.ID)
+	if err != nil {
+		return weights
+	}
+	for id, tier := range tiers {
+		weights[id] = tierWeight(gt.Tiers, tier)
+	}
+	return weights
+}
+
+// readTiers parses tiers from the game type forms, one "name=strength" or
+// "name=strength,weight" per line.
+func readTiers(s string) ([]TournamentTier, error) {
+	tiers := []TournamentTier{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New("tiers must be name=strength or name=strength,weight")
+		}
+		tier := TournamentTier{Name: strings.TrimSpace(parts[0]), Weight: 1}
+		values := strings.Split(parts[1], ",")
+		var err error
+		tier.MinStrength, err = strconv.Atoi(strings.TrimSpace(values[0]))
+		if err != nil {
+			return nil, errors.New("tiers must be name=strength or name=strength,weight")
+		}
+		if len(values) > 1 {
+			tier.Weight, err = strconv.ParseFloat(strings.TrimSpace(values[1]), 64)
+			if err != nil || tier.Weight < 0 {
+				return nil, errors.New("tier weights must be 0 or more")
+			}
+		}
+		tiers = append(tiers, tier)
+	}
+	sort.Sort(ByMinStrength(tiers))
+	return tiers, nil
+}
+
+// TiersText is the tiers as they're entered in the game type forms.
+func (gt *GameType) TiersText() string {
+	lines := make([]string, len(gt.Tiers))
+	for i, tier := range gt.Tiers {
+		lines[i] = fmt.Sprintf("%s=%d,%g", tier.Name, tier.MinStrength, tier.Weight)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestTierWeightsReachNestedPools(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := &GameType{Tiers: []TournamentTier{{Name: "S", MinStrength: 1300, Weight: 2}}, WeightByTier: true}
+	gt.ID = dataStore.AddGameType(*gt)
+	rootID := dataStore.AddTournament(Tournament{GameType: gt.ID, Tier: "S"})
+	poolID := dataStore.AddTournament(Tournament{GameType: gt.ID, PoolOf: rootID})
+	nestedID := dataStore.AddTournament(Tournament{GameType: gt.ID, PoolOf: poolID})
+
+	root, err := dataStore.FetchTournament(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tierPools(root)
+	weights := tierWeights(gt)
+	for _, id := range []string{rootID, poolID, nestedID} {
+		if weights[id] != 2 {
+			t.Errorf("tournament %s should be weighted 2, got %v", id, weights[id])
+		}
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/dguenther/go-bracket"
 	"github.com/gorilla/mux"
 	"github.com/jasonwinn/geocoder"
-	r "gopkg.in/dancannon/gorethink.v2"
 	"gopkg.in/dancannon/gorethink.v2/types"
 )
 
@@ -27,151 +26,41 @@ type Tournament struct {
 	Location    types.Point `gorethink:"location,omitempty"`
 	PlayerCount int         `gorethink:"player_count"`
 	Editing     bool        `gorethink:"editing"`
+	Strength    float64     `gorethink:"strength"`
+	Tier        string      `gorethink:"tier"`
 }
 
 const initialLastID string = "0"
 
-func getTournamentTable() r.Term {
-	return r.Table("tournaments")
-}
+type ByDateStart []Tournament
 
-func updateTournamentEditing(id string, editing bool) {
-	_, err := getTournamentTable().Get(id).Update(map[string]interface{}{
-		"editing": editing,
-	}).RunWrite(dataStore.GetSession())
+func (a ByDateStart) Len() int           { return len(a) }
+func (a ByDateStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByDateStart) Less(i, j int) bool { return a[i].DateStart.Before(a[j].DateStart) }
 
-	if err != nil {
-		fmt.Println(err)
-	}
-}
+type ByTournamentName []*Tournament
 
-func addTournament(t Tournament) string {
-	wr, err := getTournamentTable().Insert(&t).RunWrite(dataStore.GetSession())
-	if err != nil {
-		fmt.Println(err)
-	}
-	return wr.GeneratedKeys[0]
-}
+func (a ByTournamentName) Len() int           { return len(a) }
+func (a ByTournamentName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByTournamentName) Less(i, j int) bool { return a[i].Name < a[j].Name }
 
-func fetchTournament(id string) (*Tournament, error) {
-	c, err := getTournamentTable().Get(id).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	var t Tournament
-	err = c.One(&t)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return &t, nil
-}
-
-func fetchTournaments(gametype string, editing bool) (*[]Tournament, error) {
-	query := getTournamentTable()
-	filter := map[string]interface{}{
-		"pool_of": "",
-	}
-
-	if gametype != "" {
-		filter["gametype"] = gametype
-	}
-	if !editing {
-		filter["editing"] = false
-	}
-	c, err := query.Filter(filter).OrderBy(r.Desc("date_start")).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-
-	var t []Tournament
-	err = c.All(&t)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return &t, nil
-}
-
-func fetchTournamentPools(ID string) ([]*Tournament, error) {
-	query := getTournamentTable()
-	c, err := query.Filter(map[string]interface{}{
-		"pool_of": ID,
-	}).OrderBy("name").Run(dataStore.GetSession())
-	defer c.Close()
+func updateTournamentEditing(id string, editing bool) {
+	t, err := dataStore.FetchTournament(id)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
+		return
 	}
-
-	var t []*Tournament
-	err = c.All(&t)
+	t.Editing = editing
+	err = dataStore.UpdateTournament(t)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
-	}
-	return t, nil
-}
-
-func fetchTournamentsForPlayer(ID string) ([]*Tournament, error) {
-	c, err := getMatchTable().Filter(
-		r.Or(r.Row.Field("player1").Eq(ID), r.Row.Field("player2").Eq(ID)),
-	).EqJoin("tournament", getTournamentTable()).
-		Without("left").Field("right").Distinct().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	var t []*Tournament
-	err = c.All(&t)
-	if err != nil {
-		return nil, err
-	}
-	return t, nil
-}
-
-func fetchTournamentsForPlayers(player1 string, player2 string) ([]*Tournament, error) {
-	c, err := getMatchTable().Filter(
-		r.Or(
-			r.And(r.Row.Field("player1").Eq(player1), r.Row.Field("player2").Eq(player2)),
-			r.And(r.Row.Field("player1").Eq(player2), r.Row.Field("player2").Eq(player1)),
-		),
-	).EqJoin("tournament", getTournamentTable()).
-		Without("left").Field("right").Distinct().Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
-	matches := []*Tournament{}
-	err = c.All(&matches)
-	if err != nil {
-		return nil, err
 	}
-	return matches, nil
-}
-
-func deleteTournament(ID string) {
-	// Delete the tournament matches
-	getMatchTable().Filter(map[string]interface{}{
-		"tournament": ID,
-	}).Delete().RunWrite(dataStore.GetSession())
-	// Delete the tournament results
-	getTournamentResultTable().Filter(map[string]interface{}{
-		"tournament": ID,
-	}).Delete().RunWrite(dataStore.GetSession())
-	// Delete the tournament
-	getTournamentTable().Get(ID).Delete().RunWrite(dataStore.GetSession())
 }
 
 func addPoolHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -191,36 +80,31 @@ func savePoolHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	url := r.FormValue("url")
 
-	c, err := getTournamentTable().Filter(map[string]interface{}{
-		"bracket_url": url,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-
 	// if we find a tournament with the same bracket url,
 	// redirect to that tournament
-	var t *Tournament
-	if !c.IsNil() {
-		c.One(&t)
+	if t, err := dataStore.FetchTournamentByBracketURL(url); err == nil {
 		http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
 		return
 	}
 
-	t, err = fetchTournament(poolOf)
+	t, err := dataStore.FetchTournament(poolOf)
 	if err != nil {
 		http.Error(w, "No tournament with ID "+poolOf+" found", http.StatusBadRequest)
 		return
 	}
 
-	ct := fetchExternalBracket(url)
+	ct, status, err := startedBracket(url)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
 
-	id := addTournament(Tournament{
+	id := dataStore.AddTournament(Tournament{
 		Name:        name,
 		BracketURL:  url,
 		PoolOf:      poolOf,
 		GameType:    t.GameType,
+		Tier:        t.Tier,
 		City:        t.City,
 		State:       t.State,
 		Location:    t.Location,
@@ -234,7 +118,7 @@ func savePoolHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func addTournamentHandler(w http.ResponseWriter, r *http.Request) {
-	gameTypes := fetchGameTypes()
+	gameTypes := dataStore.FetchGameTypes()
 
 	data := struct {
 		GameTypes []GameType
@@ -249,12 +133,12 @@ func editTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	gameTypes := fetchGameTypes()
+	gameTypes := dataStore.FetchGameTypes()
 
 	data := struct {
 		Tournament *Tournament
@@ -272,19 +156,9 @@ func saveTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	gametype := r.FormValue("gametype")
 
-	c, err := getTournamentTable().Filter(map[string]interface{}{
-		"bracket_url": url,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		fmt.Println(err)
-	}
-
 	// if we find a tournament with the same bracket url,
 	// redirect to that tournament
-	if !c.IsNil() {
-		var t Tournament
-		c.One(&t)
+	if t, err := dataStore.FetchTournamentByBracketURL(url); err == nil {
 		http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
 		return
 	}
@@ -301,9 +175,13 @@ func saveTournamentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	t := fetchExternalBracket(url)
+	t, status, err := startedBracket(url)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
 
-	id := addTournament(Tournament{
+	id := dataStore.AddTournament(Tournament{
 		Name:        name,
 		BracketURL:  url,
 		GameType:    gametype,
@@ -315,6 +193,9 @@ func saveTournamentHandler(w http.ResponseWriter, r *http.Request) {
 		Location:    point,
 		Editing:     true,
 	})
+	if created, err := dataStore.FetchTournament(id); err == nil {
+		addExternalPools(created)
+	}
 
 	http.Redirect(w, r, "/tournament/"+id, http.StatusFound)
 }
@@ -323,7 +204,7 @@ func saveEditTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -345,19 +226,16 @@ func saveEditTournamentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	wr, err := getTournamentTable().Get(tournamentID).Update(map[string]interface{}{
-		"name":     r.FormValue("name"),
-		"gametype": r.FormValue("gametype"),
-		"city":     city,
-		"state":    state,
-		"location": point,
-	}).RunWrite(dataStore.GetSession())
+	t.Name = r.FormValue("name")
+	t.GameType = r.FormValue("gametype")
+	t.City = city
+	t.State = state
+	t.Location = point
+
+	err = dataStore.UpdateTournament(t)
 	if err != nil {
 		fmt.Println(err)
 	}
-	if wr.Errors > 0 {
-		fmt.Println(wr.FirstError)
-	}
 	http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
 }
 
@@ -365,20 +243,33 @@ func addTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	ct := fetchExternalBracket(t.BracketURL)
+	ct := tournamentBracket(t)
+	if ct == nil {
+		http.Error(w, "Couldn't fetch the bracket for "+t.Name, http.StatusInternalServerError)
+		return
+	}
+	participants := matchParticipants(ct.Players)
+	matched := 0
+	for _, p := range participants {
+		if p.Confident() {
+			matched++
+		}
+	}
 	data := struct {
 		Tournament   *Tournament
-		Participants []*bracket.Player
+		Participants []*ParticipantMatch
+		Matched      int
 		Complete     bool
 	}{
 		t,
-		ct.Players,
+		participants,
+		matched,
 		ct.State == "complete",
 	}
 	renderTemplate(w, r, "addTournamentMatch", data)
@@ -388,24 +279,12 @@ func saveTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Find the ID of the root tournament
-	var rootTournamentID string
-	if t.PoolOf == "" {
-		rootTournamentID = t.ID
-	} else {
-		ct, _ := fetchTournament(t.PoolOf)
-		for ct.PoolOf != "" {
-			ct, _ = fetchTournament(ct.PoolOf)
-		}
-		rootTournamentID = ct.ID
-	}
-
 	playerMap := make(map[string]string)
 	r.ParseForm()
 	for k, v := range r.PostForm {
@@ -424,10 +303,32 @@ func saveTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 		playerMap[split[1]] = playerID
 	}
 
-	b := fetchExternalBracket(t.BracketURL)
+	b := tournamentBracket(t)
+	if b == nil {
+		http.Error(w, "Couldn't fetch the bracket for "+t.Name, http.StatusInternalServerError)
+		return
+	}
+	recordParticipantHandles(b.Players, playerMap)
+	if err := importBracket(t, b, playerMap); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
+}
+
+// importBracket adds a bracket's completed matches to a tournament and results
+// for its participants to the root tournament, then rates the matches.
+// playerMap maps the bracket's participant IDs to players.
+func importBracket(t *Tournament, b *bracket.Bracket, playerMap map[string]string) error {
+	// Find the ID of the root tournament
+	root, err := rootTournament(t)
+	if err != nil {
+		return err
+	}
+	rootTournamentID := root.ID
 
 	// Add tournament results
-	oldResults, _ := fetchResultsForTournament(rootTournamentID)
+	oldResults, _ := dataStore.FetchResultsForTournament(rootTournamentID)
 	resultDict := map[string]*TournamentResult{}
 	for _, r := range oldResults {
 		resultDict[r.Player] = r
@@ -435,10 +336,17 @@ func saveTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 
 	newResults := []*TournamentResult{}
 	for _, p := range b.Players {
-		// If we've already saved a result for this player,
-		// don't save another one
+		// If we've already saved a result for this player, don't save
+		// another one, but the final bracket decides their place and seed
 		ourPlayerID := playerMap[p.ID]
-		if _, ok := resultDict[ourPlayerID]; ok {
+		if tr, ok := resultDict[ourPlayerID]; ok {
+			if t.PoolOf == "" && (tr.Place != p.Rank || tr.Seed != p.Seed) {
+				tr.Place = p.Rank
+				tr.Seed = p.Seed
+				if err := dataStore.UpdateTournamentResult(tr); err != nil {
+					fmt.Println(err)
+				}
+			}
 			continue
 		}
 
@@ -456,7 +364,7 @@ func saveTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		newResults = append(newResults, tr)
 	}
-	_, err = getTournamentResultTable().Insert(newResults).RunWrite(dataStore.GetSession())
+	err = dataStore.AddTournamentResults(newResults)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -483,19 +391,33 @@ func saveTournamentMatchesHandler(w http.ResponseWriter, r *http.Request) {
 			Round:                      m.Round,
 		})
 	}
-	_, err = getMatchTable().Insert(newMatches).RunWrite(dataStore.GetSession())
+	seeds := map[string]int{}
+	for _, p := range b.Players {
+		seeds[playerMap[p.ID]] = p.Seed
+	}
+	annotateUpsets(t, newMatches, seeds)
+	err = dataStore.AddMatches(newMatches)
 	if err != nil {
 		fmt.Println(err)
 	}
+	// The strength only looks at ratings from before the tournament, but
+	// it's needed before rating the matches for tier weighting.
+	updateTournamentStrength(root)
+	rated := make([]Match, len(newMatches))
+	for i, m := range newMatches {
+		rated[i] = *m
+	}
+	updateRatings(rated)
 	updateTournamentEditing(t.ID, false)
-	http.Redirect(w, r, "/tournament/"+t.ID, http.StatusFound)
+	placePoolPlayers(rootTournamentID)
+	return nil
 }
 
 func deleteTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -513,13 +435,17 @@ func saveDeleteTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	_, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	deleteTournament(tournamentID)
+	err = dataStore.DeleteTournament(tournamentID)
+	if err != nil {
+		fmt.Println(err)
+	}
+	rebuildRatings(t.GameType)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
@@ -527,14 +453,17 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tournamentID := vars["tournament"]
 
-	t, err := fetchTournament(tournamentID)
+	t, err := dataStore.FetchTournament(tournamentID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
+	nb, _ := dataStore.FetchNativeBracket(t.ID)
 	if t.Editing {
-		if _, ok := isLoggedIn(r); ok {
+		if nb != nil {
+			http.Redirect(w, r, "/bracket/"+t.ID, http.StatusFound)
+		} else if _, ok := isLoggedIn(r); ok {
 			http.Redirect(w, r, "/tournament/addmatches/"+t.ID, http.StatusFound)
 		} else {
 			http.NotFound(w, r)
@@ -544,8 +473,8 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, logged := isLoggedIn(r)
 
-	matches := fetchMatchesForTournament(t.ID, logged)
-	players := fetchPlayers()
+	matches := dataStore.FetchMatchesForTournament(t.ID, logged)
+	players := dataStore.FetchPlayers()
 	playerMap := make(map[string]Player)
 	for _, p := range players {
 		playerMap[p.ID] = p
@@ -553,12 +482,12 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 
 	var poolOf *Tournament
 	if t.PoolOf != "" {
-		poolOf, _ = fetchTournament(t.PoolOf)
+		poolOf, _ = dataStore.FetchTournament(t.PoolOf)
 	}
 
-	gametype, _ := fetchGameType(t.GameType)
-	pools, _ := fetchTournamentPools(t.ID)
-	results, _ := fetchResultsForTournament(t.ID)
+	gametype, _ := dataStore.FetchGameType(t.GameType)
+	pools, _ := dataStore.FetchTournamentPools(t.ID)
+	results, _ := dataStore.FetchResultsForTournament(t.ID)
 	// Sort the results into results that have a place and results that don't
 	placedResults := []*TournamentResult{}
 	unplacedResults := []*TournamentResult{}
@@ -569,6 +498,10 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 			placedResults = append(placedResults, r)
 		}
 	}
+	biggestUpsets := upsets(matches)
+	if len(biggestUpsets) > tournamentUpsetsShown {
+		biggestUpsets = biggestUpsets[:tournamentUpsetsShown]
+	}
 
 	data := struct {
 		Tournament      *Tournament
@@ -578,6 +511,10 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 		PlacedResults   []*TournamentResult
 		UnplacedResults []*TournamentResult
 		Matches         []Match
+		Upsets          []Match
+		SeedPerformance *SeedPerformanceSummary
+		NativeBracket   *NativeBracket
+		Brackets        []*BracketTree
 		PlayerMap       map[string]Player
 		IsLoggedIn      bool
 	}{
@@ -588,6 +525,10 @@ func viewTournamentHandler(w http.ResponseWriter, r *http.Request) {
 		placedResults,
 		unplacedResults,
 		matches,
+		biggestUpsets,
+		summarizeSeedPerformance(placedResults),
+		nb,
+		tournamentBrackets(t, matches, pools, playerMap, logged),
 		playerMap,
 		logged,
 	}
@@ -603,16 +544,16 @@ func viewTournamentsHandler(w http.ResponseWriter, r *http.Request) {
 	var selectedType *GameType
 	var t *[]Tournament
 	if gameType == "" {
-		gameTypes = fetchGameTypes()
+		gameTypes = dataStore.FetchGameTypes()
 	} else {
 		var err error
-		selectedType, err = fetchGameTypeByURLPath(gameType)
+		selectedType, err = dataStore.FetchGameTypeByURLPath(gameType)
 		if err != nil {
 			http.Redirect(w, r, "/tournaments", http.StatusTemporaryRedirect)
 			return
 		}
 		_, showInProgress := isLoggedIn(r)
-		t, _ = fetchTournaments(selectedType.ID, showInProgress)
+		t, _ = dataStore.FetchTournaments(selectedType.ID, showInProgress)
 	}
 
 	data := struct {
@@ -627,12 +568,3 @@ func viewTournamentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	renderTemplate(w, r, "tournaments", data)
 }
-
-func fetchExternalBracket(url string) *bracket.Bracket {
-	client := bracket.NewClient(siteConfiguration.ChallongeDevUsername, siteConfiguration.ChallongeApiKey)
-	bracket, err := client.FetchBracket(url)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return bracket
-}
@@ -3,11 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 
 	"github.com/gorilla/mux"
-	r "gopkg.in/dancannon/gorethink.v2"
 )
 
 type TournamentResult struct {
@@ -25,82 +23,83 @@ func (a ByPlace) Len() int           { return len(a) }
 func (a ByPlace) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByPlace) Less(i, j int) bool { return a[i].Place < a[j].Place }
 
-func getTournamentResultTable() r.Term {
-	return r.Table("tournamentresults")
+// ByTournamentDate sorts results by the start date of their tournament.
+// The Tournament field must be populated.
+type ByTournamentDate []*TournamentResult
+
+func (a ByTournamentDate) Len() int      { return len(a) }
+func (a ByTournamentDate) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByTournamentDate) Less(i, j int) bool {
+	return a[i].Tournament.DateStart.Before(a[j].Tournament.DateStart)
 }
 
-func fetchTournamentResult(resultID string) (*TournamentResult, error) {
-	c, err := getTournamentResultTable().Get(resultID).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
+// HasSeedPerformance reports whether the result has both a seed and a place
+// to compare.
+func (tr *TournamentResult) HasSeedPerformance() bool {
+	return tr.Seed > 0 && tr.Place > 0
+}
 
-	var result *TournamentResult
-	err = c.One(&result)
-	if err != nil {
-		return nil, err
+// SeedPerformance is how many placement rounds better than their seed the
+// player finished, or worse if it's negative.
+func (tr *TournamentResult) SeedPerformance() int {
+	if !tr.HasSeedPerformance() {
+		return 0
 	}
-	return result, nil
+	return placementRound(tr.Seed) - placementRound(tr.Place)
 }
 
-func fetchResultsForTournament(tournamentID string) ([]*TournamentResult, error) {
-	c, err := getTournamentResultTable().Filter(map[string]interface{}{
-		"tournament": tournamentID,
-	}).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
-	}
+// SeedPerformanceSummary adds up the seed performance of a set of results,
+// either one player's or one tournament's.
+type SeedPerformanceSummary struct {
+	Results int `json:"results"`
+	Total   int `json:"total"`
+	Over    int `json:"over"`
+	Under   int `json:"under"`
+}
 
-	var results []*TournamentResult
-	err = c.All(&results)
-	if err != nil {
-		return nil, err
+// Add counts a result, skipping ones without a seed or place.
+func (s *SeedPerformanceSummary) Add(tr *TournamentResult) {
+	if !tr.HasSeedPerformance() {
+		return
+	}
+	spr := tr.SeedPerformance()
+	s.Results++
+	s.Total += spr
+	if spr > 0 {
+		s.Over++
+	} else if spr < 0 {
+		s.Under++
 	}
-
-	sort.Sort(ByPlace(results))
-	return results, nil
 }
 
-func fetchResultsForPlayer(playerID string) ([]*TournamentResult, error) {
-	c, err := getTournamentResultTable().Filter(map[string]interface{}{
-		"player": playerID,
-	}).EqJoin("tournament", getTournamentTable()).
-		OrderBy(r.Desc(r.Row.Field("right").Field("date_start"))).Run(dataStore.GetSession())
-	defer c.Close()
-	if err != nil {
-		return nil, err
+// Average is the mean seed performance of the results.
+func (s *SeedPerformanceSummary) Average() float64 {
+	if s.Results == 0 {
+		return 0
 	}
+	return float64(s.Total) / float64(s.Results)
+}
 
-	type joinType struct {
-		Left  *TournamentResult
-		Right *Tournament
-	}
-	var result joinType
-	results := []*TournamentResult{}
-	for c.Next(&result) {
-		result.Left.Tournament = result.Right
-		results = append(results, result.Left)
+// summarizeSeedPerformance adds up the seed performance of some results.
+func summarizeSeedPerformance(results []*TournamentResult) *SeedPerformanceSummary {
+	s := &SeedPerformanceSummary{}
+	for _, tr := range results {
+		s.Add(tr)
 	}
-	if err != nil {
-		return nil, err
-	}
-
-	return results, nil
+	return s
 }
 
 func editTournamentResultHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	resultID := vars["result"]
-	result, err := fetchTournamentResult(resultID)
+	result, err := dataStore.FetchTournamentResult(resultID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	tournament, _ := fetchTournament(result.TournamentID)
-	player, _ := fetchPlayer(result.Player)
+	tournament, _ := dataStore.FetchTournament(result.TournamentID)
+	player, _ := dataStore.FetchPlayer(result.Player)
 
 	data := struct {
 		TournamentResult *TournamentResult
@@ -117,7 +116,7 @@ func editTournamentResultHandler(w http.ResponseWriter, r *http.Request) {
 func saveEditTournamentResultHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	resultID := vars["result"]
-	result, err := fetchTournamentResult(resultID)
+	result, err := dataStore.FetchTournamentResult(resultID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -126,15 +125,12 @@ func saveEditTournamentResultHandler(w http.ResponseWriter, r *http.Request) {
 	seed, _ := strconv.Atoi(r.FormValue("seed"))
 	place, _ := strconv.Atoi(r.FormValue("place"))
 
-	wr, err := getTournamentResultTable().Get(resultID).Update(map[string]interface{}{
-		"seed":      seed,
-		"placement": place,
-	}).RunWrite(dataStore.GetSession())
+	result.Seed = seed
+	result.Place = place
+
+	err = dataStore.UpdateTournamentResult(result)
 	if err != nil {
 		fmt.Println(err)
 	}
-	if wr.Errors > 0 {
-		fmt.Println(wr.FirstError)
-	}
 	http.Redirect(w, r, "/tournament/"+result.TournamentID, http.StatusFound)
 }
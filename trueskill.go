@@ -0,0 +1,135 @@
+package main
+
+import "math"
+
+// TrueSkill ratings for two sides, each of one or more players, without
+// draws. The defaults are the usual ones (mu 25, sigma 25/3) scaled up to
+// the same range as the other rating systems.
+const (
+	trueSkillMu    = 1500.0
+	trueSkillSigma = trueSkillMu / 3
+	trueSkillBeta  = trueSkillSigma / 2
+	trueSkillTau   = trueSkillSigma / 100
+)
+
+type TrueSkill struct {
+	mu    float64
+	sigma float64
+	beta  float64
+	tau   float64
+	rules matchRules
+}
+
+// newTrueSkill starts players at the given rating instead of the default
+// mu; the other parameters stay the same.
+func newTrueSkill(start int, rules matchRules) *TrueSkill {
+	mu := trueSkillMu
+	if start > 0 {
+		mu = float64(start)
+	}
+	return &TrueSkill{
+		mu:    mu,
+		sigma: trueSkillSigma,
+		beta:  trueSkillBeta,
+		tau:   trueSkillTau,
+		rules: rules,
+	}
+}
+
+func (ts *TrueSkill) NewRating(gameType string, player string) *Rating {
+	return &Rating{
+		ID:        ratingID(gameType, player),
+		Player:    player,
+		GameType:  gameType,
+		Rating:    round(ts.mu),
+		Mean:      ts.mu,
+		Deviation: ts.sigma,
+	}
+}
+
+func (ts *TrueSkill) Incremental() bool {
+	return true
+}
+
+// Rate treats each match as a win for whoever took more games, whatever the
+// game type's result mode. Tied matches aren't rated.
+func (ts *TrueSkill) Rate(gameType string, ratings map[string]*Rating, matches []Match) []*RatingChange {
+	changes := []*RatingChange{}
+	for _, m := range matches {
+		weight := ts.rules.weight(m)
+		if weight == 0 || m.Player1score == m.Player2score {
+			continue
+		}
+		rs := ratingsFor(ts, gameType, ratings, m.Player1, m.Player2)
+		r1, r2 := rs[0], rs[1]
+		before1, before2 := r1.Rating, r2.Rating
+
+		if m.Player1score > m.Player2score {
+			ts.RateTeams([]*Rating{r1}, []*Rating{r2}, weight)
+		} else {
+			ts.RateTeams([]*Rating{r2}, []*Rating{r1}, weight)
+		}
+
+		for _, r := range rs {
+			r.Matches++
+			r.LastMatch = m.Date
+		}
+		changes = append(changes,
+			newRatingChange(r1, m, m.Player2, before1),
+			newRatingChange(r2, m, m.Player1, before2))
+	}
+	return changes
+}
+
+// Predict gives the chance of winning a set, since that's all TrueSkill
+// rates.
+func (ts *TrueSkill) Predict(r1 *Rating, r2 *Rating) (float64, bool) {
+	c := math.Sqrt(2*ts.beta*ts.beta + r1.Deviation*r1.Deviation + r2.Deviation*r2.Deviation)
+	return math.Erfc(-(r1.Mean-r2.Mean)/c/math.Sqrt2) / 2, false
+}
+
+// RateTeams updates everyone on both teams after winners beat losers. A
+// team's skill is the sum of its players' skills. The weight scales how far
+// the result moves each player, but a weight above 1 doesn't make anyone
+// more certain than one match would, or a heavy enough match could leave a
+// player's deviation at 0 and their rating stuck.
+func (ts *TrueSkill) RateTeams(winners []*Rating, losers []*Rating, weight float64) {
+	var winMu, loseMu, c2 float64
+	for _, r := range winners {
+		winMu += r.Mean
+		c2 += r.Deviation*r.Deviation + ts.tau*ts.tau + ts.beta*ts.beta
+	}
+	for _, r := range losers {
+		loseMu += r.Mean
+		c2 += r.Deviation*r.Deviation + ts.tau*ts.tau + ts.beta*ts.beta
+	}
+	c := math.Sqrt(c2)
+
+	t := (winMu - loseMu) / c
+	v := trueSkillV(t)
+	w := v * (v + t)
+	v *= weight
+	w *= math.Min(weight, 1)
+
+	update := func(team []*Rating, sign float64) {
+		for _, r := range team {
+			s2 := r.Deviation*r.Deviation + ts.tau*ts.tau
+			r.Mean += sign * s2 / c * v
+			r.Deviation = math.Sqrt(s2 * math.Max(1-s2/c2*w, 0))
+			r.Rating = round(r.Mean)
+		}
+	}
+	update(winners, 1)
+	update(losers, -1)
+}
+
+// trueSkillV is the mean additive correction for a win, N(t)/Phi(t).
+func trueSkillV(t float64) float64 {
+	pdf := math.Exp(-t*t/2) / math.Sqrt(2*math.Pi)
+	cdf := math.Erfc(-t/math.Sqrt2) / 2
+	if cdf < 1e-160 {
+		// N(t)/Phi(t) tends to -t for very unlikely wins.
+		return -t
+	}
+	return pdf / cdf
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestTrueSkillHeavyMatchKeepsDeviation(t *testing.T) {
+	ts := newTrueSkill(0, matchRules{})
+	r1, r2 := ts.NewRating("gt", "p1"), ts.NewRating("gt", "p2")
+	ts.RateTeams([]*Rating{r1}, []*Rating{r2}, 5)
+	if r1.Deviation <= 0 || r2.Deviation <= 0 {
+		t.Fatalf("deviations should stay above 0, got %v and %v", r1.Deviation, r2.Deviation)
+	}
+
+	// The loser can still climb back
+	before := r2.Mean
+	ts.RateTeams([]*Rating{r2}, []*Rating{r1}, 1)
+	if r2.Mean <= before {
+		t.Errorf("a win should raise the rating from %v, got %v", before, r2.Mean)
+	}
+}
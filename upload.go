@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dguenther/go-bracket"
+	"github.com/jasonwinn/geocoder"
+	"gopkg.in/dancannon/gorethink.v2/types"
+)
+
+// maxBracketUpload is the largest bracket file that can be uploaded.
+const maxBracketUpload = 10 << 20
+
+// UploadedBracket is a bracket file uploaded for a tournament that isn't on a
+// bracket site. It's stored as go-bracket's types in JSON, so importing it
+// works the same as importing a live bracket.
+type UploadedBracket struct {
+	// ID is the ID of the tournament the bracket was uploaded for.
+	ID         string    `gorethink:"id"`
+	Data       string    `gorethink:"data"`
+	UploadedAt time.Time `gorethink:"uploaded_at"`
+}
+
+// tournamentBracket fetches a tournament's bracket from its bracket site. If it
+// doesn't have a bracket URL, it's either run in velvetdb or was uploaded.
+func tournamentBracket(t *Tournament) *bracket.Bracket {
+	if t.BracketURL != "" {
+		b, err := fetchExternalBracket(t.BracketURL)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return b
+	}
+	if nb, err := dataStore.FetchNativeBracket(t.ID); err == nil {
+		return nb.bracket(t.DateStart)
+	}
+	ub, err := dataStore.FetchUploadedBracket(t.ID)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	var b bracket.Bracket
+	if err := json.Unmarshal([]byte(ub.Data), &b); err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return &b
+}
+
+// challongeExport is a tournament as Challonge's API returns it with its
+// participants and matches included, which is what its JSON export is.
+type challongeExport struct {
+	Tournament struct {
+		Name         string     `json:"name"`
+		State        string     `json:"state"`
+		StartedAt    *time.Time `json:"started_at"`
+		CompletedAt  *time.Time `json:"completed_at"`
+		Participants []struct {
+			Participant challongeExportParticipant `json:"participant"`
+		} `json:"participants"`
+		Matches []struct {
+			Match challongeExportMatch `json:"match"`
+		} `json:"matches"`
+	} `json:"tournament"`
+}
+
+type challongeExportParticipant struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Seed        int    `json:"seed"`
+	FinalRank   *int   `json:"final_rank"`
+	// GroupPlayerIDs are the IDs the participant has in group stage matches.
+	GroupPlayerIDs []int64 `json:"group_player_ids"`
+}
+
+type challongeExportMatch struct {
+	ID                   int64      `json:"id"`
+	State                string     `json:"state"`
+	Round                int        `json:"round"`
+	Player1ID            *int64     `json:"player1_id"`
+	Player2ID            *int64     `json:"player2_id"`
+	Player1PrereqMatchID *int64     `json:"player1_prereq_match_id"`
+	Player2PrereqMatchID *int64     `json:"player2_prereq_match_id"`
+	WinnerID             *int64     `json:"winner_id"`
+	ScoresCSV            string     `json:"scores_csv"`
+	UpdatedAt            *time.Time `json:"updated_at"`
+	CompletedAt          *time.Time `json:"completed_at"`
+}
+
+// challongeSetScore is one set of a Challonge scores_csv, like "3-1" or
+// "-1-0" for a DQ.
+var challongeSetScore = regexp.MustCompile(`^(-?\d+)-(-?\d+)$`)
+
+// readChallongeScores reads a match's scores_csv. A match reported set by set
+// is scored by sets won.
+func readChallongeScores(scores string) (int, int, error) {
+	sets := strings.Split(scores, ",")
+	p1, p2 := 0, 0
+	for _, set := range sets {
+		parts := challongeSetScore.FindStringSubmatch(strings.TrimSpace(set))
+		if parts == nil {
+			return 0, 0, fmt.Errorf("couldn't read score %q", scores)
+		}
+		s1, _ := strconv.Atoi(parts[1])
+		s2, _ := strconv.Atoi(parts[2])
+		if len(sets) == 1 {
+			p1, p2 = s1, s2
+		} else if s1 > s2 {
+			p1++
+		} else if s2 > s1 {
+			p2++
+		}
+	}
+	// DQs are scored -1
+	if p1 < 0 {
+		p1 = 0
+	}
+	if p2 < 0 {
+		p2 = 0
+	}
+	return p1, p2, nil
+}
+
+// readChallongeExport reads a bracket from a Challonge JSON export. Matches
+// without a date get the tournament's, and the tournament gets date if it
+// doesn't have one either.
+func readChallongeExport(data []byte, date time.Time) (*bracket.Bracket, string, error) {
+	var export challongeExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, "", err
+	}
+	ct := export.Tournament
+
+	started := date
+	if ct.StartedAt != nil {
+		started = *ct.StartedAt
+	}
+	completed := started
+	if ct.CompletedAt != nil {
+		completed = *ct.CompletedAt
+	}
+	if started.IsZero() {
+		return nil, "", errors.New("the bracket hasn't got a date, so one has to be entered")
+	}
+	b := &bracket.Bracket{
+		State:     ct.State,
+		StartedAt: &started,
+		UpdatedAt: &completed,
+		Players:   []*bracket.Player{},
+		Matches:   []*bracket.Match{},
+	}
+
+	ids := map[int64]string{}
+	for _, cp := range ct.Participants {
+		p := cp.Participant
+		id := strconv.FormatInt(p.ID, 10)
+		ids[p.ID] = id
+		for _, gid := range p.GroupPlayerIDs {
+			ids[gid] = id
+		}
+		name := p.Name
+		if name == "" {
+			name = p.DisplayName
+		}
+		rank := 0
+		if p.FinalRank != nil {
+			rank = *p.FinalRank
+		}
+		b.Players = append(b.Players, &bracket.Player{ID: id, Name: name, Seed: p.Seed, Rank: rank})
+	}
+
+	prereq := func(id *int64) *string {
+		if id == nil {
+			return nil
+		}
+		s := strconv.FormatInt(*id, 10)
+		return &s
+	}
+	for _, cm := range ct.Matches {
+		m := cm.Match
+		if m.Player1ID == nil || m.Player2ID == nil {
+			continue
+		}
+		bm := &bracket.Match{
+			ID:                   strconv.FormatInt(m.ID, 10),
+			State:                m.State,
+			Round:                m.Round,
+			Player1ID:            ids[*m.Player1ID],
+			Player2ID:            ids[*m.Player2ID],
+			Player1PrereqMatchID: prereq(m.Player1PrereqMatchID),
+			Player2PrereqMatchID: prereq(m.Player2PrereqMatchID),
+			UpdatedAt:            &completed,
+		}
+		if m.CompletedAt != nil {
+			bm.UpdatedAt = m.CompletedAt
+		} else if m.UpdatedAt != nil {
+			bm.UpdatedAt = m.UpdatedAt
+		}
+		if m.ScoresCSV != "" {
+			var err error
+			bm.Player1Score, bm.Player2Score, err = readChallongeScores(m.ScoresCSV)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		// Matches reported without a score just have a winner
+		if bm.Player1Score == bm.Player2Score && m.WinnerID != nil {
+			if *m.WinnerID == *m.Player1ID {
+				bm.Player1Score++
+			} else if *m.WinnerID == *m.Player2ID {
+				bm.Player2Score++
+			}
+		}
+		b.Matches = append(b.Matches, bm)
+	}
+	return b, ct.Name, nil
+}
+
+// readBracketCSV reads a bracket from a CSV with a row for each match: its
+// round, both players, both scores and optionally a placement. Losers bracket
+// rounds are negative, as on Challonge. A match's placement is where its loser
+// finished, and the winner of the match for 2nd finishes 1st. Placings can
+// also be given as rows of "place", the player, their placing and optionally
+// their seed. Every match is dated date.
+func readBracketCSV(r io.Reader, date time.Time) (*bracket.Bracket, error) {
+	if date.IsZero() {
+		return nil, errors.New("a bracket from a CSV needs a date")
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bracket.Bracket{
+		State:     "complete",
+		StartedAt: &date,
+		UpdatedAt: &date,
+		Players:   []*bracket.Player{},
+		Matches:   []*bracket.Match{},
+	}
+	players := map[string]*bracket.Player{}
+	player := func(name string) *bracket.Player {
+		handle := normalizeHandle(name)
+		p, ok := players[handle]
+		if !ok {
+			p = &bracket.Player{ID: strconv.Itoa(len(players) + 1), Name: strings.TrimSpace(name)}
+			players[handle] = p
+			b.Players = append(b.Players, p)
+		}
+		return p
+	}
+	place := func(p *bracket.Player, rank int, line int) error {
+		if rank == 0 {
+			return nil
+		}
+		if p.Rank != 0 && p.Rank != rank {
+			return fmt.Errorf("line %d: %s is already placed %d", line, p.Name, p.Rank)
+		}
+		p.Rank = rank
+		return nil
+	}
+	number := func(row []string, i int, line int) (int, error) {
+		if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(row[i]))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %q isn't a number", line, row[i])
+		}
+		return n, nil
+	}
+
+	for i, row := range rows {
+		line := i + 1
+		first := strings.ToLower(strings.TrimSpace(row[0]))
+		if first == "" || (i == 0 && first == "round") {
+			continue
+		}
+		if first == "place" {
+			if len(row) < 3 || strings.TrimSpace(row[1]) == "" {
+				return nil, fmt.Errorf("line %d: placings need a player and a placing", line)
+			}
+			p := player(row[1])
+			rank, err := number(row, 2, line)
+			if err != nil {
+				return nil, err
+			}
+			if err := place(p, rank, line); err != nil {
+				return nil, err
+			}
+			if p.Seed, err = number(row, 3, line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(row) < 5 || strings.TrimSpace(row[1]) == "" || strings.TrimSpace(row[2]) == "" {
+			return nil, fmt.Errorf("line %d: matches need a round, two players and two scores", line)
+		}
+		p1, p2 := player(row[1]), player(row[2])
+		m := &bracket.Match{
+			ID:        strconv.Itoa(len(b.Matches) + 1),
+			State:     "complete",
+			Player1ID: p1.ID,
+			Player2ID: p2.ID,
+			UpdatedAt: &date,
+		}
+		if m.Round, err = number(row, 0, line); err != nil {
+			return nil, err
+		}
+		if m.Player1Score, err = number(row, 3, line); err != nil {
+			return nil, err
+		}
+		if m.Player2Score, err = number(row, 4, line); err != nil {
+			return nil, err
+		}
+		rank, err := number(row, 5, line)
+		if err != nil {
+			return nil, err
+		}
+		if rank > 0 {
+			winner, loser := p1, p2
+			if m.Player2Score > m.Player1Score {
+				winner, loser = p2, p1
+			} else if m.Player1Score == m.Player2Score {
+				return nil, fmt.Errorf("line %d: a match needs a winner to place its loser", line)
+			}
+			if err := place(loser, rank, line); err != nil {
+				return nil, err
+			}
+			if rank == 2 {
+				if err := place(winner, 1, line); err != nil {
+					return nil, err
+				}
+			}
+		}
+		b.Matches = append(b.Matches, m)
+	}
+	return b, nil
+}
+
+// readUploadedBracket reads a bracket file, by its extension or else by
+// whether it looks like JSON. It also returns the tournament's name if the
+// file has one.
+func readUploadedBracket(filename string, data []byte, date time.Time) (*bracket.Bracket, string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".json" || (ext != ".csv" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))) {
+		return readChallongeExport(data, date)
+	}
+	b, err := readBracketCSV(bytes.NewReader(data), date)
+	return b, "", err
+}
+
+func uploadTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		GameTypes []GameType
+	}{
+		dataStore.FetchGameTypes(),
+	}
+	renderTemplate(w, r, "uploadTournament", data)
+}
+
+func saveUploadTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxBracketUpload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("bracket")
+	if err != nil {
+		http.Error(w, "No bracket file was uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var date time.Time
+	if d := r.FormValue("date"); d != "" {
+		date, err = time.Parse("2006-01-02", d)
+		if err != nil {
+			http.Error(w, "Dates should look like 2016-05-01", http.StatusBadRequest)
+			return
+		}
+	}
+	b, name, err := readUploadedBracket(header.Filename, contents, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(b.Players) == 0 {
+		http.Error(w, "The bracket hasn't got any participants", http.StatusBadRequest)
+		return
+	}
+	if n := strings.TrimSpace(r.FormValue("name")); n != "" {
+		name = n
+	}
+	if name == "" {
+		http.Error(w, "The tournament needs a name", http.StatusBadRequest)
+		return
+	}
+
+	city := r.FormValue("city")
+	state := r.FormValue("state")
+	point := types.Point{}
+	if city != "" && state != "" {
+		geocoder.SetAPIKey(siteConfiguration.MapquestApiKey)
+		lat, lng, err := geocoder.Geocode(city + "," + state)
+		if err == nil {
+			point.Lat = lat
+			point.Lon = lng
+		}
+	}
+
+	id := dataStore.AddTournament(Tournament{
+		Name:        name,
+		GameType:    r.FormValue("gametype"),
+		DateStart:   *b.StartedAt,
+		DateEnd:     *b.UpdatedAt,
+		PlayerCount: len(b.Players),
+		City:        city,
+		State:       state,
+		Location:    point,
+		Editing:     true,
+	})
+	encoded, err := json.Marshal(b)
+	if err == nil {
+		err = dataStore.SaveUploadedBracket(&UploadedBracket{
+			ID:         id,
+			Data:       string(encoded),
+			UploadedAt: time.Now(),
+		})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tournament/"+id, http.StatusFound)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadBracketCSVPlacements(t *testing.T) {
+	csv := `round,player 1,player 2,score 1,score 2,placement
+1,Alice,Bob,2,1
+1,Carol,Dave,2,0
+-1,Bob,Dave,2,0,4
+2,Alice,Carol,2,1
+-2,Carol,Bob,2,1,3
+3,Alice,Carol,3,2,2
+place,Alice,1,1
+place,Carol,2,2
+`
+	b, err := readBracketCSV(strings.NewReader(csv), time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Matches) != 6 {
+		t.Errorf("got %d matches, want 6", len(b.Matches))
+	}
+	want := map[string][2]int{"Alice": {1, 1}, "Carol": {2, 2}, "Bob": {3, 0}, "Dave": {4, 0}}
+	if len(b.Players) != len(want) {
+		t.Fatalf("got %d players, want %d", len(b.Players), len(want))
+	}
+	for _, p := range b.Players {
+		if w := want[p.Name]; p.Rank != w[0] || p.Seed != w[1] {
+			t.Errorf("%s is placed %d and seeded %d, want %d and %d", p.Name, p.Rank, p.Seed, w[0], w[1])
+		}
+	}
+}
+
+func TestReadBracketCSVErrors(t *testing.T) {
+	date := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		csv  string
+		want string
+	}{
+		{"1,Alice,Bob,2", "line 1: matches need"},
+		{"1,Alice,Bob,two,1", `line 1: "two" isn't a number`},
+		{"1,Alice,Bob,2,1,second", `line 1: "second" isn't a number`},
+		{"1,Alice,Bob,1,1,2", "line 1: a match needs a winner"},
+		{"1,Alice,Bob,2,1,2\nplace,Bob,3", "line 2: Bob is already placed 2"},
+		{"place,Alice", "line 1: placings need"},
+	}
+	for _, test := range tests {
+		_, err := readBracketCSV(strings.NewReader(test.csv), date)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("reading %q: got error %v, want %q", test.csv, err, test.want)
+		}
+	}
+	if _, err := readBracketCSV(strings.NewReader("1,Alice,Bob,2,1"), time.Time{}); err == nil {
+		t.Errorf("a CSV without a date should be rejected")
+	}
+}
+
+func TestUploadNeedsName(t *testing.T) {
+	srv, client := testSite(t)
+	defer srv.Close()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("bracket", "weekly.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("1,Alice,Bob,2,1,2\n"))
+	mw.WriteField("date", "2016-05-01")
+	mw.Close()
+
+	resp, err := client.Post(srv.URL+"/save/uploadtournament", mw.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("uploading a CSV without a name: got %s, want 400", resp.Status)
+	}
+	if ts, _ := dataStore.FetchTournaments("", true); ts != nil && len(*ts) > 0 {
+		t.Errorf("no tournament should be added, got %d", len(*ts))
+	}
+}
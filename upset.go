@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// upsetMinRating is how far below the loser a winner has to be rated for
+	// the win to count as an upset by rating alone.
+	upsetMinRating = 50
+	// tournamentUpsetsShown is how many upsets the tournament page lists.
+	tournamentUpsetsShown = 5
+	// upsetsFeedSize is how many upsets the feed shows.
+	upsetsFeedSize = 50
+)
+
+// Winner returns the player who won the match, or "" for a draw.
+func (m *Match) Winner() string {
+	if m.Player1score > m.Player2score {
+		return m.Player1
+	} else if m.Player2score > m.Player1score {
+		return m.Player2
+	}
+	return ""
+}
+
+// Loser returns the player who lost the match, or "" for a draw.
+func (m *Match) Loser() string {
+	if m.Player1score > m.Player2score {
+		return m.Player2
+	} else if m.Player2score > m.Player1score {
+		return m.Player1
+	}
+	return ""
+}
+
+// IsUpset reports whether the winner was seeded or rated well enough below
+// the loser for the match to be called an upset.
+func (m *Match) IsUpset() bool {
+	return m.UpsetSeed > 0 || m.UpsetRating >= upsetMinRating
+}
+
+// ByUpset sorts the biggest upsets first: by seed, then by rating.
+type ByUpset []Match
+
+func (a ByUpset) Len() int      { return len(a) }
+func (a ByUpset) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByUpset) Less(i, j int) bool {
+	if a[i].UpsetSeed != a[j].UpsetSeed {
+		return a[i].UpsetSeed > a[j].UpsetSeed
+	}
+	return a[i].UpsetRating > a[j].UpsetRating
+}
+
+// placementRound is how many rounds of placements sit above a seed or place in
+// a double elimination bracket, where the placements go 1, 2, 3, 4, 5, 7, 9,
+// 13, 17, 25 and so on.
+func placementRound(n int) int {
+	if n < 3 {
+		return n - 1
+	}
+	round, place, step := 2, 3, 1
+	for {
+		for i := 0; i < 2; i++ {
+			if n < place+step {
+				return round
+			}
+			place += step
+			round++
+		}
+		step *= 2
+	}
+}
+
+// annotateUpsets sets the upset factors of a tournament's matches. The rating
+// factor is how many points the winner was rated below the loser when the
+// tournament started, and the seed factor is how many placement rounds the
+// winner was seeded below the loser. Both are 0 when the favourite won.
+func annotateUpsets(t *Tournament, matches []*Match, seeds map[string]int) {
+	engine := fetchRatingEngine(t.GameType)
+	ratings := map[string]int{}
+	ratingOf := func(player string) int {
+		if _, ok := ratings[player]; !ok {
+			ratings[player] = ratingBefore(engine, t, player)
+		}
+		return ratings[player]
+	}
+	for _, m := range matches {
+		setUpsetFactors(m, ratingOf, seeds)
+	}
+}
+
+func setUpsetFactors(m *Match, ratingOf func(player string) int, seeds map[string]int) {
+	winner, loser := m.Winner(), m.Loser()
+	m.UpsetRating, m.UpsetSeed = 0, 0
+	if winner == "" {
+		return
+	}
+	if diff := ratingOf(loser) - ratingOf(winner); diff > 0 {
+		m.UpsetRating = diff
+	}
+	if seeds[winner] > 0 && seeds[loser] > 0 {
+		if diff := placementRound(seeds[winner]) - placementRound(seeds[loser]); diff > 0 {
+			m.UpsetSeed = diff
+		}
+	}
+}
+
+// backfillUpsets sets the upset factors of a game type's tournament matches
+// again from its rebuilt rating changes and the stored seeds, so matches
+// imported before upsets were tracked get them, and earlier results changing
+// doesn't leave them stale. Only a root tournament's results store seeds, so
+// pool matches keep the seed factor they were imported with.
+func backfillUpsets(gameType string, changes []*RatingChange) {
+	sorted := append([]*RatingChange{}, changes...)
+	sort.Sort(ByRatingMatches(sorted))
+	histories := map[string]*RatingHistory{}
+	for _, c := range sorted {
+		h, ok := histories[c.Player]
+		if !ok {
+			h = &RatingHistory{Player: c.Player, GameType: gameType, Start: c.Before}
+			histories[c.Player] = h
+		}
+		h.Timeline = append(h.Timeline, c)
+	}
+
+	tournaments := []string{}
+	byTournament := map[string][]*Match{}
+	matches := dataStore.FetchMatchesForGameType(gameType, true)
+	for i := range matches {
+		m := &matches[i]
+		if m.Tournament == "" {
+			continue
+		}
+		if _, ok := byTournament[m.Tournament]; !ok {
+			tournaments = append(tournaments, m.Tournament)
+		}
+		byTournament[m.Tournament] = append(byTournament[m.Tournament], m)
+	}
+
+	engine := fetchRatingEngine(gameType)
+	for _, id := range tournaments {
+		t, err := dataStore.FetchTournament(id)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		ratingOf := func(player string) int {
+			if h, ok := histories[player]; ok {
+				return h.RatingAt(t.DateStart.Add(-time.Nanosecond))
+			}
+			return engine.NewRating(gameType, player).Rating
+		}
+		seeds := map[string]int{}
+		if t.PoolOf == "" {
+			results, err := dataStore.FetchResultsForTournament(t.ID)
+			if err != nil {
+				fmt.Println(err)
+			}
+			for _, r := range results {
+				seeds[r.Player] = r.Seed
+			}
+		}
+
+		for _, m := range byTournament[id] {
+			rating, seed := m.UpsetRating, m.UpsetSeed
+			setUpsetFactors(m, ratingOf, seeds)
+			if t.PoolOf != "" {
+				m.UpsetSeed = seed
+			}
+			if m.UpsetRating == rating && m.UpsetSeed == seed {
+				continue
+			}
+			if err := dataStore.UpdateMatch(m); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// upsets returns the visible matches that were upsets, biggest first.
+func upsets(matches []Match) []Match {
+	found := []Match{}
+	for _, m := range matches {
+		if !m.Hidden && m.IsUpset() {
+			found = append(found, m)
+		}
+	}
+	sort.Sort(ByUpset(found))
+	return found
+}
+
+// fetchUpsetsFeed returns a game type's most recent upsets.
+func fetchUpsetsFeed(gt *GameType) []Match {
+	found := upsets(dataStore.FetchMatchesForGameType(gt.ID, false))
+	sort.Sort(sort.Reverse(ByDate(found)))
+	if len(found) > upsetsFeedSize {
+		found = found[:upsetsFeedSize]
+	}
+	return found
+}
+
+// UpsetCount is how many upsets a player has caused and suffered in a game
+// type.
+type UpsetCount struct {
+	Caused   int
+	Suffered int
+}
+
+// countUpsets counts a player's upsets by game type.
+func countUpsets(player string, matches []Match) map[string]*UpsetCount {
+	counts := map[string]*UpsetCount{}
+	for i := range matches {
+		m := &matches[i]
+		if m.Hidden || !m.IsUpset() {
+			continue
+		}
+		c, ok := counts[m.GameType]
+		if !ok {
+			c = &UpsetCount{}
+			counts[m.GameType] = c
+		}
+		if m.Winner() == player {
+			c.Caused++
+		} else {
+			c.Suffered++
+		}
+	}
+	return counts
+}
+
+func upsetsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gt, err := dataStore.FetchGameTypeByURLPath(vars["gametype"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	upsets := fetchUpsetsFeed(gt)
+	playerMap := make(map[string]Player)
+	for _, p := range dataStore.FetchPlayers() {
+		playerMap[p.ID] = p
+	}
+	tournamentMap := map[string]*Tournament{}
+	for _, m := range upsets {
+		if _, ok := tournamentMap[m.Tournament]; ok || m.Tournament == "" {
+			continue
+		}
+		if t, err := dataStore.FetchTournament(m.Tournament); err == nil {
+			tournamentMap[m.Tournament] = t
+		}
+	}
+
+	data := struct {
+		GameType      *GameType
+		Upsets        []Match
+		PlayerMap     map[string]Player
+		TournamentMap map[string]*Tournament
+	}{
+		gt,
+		upsets,
+		playerMap,
+		tournamentMap,
+	}
+	renderTemplate(w, r, "upsets", data)
+}
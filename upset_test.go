@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRebuildRatingsBackfillsUpsets(t *testing.T) {
+	dataStore = newMemoryDataStore()
+	gt := GameType{Name: "Melee", URLPath: "melee", TournamentWeight: 1}
+	gt.ID = dataStore.AddGameType(gt)
+	alice := dataStore.AddPlayer(Player{Nickname: "Alice"})
+	bob := dataStore.AddPlayer(Player{Nickname: "Bob"})
+
+	// Alice is rated well above Bob by the time the second tournament starts
+	first := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	second := first.AddDate(0, 1, 0)
+	firstID := dataStore.AddTournament(Tournament{GameType: gt.ID, DateStart: first})
+	secondID := dataStore.AddTournament(Tournament{GameType: gt.ID, DateStart: second})
+	matches := []*Match{}
+	for i := 0; i < 3; i++ {
+		matches = append(matches, &Match{
+			GameType: gt.ID, Tournament: firstID, Date: first.Add(time.Duration(i) * time.Hour),
+			Player1: alice, Player2: bob, Player1score: 3, Player2score: 0,
+		})
+	}
+	matches = append(matches, &Match{
+		GameType: gt.ID, Tournament: secondID, Date: second,
+		Player1: alice, Player2: bob, Player1score: 1, Player2score: 3,
+	})
+	if err := dataStore.AddMatches(matches); err != nil {
+		t.Fatal(err)
+	}
+	dataStore.AddTournamentResults([]*TournamentResult{
+		{TournamentID: secondID, Player: alice, Seed: 1},
+		{TournamentID: secondID, Player: bob, Seed: 5},
+	})
+
+	rebuildRatings(gt.ID)
+	for _, m := range dataStore.FetchMatchesForTournament(firstID, true) {
+		if m.IsUpset() {
+			t.Errorf("Alice's wins in the first tournament weren't upsets: %+v", m)
+		}
+	}
+	upset := dataStore.FetchMatchesForTournament(secondID, true)[0]
+	if upset.UpsetRating < upsetMinRating {
+		t.Errorf("Bob was rated below Alice, got an upset rating of %d", upset.UpsetRating)
+	}
+	if upset.UpsetSeed != 4 {
+		t.Errorf("Bob was seeded 4 placement rounds below Alice, got %d", upset.UpsetSeed)
+	}
+}